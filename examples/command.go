@@ -2,7 +2,12 @@ package main
 
 import (
 	"fmt"
+	_ "github.com/conductant/gohm/pkg/cache"
 	"github.com/conductant/gohm/pkg/command"
+	_ "github.com/conductant/gohm/pkg/file"
+	_ "github.com/conductant/gohm/pkg/mem"
+	_ "github.com/conductant/gohm/pkg/ns"
+	_ "github.com/conductant/gohm/pkg/nsgw"
 	"github.com/conductant/gohm/pkg/runtime"
 	_ "github.com/conductant/gohm/pkg/version"
 	"github.com/golang/glog"
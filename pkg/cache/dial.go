@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	net "net/url"
+	"strconv"
+	"sync"
+)
+
+func init() {
+	namespace.RegisterDecorator(Decorate)
+}
+
+var (
+	// Caches shared by the registries returned by Dial, by the registry they wrap.
+	sharedLock sync.Mutex
+	shared     = map[namespace.Registry]*sharedCache{}
+)
+
+type sharedCache struct {
+	cache *Registry
+	refs  int
+}
+
+// Makes Dial return cached registries for urls with cache=true, e.g. zk://host:2181/?cache=true, so that
+// the get, exists and list template functions read from the cache.  Registered when this package is
+// linked in.  All the urls dialed with the option for the same registry share one cache.
+func Decorate(url net.URL, reg namespace.Registry) namespace.Registry {
+	v := url.Query().Get("cache")
+	if v == "" {
+		return reg
+	}
+	if on, err := strconv.ParseBool(v); err != nil {
+		glog.Warningln("Not caching, bad option cache=", v)
+		return reg
+	} else if !on {
+		return reg
+	}
+	sharedLock.Lock()
+	defer sharedLock.Unlock()
+	s, has := shared[reg]
+	if !has {
+		s = &sharedCache{cache: New(reg)}
+		shared[reg] = s
+	}
+	s.refs++
+	return &dialed{Registry: s.cache, key: reg}
+}
+
+// A registry returned by Dial, sharing its cache with the other ones for the same registry.
+type dialed struct {
+	*Registry
+	key namespace.Registry
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// Closes the registry, as each Dial needs, and stops the triggers of the cache with the last one.
+func (this *dialed) Close() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	sharedLock.Lock()
+	s := shared[this.key]
+	s.refs--
+	if s.refs == 0 {
+		delete(shared, this.key)
+	}
+	sharedLock.Unlock()
+	if s.refs == 0 {
+		return s.cache.Close()
+	}
+	return this.key.Close()
+}
@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"sync"
+	"sync/atomic"
+)
+
+// Cache statistics.  Invalidations counts the entries dropped because of triggers or local writes.
+type Stats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+}
+
+// A cached result, with the triggers that invalidate it.
+type entry struct {
+	value   []byte
+	version namespace.Version
+	list    []namespace.Path
+	err     error // ErrNotExist is cached too
+	stop    context.CancelFunc
+	stale   bool // invalidated, possibly before it was cached
+}
+
+// Read-through cache around a Registry.  Get, Exists and List are served from the cache.  A cached node
+// is invalidated by its Create, Change and Delete triggers, and a cached list by the Members trigger of
+// the parent.  Writes through the cache invalidate the entries right away, so the caller reads its own
+// writes.  Everything else goes to the registry as is.
+type Registry struct {
+	namespace.Registry
+
+	lock   sync.Mutex
+	nodes  map[string]*entry
+	lists  map[string]*entry
+	hits   int64
+	misses int64
+	invals int64
+}
+
+func New(reg namespace.Registry) *Registry {
+	return &Registry{
+		Registry: reg,
+		nodes:    map[string]*entry{},
+		lists:    map[string]*entry{},
+	}
+}
+
+func (this *Registry) Stats() Stats {
+	return Stats{
+		Hits:          atomic.LoadInt64(&this.hits),
+		Misses:        atomic.LoadInt64(&this.misses),
+		Invalidations: atomic.LoadInt64(&this.invals),
+	}
+}
+
+// Sets the triggers and calls the invalidate function on the first event from any of them.
+// Returns the function that stops the triggers.
+func (this *Registry) watch(triggers []namespace.Trigger, invalidate func()) (context.CancelFunc, error) {
+	ctx, stop := context.WithCancel(context.Background())
+	events := []<-chan namespace.Event{}
+	for _, t := range triggers {
+		e, err := this.Registry.Trigger(ctx, t)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	for _, e := range events {
+		go func(e <-chan namespace.Event) {
+			<-e
+			invalidate()
+		}(e)
+	}
+	return stop, nil
+}
+
+// Drops the entry if it's still the one cached for the key.  A nil entry drops whatever is cached.
+func (this *Registry) drop(m map[string]*entry, key string, e *entry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if e != nil {
+		e.stale = true
+	}
+	if current, has := m[key]; has && (e == nil || current == e) {
+		delete(m, key)
+		atomic.AddInt64(&this.invals, 1)
+		current.stop()
+	}
+}
+
+func (this *Registry) getNode(key namespace.Path) *entry {
+	k := key.String()
+	this.lock.Lock()
+	e, has := this.nodes[k]
+	this.lock.Unlock()
+	if has {
+		atomic.AddInt64(&this.hits, 1)
+		return e
+	}
+	atomic.AddInt64(&this.misses, 1)
+
+	e = &entry{}
+	// The triggers are set before reading so that no change is missed.
+	stop, err := this.watch([]namespace.Trigger{
+		namespace.Create{Path: key}, namespace.Change{Path: key}, namespace.Delete{Path: key}},
+		func() { this.drop(this.nodes, k, e) })
+	e.value, e.version, e.err = this.Registry.Get(key)
+	if err != nil {
+		glog.Warningln("Cannot watch", key, "not caching. err=", err)
+		return e
+	}
+	if e.err != nil && e.err != namespace.ErrNotExist {
+		stop()
+		return e
+	}
+	e.stop = stop
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if current, has := this.nodes[k]; has {
+		stop() // Someone else cached it in the meantime.
+		return current
+	}
+	if e.stale {
+		stop() // Changed while being read.  Not cached, but the value read is as good as any.
+		return e
+	}
+	this.nodes[k] = e
+	return e
+}
+
+func (this *Registry) Get(key namespace.Path) ([]byte, namespace.Version, error) {
+	e := this.getNode(key)
+	if e.err != nil {
+		return nil, namespace.InvalidVersion, e.err
+	}
+	value := make([]byte, len(e.value))
+	copy(value, e.value)
+	return value, e.version, nil
+}
+
+func (this *Registry) Exists(key namespace.Path) (bool, error) {
+	e := this.getNode(key)
+	switch e.err {
+	case nil:
+		return true, nil
+	case namespace.ErrNotExist:
+		return false, nil
+	default:
+		return false, e.err
+	}
+}
+
+func (this *Registry) List(key namespace.Path) ([]namespace.Path, error) {
+	k := key.String()
+	this.lock.Lock()
+	e, has := this.lists[k]
+	this.lock.Unlock()
+	if has {
+		atomic.AddInt64(&this.hits, 1)
+		return append([]namespace.Path{}, e.list...), nil
+	}
+	atomic.AddInt64(&this.misses, 1)
+
+	e = &entry{}
+	stop, err := this.watch([]namespace.Trigger{namespace.Members{Path: key}}, func() { this.drop(this.lists, k, e) })
+	e.list, e.err = this.Registry.List(key)
+	if err != nil || e.err != nil {
+		if err == nil {
+			stop()
+		}
+		return e.list, e.err
+	}
+	e.stop = stop
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, has := this.lists[k]; has || e.stale {
+		stop()
+	} else {
+		this.lists[k] = e
+	}
+	return append([]namespace.Path{}, e.list...), nil
+}
+
+// Drops the node and the list of its parent.
+func (this *Registry) invalidate(key namespace.Path) {
+	this.drop(this.nodes, key.String(), nil)
+	this.drop(this.lists, key.String(), nil)
+	this.drop(this.lists, key.Dir().String(), nil)
+}
+
+func (this *Registry) Put(key namespace.Path, value []byte, ephemeral bool) (namespace.Version, error) {
+	defer this.invalidate(key)
+	return this.Registry.Put(key, value, ephemeral)
+}
+
+func (this *Registry) PutVersion(key namespace.Path, value []byte, version namespace.Version) (namespace.Version, error) {
+	defer this.invalidate(key)
+	return this.Registry.PutVersion(key, value, version)
+}
+
+func (this *Registry) Delete(key namespace.Path) error {
+	defer this.invalidate(key)
+	return this.Registry.Delete(key)
+}
+
+func (this *Registry) DeleteVersion(key namespace.Path, version namespace.Version) error {
+	defer this.invalidate(key)
+	return this.Registry.DeleteVersion(key, version)
+}
+
+func (this *Registry) Txn(ops ...namespace.Op) ([]namespace.OpResult, error) {
+	defer func() {
+		for _, op := range ops {
+			this.invalidate(namespace.PathOf(op))
+		}
+	}()
+	return this.Registry.Txn(ops...)
+}
+
+// Stops all the triggers and closes the registry.
+func (this *Registry) Close() error {
+	this.lock.Lock()
+	for _, m := range []map[string]*entry{this.nodes, this.lists} {
+		for k, e := range m {
+			e.stop()
+			delete(m, k)
+		}
+	}
+	this.lock.Unlock()
+	return this.Registry.Close()
+}
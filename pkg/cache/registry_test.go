@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/template"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var delay = 200 * time.Millisecond
+
+func TestCache(t *testing.T) { TestingT(t) }
+
+type TestSuiteCache struct{}
+
+var _ = Suite(&TestSuiteCache{})
+
+func (suite *TestSuiteCache) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteCache) TearDownSuite(c *C) {
+}
+
+// Each call returns a separate session on the same in-memory tree.
+func session(c *C) namespace.Registry {
+	reg, err := mem.NewService(context.Background(), net.URL{Scheme: "mem", Host: "cache"}, nil)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+func (suite *TestSuiteCache) TestReadThrough(c *C) {
+	cached := New(session(c))
+	defer cached.Close()
+	other := session(c)
+	defer other.Close()
+
+	p := namespace.NewPath("/unit-test/cache/read")
+	_, err := other.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+
+	for i := 0; i < 3; i++ {
+		value, _, err := cached.Get(p)
+		c.Assert(err, IsNil)
+		c.Assert(value, DeepEquals, []byte("1"))
+		exists, err := cached.Exists(p)
+		c.Assert(err, IsNil)
+		c.Assert(exists, Equals, true)
+	}
+	c.Assert(cached.Stats(), Equals, Stats{Hits: 5, Misses: 1})
+
+	// A change by someone else invalidates through the trigger.
+	_, err = other.Put(p, []byte("2"), false)
+	c.Assert(err, IsNil)
+	time.Sleep(delay)
+	value, _, err := cached.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("2"))
+	c.Assert(cached.Stats().Misses, Equals, int64(2))
+	c.Assert(cached.Stats().Invalidations, Equals, int64(1))
+
+	// Missing nodes are cached too.
+	missing := p.Sub("missing")
+	exists, err := cached.Exists(missing)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	_, _, err = cached.Get(missing)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	c.Assert(cached.Stats().Misses, Equals, int64(3))
+
+	_, err = other.Put(missing, []byte("here"), false)
+	c.Assert(err, IsNil)
+	time.Sleep(delay)
+	exists, err = cached.Exists(missing)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+}
+
+func (suite *TestSuiteCache) TestList(c *C) {
+	cached := New(session(c))
+	defer cached.Close()
+	other := session(c)
+	defer other.Close()
+
+	p := namespace.NewPath("/unit-test/cache/list")
+	_, err := other.Put(p.Sub("a"), []byte{}, false)
+	c.Assert(err, IsNil)
+
+	list, err := cached.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(list, DeepEquals, []namespace.Path{p.Sub("a")})
+	list, err = cached.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(cached.Stats(), Equals, Stats{Hits: 1, Misses: 1})
+
+	_, err = other.Put(p.Sub("b"), []byte{}, false)
+	c.Assert(err, IsNil)
+	time.Sleep(delay)
+	list, err = cached.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 2)
+
+	// Writes through the cache are seen right away.
+	c.Assert(cached.Delete(p.Sub("a")), IsNil)
+	list, err = cached.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(list, DeepEquals, []namespace.Path{p.Sub("b")})
+	_, err = cached.Put(p.Sub("b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+	value, _, err := cached.Get(p.Sub("b"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("b"))
+}
+
+func (suite *TestSuiteCache) TestDial(c *C) {
+	ctx := context.Background()
+	reg, err := namespace.Dial(ctx, "mem://cache-dial/?cache=true")
+	c.Assert(err, IsNil)
+	cached, is := reg.(*dialed)
+	c.Assert(is, Equals, true)
+
+	_, err = reg.Put(namespace.NewPath("/name"), []byte("world"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(namespace.NewPath("/tmpl"), []byte(`hello {{get "mem://cache-dial/name?cache=true"}}`), false)
+	c.Assert(err, IsNil)
+
+	// The template functions dial the same url, and share the cache.
+	for i := 0; i < 3; i++ {
+		applied, err := template.Execute(ctx, "mem://cache-dial/tmpl")
+		c.Assert(err, IsNil)
+		c.Assert(string(applied), Equals, "hello world")
+	}
+	c.Assert(cached.Stats(), Equals, Stats{Hits: 2, Misses: 1, Invalidations: 0})
+
+	// Not cached without the option
+	plain, err := namespace.Dial(ctx, "mem://cache-dial")
+	c.Assert(err, IsNil)
+	_, is = plain.(*dialed)
+	c.Assert(is, Equals, false)
+	c.Assert(plain.Close(), IsNil)
+
+	c.Assert(reg.Close(), IsNil)
+	c.Assert(reg.Close(), IsNil)
+}
+
+// Counts the triggers not stopped, and writes the node before each Get, as if someone else did
+// between setting the triggers and reading.
+type racing struct {
+	namespace.Registry
+	other  namespace.Registry
+	active int32
+}
+
+func (this *racing) Trigger(ctx context.Context, t namespace.Trigger) (<-chan namespace.Event, error) {
+	atomic.AddInt32(&this.active, 1)
+	go func() {
+		<-ctx.Done()
+		atomic.AddInt32(&this.active, -1)
+	}()
+	return this.Registry.Trigger(ctx, t)
+}
+
+func (this *racing) Get(key namespace.Path) ([]byte, namespace.Version, error) {
+	this.other.Put(key, []byte("raced"), false)
+	time.Sleep(delay) // for the trigger to fire
+	return this.Registry.Get(key)
+}
+
+func (suite *TestSuiteCache) TestStaleNotCached(c *C) {
+	reg := &racing{Registry: session(c), other: session(c)}
+	defer reg.other.Close()
+	cached := New(reg)
+	defer cached.Close()
+
+	p := namespace.NewPath("/unit-test/cache/stale")
+	_, err := reg.other.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+	value, _, err := cached.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("raced"))
+
+	// Not cached, and no trigger left behind.
+	c.Assert(len(cached.nodes), Equals, 0)
+	deadline := time.Now().Add(delay)
+	for atomic.LoadInt32(&reg.active) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(atomic.LoadInt32(&reg.active), Equals, int32(0))
+}
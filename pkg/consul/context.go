@@ -0,0 +1,23 @@
+package consul
+
+import (
+	"golang.org/x/net/context"
+	"time"
+)
+
+type waitTimeContextKey int
+
+const (
+	WaitTimeContextKey waitTimeContextKey = 1
+)
+
+func ContextGetWaitTime(ctx context.Context) time.Duration {
+	if v, ok := (ctx.Value(WaitTimeContextKey)).(time.Duration); ok {
+		return v
+	}
+	return DefaultWaitTime
+}
+
+func ContextPutWaitTime(ctx context.Context, t time.Duration) context.Context {
+	return context.WithValue(ctx, WaitTimeContextKey, t)
+}
@@ -0,0 +1,14 @@
+package consul
+
+import (
+	"fmt"
+)
+
+type UnexpectedStatus struct {
+	Status int
+	Body   string
+}
+
+func (this *UnexpectedStatus) Error() string {
+	return fmt.Sprintf("consul-unexpected-status: %d %s", this.Status, this.Body)
+}
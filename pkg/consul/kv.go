@@ -0,0 +1,255 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	net "net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Thin client for the parts of the consul HTTP api used by the registry.
+type kv struct {
+	base   string // http://host:port
+	client *http.Client
+}
+
+func (this *kv) url(endpoint string, params net.Values) string {
+	u := this.base + endpoint
+	if len(params) > 0 {
+		u += "?" + params.Encode()
+	}
+	return u
+}
+
+// Escapes each segment of the key, so that names with ?, # or % are not taken as part of the query.
+func kvPath(key string) string {
+	parts := strings.Split(key, "/")
+	for i, part := range parts {
+		parts[i] = net.PathEscape(part)
+	}
+	return "/v1/kv/" + strings.Join(parts, "/")
+}
+
+func (this *kv) do(method, endpoint string, params net.Values, body io.Reader, cancel <-chan struct{}) (*http.Response, error) {
+	req, err := http.NewRequest(method, this.url(endpoint, params), body)
+	if err != nil {
+		return nil, err
+	}
+	if cancel != nil {
+		req.Cancel = cancel
+	}
+	return this.client.Do(req)
+}
+
+func index(resp *http.Response) uint64 {
+	i, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return i
+}
+
+func blocking(params net.Values, waitIndex uint64, wait time.Duration) net.Values {
+	if waitIndex > 0 {
+		params.Set("index", strconv.FormatUint(waitIndex, 10))
+		params.Set("wait", fmt.Sprintf("%dms", wait/time.Millisecond))
+	}
+	return params
+}
+
+func unexpected(resp *http.Response) error {
+	buff, _ := ioutil.ReadAll(resp.Body)
+	return &UnexpectedStatus{Status: resp.StatusCode, Body: string(buff)}
+}
+
+// Gets the key.  A nil pair is returned if the key does not exist.  If waitIndex is not 0, this is a
+// blocking query that returns when the index changes or the wait time is up.
+func (this *kv) get(key string, waitIndex uint64, wait time.Duration, cancel <-chan struct{}) (*pair, uint64, error) {
+	resp, err := this.do("GET", kvPath(key), blocking(net.Values{}, waitIndex, wait), nil, cancel)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, index(resp), nil
+	default:
+		return nil, 0, unexpected(resp)
+	}
+	pairs := []*pair{}
+	if err := json.NewDecoder(resp.Body).Decode(&pairs); err != nil {
+		return nil, 0, err
+	}
+	if len(pairs) == 0 {
+		return nil, index(resp), nil
+	}
+	if pairs[0].Value == nil {
+		pairs[0].Value = []byte{}
+	}
+	return pairs[0], index(resp), nil
+}
+
+// Lists the keys one level below the prefix.  Keys of deeper levels are returned with trailing separator.
+func (this *kv) keys(prefix string, waitIndex uint64, wait time.Duration, cancel <-chan struct{}) ([]string, uint64, error) {
+	params := blocking(net.Values{"keys": []string{""}, "separator": []string{"/"}}, waitIndex, wait)
+	resp, err := this.do("GET", kvPath(prefix), params, nil, cancel)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return []string{}, index(resp), nil
+	default:
+		return nil, 0, unexpected(resp)
+	}
+	keys := []string{}
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, 0, err
+	}
+	return keys, index(resp), nil
+}
+
+// Lists all the keys under the prefix, at any depth.
+func (this *kv) allKeys(prefix string) ([]string, error) {
+	resp, err := this.do("GET", kvPath(prefix), net.Values{"keys": []string{""}}, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return []string{}, nil
+	default:
+		return nil, unexpected(resp)
+	}
+	keys := []string{}
+	if err := json.NewDecoder(resp.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Sets the key, with optional cas / acquire parameters.  Returns false if the operation was not applied.
+func (this *kv) put(key string, value []byte, params net.Values) (bool, error) {
+	resp, err := this.do("PUT", kvPath(key), params, bytes.NewBuffer(value), nil)
+	if err != nil {
+		return false, err
+	}
+	return result(resp)
+}
+
+func (this *kv) delete(key string, params net.Values) (bool, error) {
+	resp, err := this.do("DELETE", kvPath(key), params, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	return result(resp)
+}
+
+func result(resp *http.Response) (bool, error) {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, unexpected(resp)
+	}
+	buff, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(buff)) == "true", nil
+}
+
+// Applies the operations atomically.  Returns the keys as left by the operations, one for each
+// operation that is not a delete, in order.  If the transaction is rolled back, the errors are
+// returned with a nil error.
+func (this *kv) txn(ops []txnOp) ([]*pair, []txnError, error) {
+	body := []map[string]txnOp{}
+	for _, op := range ops {
+		body = append(body, map[string]txnOp{"KV": op})
+	}
+	buff, err := json.Marshal(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := this.do("PUT", "/v1/txn", nil, bytes.NewBuffer(buff), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusConflict:
+	default:
+		return nil, nil, unexpected(resp)
+	}
+	result := struct {
+		Results []struct{ KV *pair }
+		Errors  []txnError
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode == http.StatusConflict {
+		if len(result.Errors) == 0 {
+			result.Errors = []txnError{{OpIndex: -1, What: "rolled back"}}
+		}
+		return nil, result.Errors, nil
+	}
+	pairs := []*pair{}
+	for _, r := range result.Results {
+		pairs = append(pairs, r.KV)
+	}
+	return pairs, nil, nil
+}
+
+// Creates a session whose keys are deleted when the session is invalidated.
+func (this *kv) createSession(name string, ttl time.Duration) (string, error) {
+	body, _ := json.Marshal(map[string]string{
+		"Name":      name,
+		"TTL":       fmt.Sprintf("%ds", int(ttl/time.Second)),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	})
+	resp, err := this.do("PUT", "/v1/session/create", nil, bytes.NewBuffer(body), nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", unexpected(resp)
+	}
+	created := struct{ ID string }{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+func (this *kv) renewSession(id string) error {
+	resp, err := this.do("PUT", "/v1/session/renew/"+id, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpected(resp)
+	}
+	return nil
+}
+
+func (this *kv) destroySession(id string) error {
+	resp, err := this.do("PUT", "/v1/session/destroy/"+id, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpected(resp)
+	}
+	return nil
+}
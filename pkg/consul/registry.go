@@ -0,0 +1,435 @@
+package consul
+
+import (
+	"fmt"
+	. "github.com/conductant/gohm/pkg/namespace"
+	. "github.com/conductant/gohm/pkg/store"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"net/http"
+	net "net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("consul", NewService)
+	RegisterSanitizer("consul", SanitizeUrl)
+}
+
+func SanitizeUrl(url net.URL) net.URL {
+	if len(url.Host) > 0 {
+		return url
+	} else {
+		glog.Infoln("No host provided. Using environment variable", EnvConsulHost, "or default.")
+		copy := url
+		copy.Host = Host()
+		return copy
+	}
+}
+
+// Registry implementation using the consul KV api.  Node paths map to keys without the leading slash,
+// and the Version of a node is the ModifyIndex of the key.  Since ModifyIndex is a cluster-wide counter,
+// versions are not sequential for a given key, but they still work for CAS via PutVersion / DeleteVersion.
+// The index is a uint64 and maps to a Version without loss until 2^63.
+// Ephemeral nodes are acquired by a session that is renewed until the registry is closed.  Keys under
+// ReservedKey hold the registry's own data and are not nodes.
+type registry struct {
+	url   net.URL
+	kv    *kv
+	wait  time.Duration
+	ttl   time.Duration // of the session
+	close Dispose
+
+	lock    sync.Mutex
+	closed  bool
+	session string
+	done    chan struct{}
+}
+
+// Optional parameter is the wait time of blocking queries, in Duration.  The wait option of the url,
+// e.g. consul://host:8500/?wait=30s, takes precedence.
+func NewService(ctx context.Context, url net.URL, close Dispose) (Registry, error) {
+	wait := ContextGetWaitTime(ctx)
+	if v := ContextGetOptions(ctx).Params.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, &BadOption{Name: "wait", Value: v}
+		}
+		wait = d
+	}
+	return &registry{
+		url:   net.URL{Scheme: url.Scheme, Host: url.Host},
+		kv:    &kv{base: "http://" + url.Host, client: &http.Client{}},
+		wait:  wait,
+		ttl:   DefaultSessionTTL,
+		close: close,
+		done:  make(chan struct{}),
+	}, nil
+}
+
+func toKey(p Path) string {
+	return strings.TrimPrefix(NewPath(p.String()).String(), "/")
+}
+
+func (this *registry) check() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (this *registry) Close() error {
+	ok := true
+	if this.close != nil {
+		this.close.Propose() <- this
+		ok = <-this.close.Accept()
+	}
+	if !ok {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	close(this.done)
+	if this.session != "" {
+		// Invalidating the session deletes the ephemeral nodes.
+		return this.kv.destroySession(this.session)
+	}
+	return nil
+}
+
+// Returns the session for ephemeral nodes, creating it if necessary.  A session that has expired or
+// was invalidated is forgotten when it fails to renew, and the next ephemeral node creates another.
+// The nodes of the old session are gone with it.
+func (this *registry) getSession() (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.session != "" {
+		return this.session, nil
+	}
+	host, _ := os.Hostname()
+	id, err := this.kv.createSession(fmt.Sprintf("gohm-%s-%d", host, os.Getpid()), this.ttl)
+	if err != nil {
+		return "", err
+	}
+	this.session = id
+	go func() {
+		ticker := time.NewTicker(this.ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := this.kv.renewSession(id)
+				if status, is := err.(*UnexpectedStatus); is && status.Status == http.StatusNotFound {
+					glog.Warningln("Session", id, "is gone")
+					this.lock.Lock()
+					if this.session == id {
+						this.session = ""
+					}
+					this.lock.Unlock()
+					return
+				} else if err != nil {
+					glog.Warningln("Cannot renew session", id, "err=", err)
+				}
+			case <-this.done:
+				return
+			}
+		}
+	}()
+	return id, nil
+}
+
+func (this *registry) Id() net.URL {
+	return this.url
+}
+
+func (this *registry) Exists(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	k := toKey(key)
+	if k == "" {
+		return true, nil
+	}
+	p, _, err := this.kv.get(k, 0, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	return p != nil, nil
+}
+
+func (this *registry) Get(key Path) ([]byte, Version, error) {
+	if err := this.check(); err != nil {
+		return nil, InvalidVersion, err
+	}
+	k := toKey(key)
+	if k == "" {
+		return []byte{}, InvalidVersion, nil
+	}
+	p, _, err := this.kv.get(k, 0, 0, nil)
+	if err != nil {
+		return nil, InvalidVersion, err
+	}
+	if p == nil {
+		return nil, InvalidVersion, ErrNotExist
+	}
+	return p.Value, Version(p.ModifyIndex), nil
+}
+
+// Keys acquired by a session are the ephemeral nodes.
+func (this *registry) IsEphemeral(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	k := toKey(key)
+	if k == "" {
+		return false, nil
+	}
+	p, _, err := this.kv.get(k, 0, 0, nil)
+	if err != nil {
+		return false, err
+	}
+	if p == nil {
+		return false, ErrNotExist
+	}
+	return p.Session != "", nil
+}
+
+// Consul keeps no times, so they are zero.  The owner of an ephemeral node is the session that holds it.
+func (this *registry) Stat(key Path) (*Stat, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	k := toKey(key)
+	if k == "" {
+		keys, _, err := this.kv.keys("", 0, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &Stat{Version: InvalidVersion, Children: len(children(k, keys))}, nil
+	}
+	p, _, err := this.kv.get(k, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, ErrNotExist
+	}
+	keys, _, err := this.kv.keys(k+"/", 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Stat{
+		Version:   Version(p.ModifyIndex),
+		Size:      len(p.Value),
+		Children:  len(children(k, keys)),
+		Ephemeral: p.Session != "",
+		Owner:     p.Session,
+	}, nil
+}
+
+func reserved(key string) bool {
+	return key == ReservedKey || strings.HasPrefix(key, ReservedKey+"/")
+}
+
+// Returns the full paths of the children, given the keys from a keys query.
+func children(key string, keys []string) []Path {
+	seen := map[string]bool{}
+	names := []string{}
+	prefix := key + "/"
+	if key == "" {
+		prefix = ""
+	}
+	for _, k := range keys {
+		name := strings.TrimSuffix(strings.TrimPrefix(k, prefix), "/")
+		if name == "" || seen[name] || reserved(k) {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	paths := []Path{}
+	for _, n := range names {
+		paths = append(paths, NewPath(prefix, n))
+	}
+	return paths
+}
+
+// Matches the pattern against all the keys under its prefix, listed in one request.
+func (this *registry) Glob(pattern string) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	k := toKey(GlobPrefix(pattern))
+	keys, err := this.kv.allKeys(k)
+	if err != nil {
+		return nil, err
+	}
+	// The root is not a key, and folders may be keys with a trailing slash.
+	paths := []Path{NewPath("/")}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		key = strings.TrimSuffix(key, "/")
+		if seen[key] || reserved(key) || !(k == "" || key == k || strings.HasPrefix(key, k+"/")) {
+			continue
+		}
+		seen[key] = true
+		paths = append(paths, NewPath(key))
+	}
+	return FilterGlob(pattern, paths)
+}
+
+func (this *registry) List(key Path) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	k := toKey(key)
+	prefix := k + "/"
+	if k == "" {
+		prefix = ""
+	}
+	keys, _, err := this.kv.keys(prefix, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if exists, err := this.Exists(key); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, ErrNotExist
+		}
+	}
+	return children(k, keys), nil
+}
+
+func (this *registry) Delete(key Path) error {
+	return this.DeleteVersion(key, InvalidVersion)
+}
+
+func (this *registry) DeleteVersion(key Path, version Version) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	k := toKey(key)
+	p, _, err := this.kv.get(k, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return ErrNotExist
+	}
+	keys, _, err := this.kv.keys(k+"/", 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	if len(children(k, keys)) > 0 {
+		return ErrNotEmpty
+	}
+	params := net.Values{}
+	if version != InvalidVersion {
+		params.Set("cas", strconv.FormatUint(uint64(version), 10))
+	}
+	ok, err := this.kv.delete(k, params)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBadVersion
+	}
+	this.forgetSequence(k)
+	return nil
+}
+
+// Creates the missing parents as empty keys, so that they can be listed and read like in other backends.
+func (this *registry) createParents(key string) error {
+	parts := strings.Split(key, "/")
+	for i := 1; i < len(parts); i++ {
+		_, err := this.kv.put(strings.Join(parts[:i], "/"), []byte{}, net.Values{"cas": []string{"0"}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (this *registry) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	k := toKey(key)
+	if err := this.createParents(k); err != nil {
+		return InvalidVersion, err
+	}
+	if !ephemeral {
+		version, _, err := this.write(k, txnOp{Verb: "set", Key: k, Value: value})
+		return version, err
+	}
+	session, err := this.getSession()
+	if err != nil {
+		return InvalidVersion, err
+	}
+	version, errs, err := this.write(k,
+		txnOp{Verb: "cas", Key: k, Value: value, Index: 0},
+		txnOp{Verb: "lock", Key: k, Value: value, Session: session})
+	switch {
+	case err != nil:
+		return InvalidVersion, err
+	case len(errs) > 0 && errs[0].OpIndex == 0:
+		return InvalidVersion, ErrNodeExists
+	case len(errs) > 0:
+		return InvalidVersion, fmt.Errorf("cannot-acquire: %s", k)
+	}
+	return version, nil
+}
+
+// Writes the key with the operations, in one transaction, and returns the index it was written at.
+// Reading the version afterwards could get the one of someone else's write.
+func (this *registry) write(k string, ops ...txnOp) (Version, []txnError, error) {
+	pairs, errs, err := this.kv.txn(ops)
+	if err != nil || len(errs) > 0 {
+		return InvalidVersion, errs, err
+	}
+	for i := len(pairs) - 1; i >= 0; i-- {
+		if pairs[i] != nil && pairs[i].Key == k {
+			return Version(pairs[i].ModifyIndex), nil, nil
+		}
+	}
+	return InvalidVersion, nil, nil
+}
+
+func (this *registry) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	k := toKey(key)
+	p, _, err := this.kv.get(k, 0, 0, nil)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	if p == nil {
+		return InvalidVersion, ErrNotExist
+	}
+	op := txnOp{Verb: "set", Key: k, Value: value}
+	if version != InvalidVersion {
+		op = txnOp{Verb: "cas", Key: k, Value: value, Index: uint64(version)}
+	}
+	written, errs, err := this.write(k, op)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	if len(errs) > 0 {
+		return InvalidVersion, ErrBadVersion
+	}
+	return written, nil
+}
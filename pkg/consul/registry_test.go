@@ -0,0 +1,574 @@
+package consul
+
+import (
+	"fmt"
+	"github.com/conductant/gohm/pkg/encoding"
+	_ "github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/resource"
+	"github.com/conductant/gohm/pkg/template"
+	"github.com/conductant/gohm/pkg/testutil/nstest"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+	"testing"
+	"time"
+)
+
+var delay = 200 * time.Millisecond
+
+func TestRegistry(t *testing.T) { TestingT(t) }
+
+type TestSuiteRegistry struct {
+	consul *fakeConsul
+	url    string
+}
+
+var _ = Suite(&TestSuiteRegistry{})
+
+func (suite *TestSuiteRegistry) SetUpSuite(c *C) {
+	suite.consul = newFakeConsul()
+	suite.url = "consul://" + suite.consul.Host()
+}
+
+func (suite *TestSuiteRegistry) TearDownSuite(c *C) {
+	suite.consul.Close()
+}
+
+func (suite *TestSuiteRegistry) dial(c *C) namespace.Registry {
+	ctx := ContextPutWaitTime(context.Background(), time.Second)
+	reg, err := namespace.Dial(ctx, suite.url)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+func (suite *TestSuiteRegistry) TestUsage(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/namespace/test")
+	v := []byte("test")
+	_, err := reg.Put(p, v, false)
+	c.Assert(err, IsNil)
+	read, _, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, v)
+
+	exists, err := reg.Exists(p.Dir())
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	check := map[namespace.Path]int{}
+	for i := 0; i < 10; i++ {
+		cp := p.Sub(fmt.Sprintf("child-%d", i))
+		_, err = reg.Put(cp, []byte{0}, false)
+		c.Assert(err, IsNil)
+		check[cp] = i
+	}
+	// A grandchild shows up as a child only once
+	_, err = reg.Put(p.Sub("child-0", "grandchild"), []byte{0}, false)
+	c.Assert(err, IsNil)
+
+	list, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, len(check))
+	for _, p := range list {
+		_, has := check[p]
+		c.Assert(has, Equals, true)
+	}
+
+	c.Assert(reg.Delete(p.Sub("child-0")), Equals, namespace.ErrNotEmpty)
+	c.Assert(reg.Delete(p.Sub("child-0", "grandchild")), IsNil)
+
+	for i := 0; i < 10; i++ {
+		err = reg.Delete(p.Sub(fmt.Sprintf("child-%d", i)))
+		c.Assert(err, IsNil)
+	}
+	list, err = reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 0)
+
+	exists, err = reg.Exists(p.Sub("child-0"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	_, _, err = reg.Get(p.Sub("child-0"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	_, err = reg.List(p.Sub("child-0"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	c.Assert(reg.Delete(p.Sub("child-0")), Equals, namespace.ErrNotExist)
+
+	root, err := reg.List(namespace.NewPath("/"))
+	c.Assert(err, IsNil)
+	c.Assert(root, DeepEquals, []namespace.Path{namespace.NewPath("/unit-test")})
+}
+
+func (suite *TestSuiteRegistry) TestEscapedNames(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/escaped")
+	names := []string{"a?b=1", "c#d", "e%20f", "g h"}
+	for _, name := range names {
+		_, err := reg.Put(p.Sub(name), []byte(name), false)
+		c.Assert(err, IsNil)
+	}
+	for _, name := range names {
+		read, _, err := reg.Get(p.Sub(name))
+		c.Assert(err, IsNil)
+		c.Assert(string(read), Equals, name)
+	}
+	list, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, len(names))
+	for _, name := range names {
+		c.Assert(reg.Delete(p.Sub(name)), IsNil)
+	}
+	_, _, err = reg.Get(p.Sub("a"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
+
+func (suite *TestSuiteRegistry) TestVersions(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/version")
+	version, err := reg.Put(p, []byte("test"), false)
+	c.Assert(err, IsNil)
+	c.Assert(version, Not(Equals), namespace.InvalidVersion)
+
+	read, version2, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, []byte("test"))
+	c.Assert(version, Equals, version2)
+
+	version3, err := reg.PutVersion(p, []byte{1}, version2)
+	c.Assert(err, IsNil)
+	c.Assert(version3 > version2, Equals, true)
+
+	_, err = reg.PutVersion(p, []byte{2}, version2)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
+
+	_, err = reg.PutVersion(p.Sub("missing"), []byte{2}, version2)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	c.Assert(reg.DeleteVersion(p, version), Equals, namespace.ErrBadVersion)
+
+	cv, version4, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(version4, Equals, version3)
+	c.Assert(cv, DeepEquals, []byte{1})
+
+	c.Assert(reg.DeleteVersion(p, version4), IsNil)
+	_, _, err = reg.Get(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
+
+func (suite *TestSuiteRegistry) TestLargeIndex(c *C) {
+	consul := newFakeConsul()
+	defer consul.Close()
+	reg, err := NewService(context.Background(), net.URL{Scheme: "consul", Host: consul.Host()}, nil)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	// The next write is at 2^32 - 1, which is -1 if cut to 32 bits.
+	consul.lock.Lock()
+	consul.index = 1<<32 - 2
+	consul.lock.Unlock()
+
+	p := namespace.NewPath("/large")
+	version, err := reg.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, namespace.Version(1<<32-1))
+
+	_, read, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(read, Equals, version)
+
+	version2, err := reg.PutVersion(p, []byte("2"), version)
+	c.Assert(err, IsNil)
+	c.Assert(version2 > version, Equals, true)
+	_, err = reg.PutVersion(p, []byte("3"), version)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
+
+	// A CAS update loop does not mistake the node for a missing one.
+	value := 0
+	_, err = namespace.UpdateObject(reg, p, encoding.ContentTypeJSON, &value, func(interface{}) error {
+		value++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(value, Equals, 3)
+}
+
+func (suite *TestSuiteRegistry) TestWritesAreAtomic(c *C) {
+	consul := newFakeConsul()
+	defer consul.Close()
+	reg, err := NewService(context.Background(), net.URL{Scheme: "consul", Host: consul.Host()}, nil)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	// Someone else writes the key right after each transaction.
+	consul.lock.Lock()
+	consul.afterTxn = func() {
+		if p, has := consul.kv["atomic"]; has {
+			p.Value = []byte("other")
+			p.ModifyIndex = consul.bump()
+		}
+	}
+	consul.lock.Unlock()
+
+	p := namespace.NewPath("/atomic")
+	for _, write := range []func() (namespace.Version, error){
+		func() (namespace.Version, error) { return reg.Put(p, []byte("mine"), false) },
+		func() (namespace.Version, error) { return reg.PutVersion(p, []byte("mine"), namespace.InvalidVersion) },
+	} {
+		version, err := write()
+		c.Assert(err, IsNil)
+		_, current, err := reg.Get(p)
+		c.Assert(err, IsNil)
+		c.Assert(version < current, Equals, true)
+	}
+
+	// Deleting without a version is not a CAS on an index read before.
+	c.Assert(reg.Delete(p), IsNil)
+	consul.lock.Lock()
+	_, cas := consul.lastDelete["cas"]
+	consul.lock.Unlock()
+	c.Assert(cas, Equals, false)
+
+	version, err := reg.Put(p, []byte("mine"), false)
+	c.Assert(err, IsNil)
+	c.Assert(reg.DeleteVersion(p, version), Equals, namespace.ErrBadVersion)
+	consul.lock.Lock()
+	c.Assert(consul.lastDelete.Get("cas"), Equals, fmt.Sprint(version))
+	consul.lock.Unlock()
+}
+
+func (suite *TestSuiteRegistry) TestEphemeral(c *C) {
+	url := net.URL{Scheme: "consul", Host: suite.consul.Host()}
+	session1, err := NewService(context.Background(), url, nil)
+	c.Assert(err, IsNil)
+	session2, err := NewService(context.Background(), url, nil)
+	c.Assert(err, IsNil)
+	defer session2.Close()
+
+	p := namespace.NewPath("/unit-test/registry/ephemeral")
+	_, err = session1.Put(p, []byte("test"), true)
+	c.Assert(err, IsNil)
+
+	_, err = session2.Put(p, []byte("test"), true)
+	c.Assert(err, Equals, namespace.ErrNodeExists)
+
+	exists, err := session2.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	c.Assert(session1.Close(), IsNil)
+
+	exists, err = session2.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	exists, err = session2.Exists(p.Dir())
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	_, _, err = session1.Get(p)
+	c.Assert(err, Equals, namespace.ErrClosed)
+}
+
+func (suite *TestSuiteRegistry) TestEphemeralAfterSessionInvalidated(c *C) {
+	reg, err := NewService(context.Background(), net.URL{Scheme: "consul", Host: suite.consul.Host()}, nil)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+	reg.(*registry).ttl = 2 * delay
+
+	p := namespace.NewPath("/unit-test/registry/ephemeral/invalidated")
+	_, err = reg.Put(p, []byte("1"), true)
+	c.Assert(err, IsNil)
+
+	// Invalidated behind the registry's back, e.g. by an operator or a partition.
+	suite.consul.lock.Lock()
+	key := toKey(p)
+	delete(suite.consul.sessions, suite.consul.kv[key].Session)
+	delete(suite.consul.kv, key)
+	suite.consul.lock.Unlock()
+
+	time.Sleep(2 * delay)
+	_, err = reg.Put(p, []byte("2"), true)
+	c.Assert(err, IsNil)
+	ephemeral, err := reg.(namespace.EphemeralReporter).IsEphemeral(p)
+	c.Assert(err, IsNil)
+	c.Assert(ephemeral, Equals, true)
+}
+
+func (suite *TestSuiteRegistry) TestFollowAcrossBackends(c *C) {
+	ctx := context.Background()
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	other, err := namespace.Dial(ctx, "mem://consul-follow")
+	c.Assert(err, IsNil)
+	defer other.Close()
+
+	p := namespace.NewPath("/unit-test/registry/follow")
+
+	// consul -> mem -> consul
+	_, err = reg.Put(p.Sub("1"), []byte("mem://consul-follow"+p.Sub("2").String()), false)
+	c.Assert(err, IsNil)
+	_, err = other.Put(p.Sub("2"), []byte(suite.url+p.Sub("3").String()), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("3"), []byte("end"), false)
+	c.Assert(err, IsNil)
+
+	u, err := net.Parse(suite.url + p.Sub("1").String())
+	c.Assert(err, IsNil)
+	path, value, version, err := namespace.FollowUrl(ctx, *u)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("end"))
+	c.Assert(path.String(), Equals, suite.url+p.Sub("3").String())
+	c.Assert(version, Not(Equals), namespace.InvalidVersion)
+
+	// The template get function and resource fetch go through the registry
+	_, err = other.Put(p.Sub("tmpl"), []byte(`value={{get "`+suite.url+p.Sub("3").String()+`"}}`), false)
+	c.Assert(err, IsNil)
+	applied, err := template.Execute(ctx, "mem://consul-follow"+p.Sub("tmpl").String())
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "value=end")
+
+	fetched, err := resource.Fetch(ctx, suite.url+p.Sub("3").String())
+	c.Assert(err, IsNil)
+	c.Assert(fetched, DeepEquals, []byte("end"))
+}
+
+func (suite *TestSuiteRegistry) TestTriggers(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/node")
+
+	watch, stop := context.WithCancel(context.Background())
+	created, err := reg.Trigger(watch, namespace.Create{Path: p})
+	c.Assert(err, IsNil)
+	changed, err := reg.Trigger(watch, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+	deleted, err := reg.Trigger(watch, namespace.Delete{Path: p})
+	c.Assert(err, IsNil)
+	members, err := reg.Trigger(watch, namespace.Members{Path: p})
+	c.Assert(err, IsNil)
+
+	_, err = reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+
+	events := nstest.Collect(created, 1, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventCreate)
+	c.Assert(events[0].Path, Equals, p.String())
+
+	time.Sleep(delay)
+
+	for i := 2; i <= 3; i++ {
+		version, err := reg.Put(p, []byte{byte(i)}, false)
+		c.Assert(err, IsNil)
+		events = nstest.Collect(changed, 1, delay)
+		c.Assert(len(events), Equals, 1)
+		c.Assert(events[0].Kind, Equals, namespace.EventChange)
+		c.Assert(events[0].Version, Equals, version)
+	}
+
+	_, err = reg.Put(p.Sub("a"), []byte{1}, false)
+	c.Assert(err, IsNil)
+	changes := nstest.CollectMembers(members, 1, delay)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].After, DeepEquals, []string{p.Sub("a").String()})
+
+	c.Assert(reg.Delete(p.Sub("a")), IsNil)
+	changes = nstest.CollectMembers(members, 1, delay)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].AfterCount, Equals, 0)
+
+	c.Assert(reg.Delete(p), IsNil)
+	events = nstest.Collect(deleted, 1, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventDelete)
+
+	stop()
+
+	_, open := <-created
+	c.Assert(open, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestTriggerOfPointers(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/pointers")
+	watch, stop := context.WithCancel(context.Background())
+	defer stop()
+	members, err := reg.Trigger(watch, (&namespace.Members{Path: p}).SetMin(1))
+	c.Assert(err, IsNil)
+
+	_, err = reg.Put(p.Sub("a"), []byte{1}, false)
+	c.Assert(err, IsNil)
+	changes := nstest.CollectMembers(members, 1, delay)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].AfterCount, Equals, 1)
+
+	_, err = reg.Trigger(watch, &namespace.Create{Path: p})
+	c.Assert(err, Equals, namespace.ErrBadTrigger)
+}
+
+func (suite *TestSuiteRegistry) TestTriggerRefused(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/refused")
+	changed, err := reg.Trigger(context.Background(), namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+
+	suite.consul.lock.Lock()
+	suite.consul.denied = toKey(p)
+	suite.consul.lock.Unlock()
+	defer func() {
+		suite.consul.lock.Lock()
+		suite.consul.denied = ""
+		suite.consul.lock.Unlock()
+	}()
+	_, err = reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+
+	// The query is not retried.  The error is the last event.
+	events := nstest.Collect(changed, 2, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventError)
+	c.Assert(events[0].Err.(*UnexpectedStatus).Status, Equals, 403)
+	_, open := <-changed
+	c.Assert(open, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestTxn(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	a := namespace.NewPath("/unit-test/registry/txn/a")
+	b := namespace.NewPath("/unit-test/registry/txn/b")
+	version, err := reg.Put(a, []byte("a"), false)
+	c.Assert(err, IsNil)
+
+	_, err = reg.Txn(
+		namespace.OpCreate{Path: b, Value: []byte("b")},
+		namespace.OpSet{Path: a, Value: []byte("a2"), Version: version + 100},
+	)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*namespace.TxnError).Index, Equals, 1)
+	c.Assert(err.(*namespace.TxnError).Err, Equals, namespace.ErrBadVersion)
+	exists, err := reg.Exists(b)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	_, err = reg.Txn(namespace.OpCreate{Path: a, Value: []byte("a")})
+	c.Assert(err.(*namespace.TxnError).Err, Equals, namespace.ErrNodeExists)
+
+	results, err := reg.Txn(
+		namespace.OpCheck{Path: a, Version: version},
+		namespace.OpCreate{Path: b, Value: []byte("b"), Ephemeral: true},
+		namespace.OpSet{Path: a, Value: []byte("a2"), Version: namespace.InvalidVersion},
+	)
+	c.Assert(err, IsNil)
+	value, v, err := reg.Get(a)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("a2"))
+	c.Assert(v, Equals, results[2].Version)
+	value, _, err = reg.Get(b)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("b"))
+}
+
+func (suite *TestSuiteRegistry) TestSequential(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/sequential")
+	for i := 0; i < 3; i++ {
+		_, err := namespace.CreateSequential(reg, p.Sub("job-"), []byte("job"), false)
+		c.Assert(err, IsNil)
+	}
+	// Numbers are not reused after the node with the highest one is deleted.
+	c.Assert(reg.Delete(p.Sub("job-0000000002")), IsNil)
+	next, err := namespace.CreateSequential(reg, p.Sub("job-"), []byte("job"), false)
+	c.Assert(err, IsNil)
+	c.Assert(next.String(), Equals, p.Sub("job-0000000003").String())
+
+	// The counters are not nodes.
+	root, err := reg.List(namespace.NewPath("/"))
+	c.Assert(err, IsNil)
+	for _, child := range root {
+		c.Assert(child.String(), Not(Equals), "/"+ReservedKey)
+	}
+	matched, err := namespace.Glob(reg, "/*")
+	c.Assert(err, IsNil)
+	c.Assert(matched, DeepEquals, root)
+
+	// The counter goes away with the parent.
+	_, err = namespace.DeleteTree(reg, p, namespace.TreeOptions{})
+	c.Assert(err, IsNil)
+	exists, err := reg.Exists(namespace.NewPath(ReservedKey, "sequence", toKey(p)))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestCopyTreeAcrossBackends(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	other, err := namespace.Dial(context.Background(), "mem://consul-copy")
+	c.Assert(err, IsNil)
+	defer other.Close()
+
+	src := namespace.NewPath("/unit-test/registry/copy")
+	for i := 0; i < 3; i++ {
+		_, err = other.Put(src.Sub(fmt.Sprintf("node-%d/leaf", i)), []byte(fmt.Sprintf("%d", i)), false)
+		c.Assert(err, IsNil)
+	}
+
+	dst := namespace.NewPath("/unit-test/registry/copied")
+	copied, err := namespace.CopyTree(other, src, reg, dst, namespace.TreeOptions{Concurrency: 2})
+	c.Assert(err, IsNil)
+	c.Assert(len(copied), Equals, 7)
+
+	list, err := reg.List(dst)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 3)
+	value, _, err := reg.Get(dst.Sub("node-2/leaf"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("2"))
+
+	_, err = namespace.DeleteTree(reg, dst, namespace.TreeOptions{})
+	c.Assert(err, IsNil)
+	exists, err := reg.Exists(dst)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestGlob(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	for _, p := range []string{
+		"/unit-test/glob/services/web/instances/1", "/unit-test/glob/services/db/instances/1",
+		"/unit-test/glob/services/web/config", "/unit-test/glob-other/services/x/instances/1",
+	} {
+		_, err := reg.Put(namespace.NewPath(p), []byte{}, false)
+		c.Assert(err, IsNil)
+	}
+	matched, err := namespace.Glob(reg, "/unit-test/glob/services/*/instances/*")
+	c.Assert(err, IsNil)
+	c.Assert(matched, DeepEquals, []namespace.Path{
+		namespace.NewPath("/unit-test/glob/services/db/instances/1"),
+		namespace.NewPath("/unit-test/glob/services/web/instances/1"),
+	})
+	matched, err = namespace.Glob(reg, "/unit-test/glob/**/config")
+	c.Assert(err, IsNil)
+	c.Assert(matched, DeepEquals, []namespace.Path{namespace.NewPath("/unit-test/glob/services/web/config")})
+}
@@ -0,0 +1,282 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	net "net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stand-in for the consul KV and session apis, good enough for testing the registry.
+type fakeConsul struct {
+	lock     sync.Mutex
+	index    uint64
+	kv       map[string]*pair
+	sessions map[string]bool
+	changed  chan struct{}
+	server   *httptest.Server
+
+	// Query of the last delete of a key.
+	lastDelete net.Values
+
+	// Called with the lock held after each transaction, e.g. to write in the way.
+	afterTxn func()
+
+	// Reads of the keys with this prefix are denied, as by an acl.
+	denied string
+}
+
+func newFakeConsul() *fakeConsul {
+	f := &fakeConsul{
+		index:    1,
+		kv:       map[string]*pair{},
+		sessions: map[string]bool{},
+		changed:  make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/kv/", f.handleKV)
+	mux.HandleFunc("/v1/session/", f.handleSession)
+	mux.HandleFunc("/v1/txn", f.handleTxn)
+	f.server = httptest.NewServer(mux)
+	return f
+}
+
+func (this *fakeConsul) Host() string {
+	return strings.TrimPrefix(this.server.URL, "http://")
+}
+
+func (this *fakeConsul) Close() {
+	this.server.Close()
+}
+
+// Must hold lock.
+func (this *fakeConsul) bump() uint64 {
+	this.index++
+	close(this.changed)
+	this.changed = make(chan struct{})
+	return this.index
+}
+
+// Blocks until the index moves past the requested index or the wait time is up.  Returns with the lock held.
+func (this *fakeConsul) block(req *http.Request) {
+	this.lock.Lock()
+	waitIndex, _ := strconv.ParseUint(req.URL.Query().Get("index"), 10, 64)
+	if waitIndex == 0 {
+		return
+	}
+	wait, err := time.ParseDuration(req.URL.Query().Get("wait"))
+	if err != nil {
+		wait = 5 * time.Second
+	}
+	timeout := time.After(wait)
+	for this.index <= waitIndex {
+		changed := this.changed
+		this.lock.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
+			this.lock.Lock()
+			return
+		}
+		this.lock.Lock()
+	}
+}
+
+func (this *fakeConsul) handleKV(resp http.ResponseWriter, req *http.Request) {
+	key := strings.TrimPrefix(req.URL.Path, "/v1/kv/")
+	query := req.URL.Query()
+	switch req.Method {
+	case "GET":
+		this.block(req)
+		defer this.lock.Unlock()
+		if this.denied != "" && strings.HasPrefix(key, this.denied) {
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+		resp.Header().Set("X-Consul-Index", strconv.FormatUint(this.index, 10))
+		if _, has := query["keys"]; has {
+			seen := map[string]bool{}
+			keys := []string{}
+			for k, _ := range this.kv {
+				if !strings.HasPrefix(k, key) {
+					continue
+				}
+				rest := k[len(key):]
+				if i := strings.Index(rest, query.Get("separator")); i >= 0 && query.Get("separator") != "" {
+					k = key + rest[:i+1]
+				}
+				if !seen[k] {
+					seen[k] = true
+					keys = append(keys, k)
+				}
+			}
+			if len(keys) == 0 {
+				resp.WriteHeader(http.StatusNotFound)
+				return
+			}
+			sort.Strings(keys)
+			json.NewEncoder(resp).Encode(keys)
+			return
+		}
+		p, has := this.kv[key]
+		if !has {
+			resp.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(resp).Encode([]*pair{p})
+
+	case "PUT":
+		value, _ := ioutil.ReadAll(req.Body)
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		p, has := this.kv[key]
+		if cas, ok := query["cas"]; ok {
+			index, _ := strconv.ParseUint(cas[0], 10, 64)
+			if (index == 0 && has) || (index > 0 && (!has || p.ModifyIndex != index)) {
+				fmt.Fprint(resp, "false")
+				return
+			}
+		}
+		session := query.Get("acquire")
+		if session != "" && (!this.sessions[session] || (has && p.Session != "" && p.Session != session)) {
+			fmt.Fprint(resp, "false")
+			return
+		}
+		index := this.bump()
+		if !has {
+			p = &pair{Key: key, CreateIndex: index}
+			this.kv[key] = p
+		}
+		p.Value = value
+		p.ModifyIndex = index
+		if session != "" {
+			p.Session = session
+		}
+		fmt.Fprint(resp, "true")
+
+	case "DELETE":
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		this.lastDelete = query
+		p, has := this.kv[key]
+		if cas, ok := query["cas"]; ok {
+			index, _ := strconv.ParseUint(cas[0], 10, 64)
+			if !has || p.ModifyIndex != index {
+				fmt.Fprint(resp, "false")
+				return
+			}
+		}
+		if has {
+			delete(this.kv, key)
+			this.bump()
+		}
+		fmt.Fprint(resp, "true")
+	}
+}
+
+func (this *fakeConsul) handleSession(resp http.ResponseWriter, req *http.Request) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/v1/session/"), "/")
+	switch parts[0] {
+	case "create":
+		id := fmt.Sprintf("session-%d", this.bump())
+		this.sessions[id] = true
+		json.NewEncoder(resp).Encode(map[string]string{"ID": id})
+	case "renew":
+		if !this.sessions[parts[1]] {
+			resp.WriteHeader(http.StatusNotFound)
+		}
+	case "destroy":
+		delete(this.sessions, parts[1])
+		for k, p := range this.kv {
+			if p.Session == parts[1] {
+				delete(this.kv, k)
+			}
+		}
+		this.bump()
+		fmt.Fprint(resp, "true")
+	}
+}
+
+// Applies the KV operations on a copy and swaps it in only if all of them succeed.
+func (this *fakeConsul) handleTxn(resp http.ResponseWriter, req *http.Request) {
+	ops := []struct{ KV txnOp }{}
+	if err := json.NewDecoder(req.Body).Decode(&ops); err != nil {
+		resp.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	kv := map[string]*pair{}
+	for k, p := range this.kv {
+		copy := *p
+		kv[k] = &copy
+	}
+	index := this.index + 1
+	results := []map[string]*pair{}
+	fail := func(i int, what string) {
+		resp.WriteHeader(http.StatusConflict)
+		json.NewEncoder(resp).Encode(map[string]interface{}{
+			"Errors": []txnError{{OpIndex: i, What: what}},
+		})
+	}
+	for i, op := range ops {
+		o := op.KV
+		p, has := kv[o.Key]
+		switch o.Verb {
+		case "check-index":
+			if !has {
+				fail(i, fmt.Sprintf("key %q doesn't exist", o.Key))
+				return
+			} else if p.ModifyIndex != o.Index {
+				fail(i, fmt.Sprintf("current modify index %d != %d", p.ModifyIndex, o.Index))
+				return
+			}
+			copy := *p
+			results = append(results, map[string]*pair{"KV": &copy})
+			continue
+		case "cas", "delete-cas":
+			if (o.Index == 0 && has) || (o.Index > 0 && (!has || p.ModifyIndex != o.Index)) {
+				fail(i, fmt.Sprintf("failed to %s key %q, index is stale", o.Verb, o.Key))
+				return
+			}
+		case "lock":
+			if !this.sessions[o.Session] || (has && p.Session != "" && p.Session != o.Session) {
+				fail(i, fmt.Sprintf("failed to lock key %q", o.Key))
+				return
+			}
+		}
+		switch o.Verb {
+		case "delete", "delete-cas":
+			delete(kv, o.Key)
+		default:
+			if !has {
+				p = &pair{Key: o.Key, CreateIndex: index}
+				kv[o.Key] = p
+			}
+			p.Value = o.Value
+			p.ModifyIndex = index
+			if o.Session != "" {
+				p.Session = o.Session
+			}
+		}
+		if p, has := kv[o.Key]; has {
+			copy := *p
+			results = append(results, map[string]*pair{"KV": &copy})
+		}
+	}
+	this.kv = kv
+	this.bump()
+	json.NewEncoder(resp).Encode(map[string]interface{}{"Results": results})
+	if this.afterTxn != nil {
+		this.afterTxn()
+	}
+}
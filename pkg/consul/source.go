@@ -0,0 +1,11 @@
+package consul
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/resource"
+)
+
+// Binds the consul protocol to the generic Source implementation in the namespace package.
+func init() {
+	resource.Register("consul", namespace.Source)
+}
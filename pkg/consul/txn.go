@@ -0,0 +1,193 @@
+package consul
+
+import (
+	"fmt"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	net "net/url"
+	"strconv"
+	"strings"
+)
+
+// Applies the operations with the consul txn api.  Operations with InvalidVersion are sent as CAS on
+// the index read just before the transaction, so a concurrent write makes the transaction fail
+// instead of being overwritten.  Missing parents of created nodes are created before the transaction
+// and are not removed if it fails.  Versions in the results are the ones the transaction wrote.  Sequential
+// nodes are emulated, see EmulateSequential.
+func (this *registry) Txn(ops ...Op) ([]OpResult, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return EmulateSequential(this, this.txn, this.sequence, ops)
+}
+
+// Key of the counter of the sequential children of the key, under the reserved key.
+func sequenceKey(key string) string {
+	return strings.TrimSuffix(ReservedKey+"/sequence/"+key, "/")
+}
+
+// The counter holds the next number, and is updated with CAS on its index.
+func (this *registry) sequence(parent Path, start int64) (int64, error) {
+	k := sequenceKey(toKey(parent))
+	for {
+		p, _, err := this.kv.get(k, 0, 0, nil)
+		if err != nil {
+			return 0, err
+		}
+		seq, index := start, uint64(0)
+		if p != nil {
+			index = p.ModifyIndex
+			if next, err := strconv.ParseInt(string(p.Value), 10, 64); err == nil && next > seq {
+				seq = next
+			}
+		}
+		next := []byte(strconv.FormatInt(seq+1, 10))
+		ok, err := this.kv.put(k, next, net.Values{"cas": []string{strconv.FormatUint(index, 10)}})
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return seq, nil
+		}
+		// Taken by another client.  Read again.
+	}
+}
+
+// Removes the counter of a deleted node.  Best effort: a counter left behind only means the numbers
+// continue from where they were if the node is created again.
+func (this *registry) forgetSequence(key string) {
+	if _, err := this.kv.delete(sequenceKey(key), nil); err != nil {
+		glog.Warningln("Cannot remove sequence counter of", key, "err=", err)
+	}
+}
+
+func (this *registry) txn(ops ...Op) ([]OpResult, error) {
+	created := map[string]bool{}
+	txn := []txnOp{}
+	owner := []int{} // index of the namespace op of each txn op
+	add := func(i int, op txnOp) {
+		txn = append(txn, op)
+		owner = append(owner, i)
+	}
+	// Returns the index of the key to CAS on, or an error if the key does not exist.
+	index := func(k string, version Version) (uint64, error) {
+		if version != InvalidVersion {
+			return uint64(version), nil
+		}
+		p, _, err := this.kv.get(k, 0, 0, nil)
+		if err != nil {
+			return 0, err
+		} else if p == nil {
+			return 0, ErrNotExist
+		}
+		return p.ModifyIndex, nil
+	}
+
+	for i, op := range ops {
+		k := toKey(PathOf(op))
+		switch op := op.(type) {
+		case OpCheck:
+			idx, err := index(k, op.Version)
+			if err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			add(i, txnOp{Verb: "check-index", Key: k, Index: idx})
+		case OpCreate:
+			if err := this.createTxnParents(k, created); err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			created[k] = true
+			add(i, txnOp{Verb: "cas", Key: k, Value: op.Value, Index: 0})
+			if op.Ephemeral {
+				session, err := this.getSession()
+				if err != nil {
+					return AbortTxn(ops, i, err)
+				}
+				add(i, txnOp{Verb: "lock", Key: k, Value: op.Value, Session: session})
+			}
+		case OpSet:
+			if created[k] && op.Version == InvalidVersion {
+				add(i, txnOp{Verb: "set", Key: k, Value: op.Value})
+				break
+			}
+			idx, err := index(k, op.Version)
+			if err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			add(i, txnOp{Verb: "cas", Key: k, Value: op.Value, Index: idx})
+		case OpDelete:
+			keys, _, err := this.kv.keys(k+"/", 0, 0, nil)
+			if err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			if len(children(k, keys)) > 0 {
+				return AbortTxn(ops, i, ErrNotEmpty)
+			}
+			if created[k] && op.Version == InvalidVersion {
+				add(i, txnOp{Verb: "delete", Key: k})
+				break
+			}
+			idx, err := index(k, op.Version)
+			if err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			add(i, txnOp{Verb: "delete-cas", Key: k, Index: idx})
+		}
+	}
+
+	pairs, errs, err := this.kv.txn(txn)
+	if err != nil {
+		return AbortTxn(ops, -1, err)
+	}
+	if len(errs) > 0 {
+		i := -1
+		if errs[0].OpIndex >= 0 && errs[0].OpIndex < len(owner) {
+			i = owner[errs[0].OpIndex]
+		}
+		return AbortTxn(ops, i, txnFailure(ops, i, errs[0]))
+	}
+
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		results[i] = OpResult{Path: PathOf(op), Version: InvalidVersion}
+		if _, isDelete := op.(OpDelete); isDelete {
+			this.forgetSequence(toKey(PathOf(op)))
+		}
+	}
+	for i, j := 0, 0; i < len(txn) && j < len(pairs); i++ {
+		if _, isDelete := ops[owner[i]].(OpDelete); isDelete {
+			continue // Deletes have no result.
+		}
+		if pairs[j] != nil {
+			results[owner[i]].Version = Version(pairs[j].ModifyIndex)
+		}
+		j++
+	}
+	return results, nil
+}
+
+// Maps the error reported by consul to the namespace errors.  Consul only reports a message, so a
+// failed create means the node exists, and anything else is a version conflict unless the key is gone.
+func txnFailure(ops []Op, i int, e txnError) error {
+	if i < 0 {
+		return fmt.Errorf("consul-txn-failed: %s", e.What)
+	}
+	if _, isCreate := ops[i].(OpCreate); isCreate {
+		return ErrNodeExists
+	}
+	if strings.Contains(e.What, "doesn't exist") || strings.Contains(e.What, "not exist") {
+		return ErrNotExist
+	}
+	return ErrBadVersion
+}
+
+// Creates the missing parents of the key, except the ones that are created in the transaction.
+func (this *registry) createTxnParents(key string, created map[string]bool) error {
+	parts := strings.Split(key, "/")
+	for i := 1; i < len(parts); i++ {
+		if created[strings.Join(parts[:i], "/")] {
+			return nil
+		}
+	}
+	return this.createParents(key)
+}
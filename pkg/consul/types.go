@@ -0,0 +1,49 @@
+package consul
+
+import (
+	"time"
+)
+
+const (
+	// Defaults to the local agent.
+	DefaultConsulHost = "localhost:8500"
+
+	// Environment variable to use when host is not specified explicitly.
+	EnvConsulHost = "CONSUL_HTTP_ADDR"
+
+	// Max time a blocking query used by triggers waits before the query is reissued.
+	DefaultWaitTime = 1 * time.Minute
+
+	// TTL of the session holding the ephemeral nodes.  The session is renewed at half this interval.
+	DefaultSessionTTL = 15 * time.Second
+
+	// Top level key reserved for the registry's own keys, e.g. the counters of sequential nodes.  It is
+	// not listed as a node.
+	ReservedKey = ".ns"
+)
+
+// A key value pair as returned by the KV api.
+type pair struct {
+	Key         string
+	Value       []byte // base64 in json
+	CreateIndex uint64
+	ModifyIndex uint64
+	LockIndex   uint64
+	Flags       uint64
+	Session     string `json:",omitempty"`
+}
+
+// A KV operation in a transaction, as sent to the txn api.
+type txnOp struct {
+	Verb    string
+	Key     string
+	Value   []byte `json:",omitempty"`
+	Index   uint64
+	Session string `json:",omitempty"`
+}
+
+// An error from a rolled back transaction.  OpIndex is the index of the failed operation.
+type txnError struct {
+	OpIndex int
+	What    string
+}
@@ -0,0 +1,16 @@
+package consul
+
+import (
+	"github.com/golang/glog"
+	"os"
+)
+
+// Determines the consul agent address from ENV variable or use the default.
+func Host() string {
+	host := DefaultConsulHost
+	if fromEnv := os.Getenv(EnvConsulHost); len(fromEnv) > 0 {
+		host = fromEnv
+	}
+	glog.Infoln("consul-host:", host)
+	return host
+}
@@ -0,0 +1,171 @@
+package consul
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Snapshot of the state of a key as seen by a watcher.
+type state struct {
+	exists  bool
+	version Version
+	value   []byte
+	members []string
+}
+
+// Runs a blocking query with the index from the previous query and returns the new state and index.
+type queryFunc func(waitIndex uint64, cancel <-chan struct{}) (*state, uint64, error)
+
+// Compares the previous and current states and returns the events to send, if any.
+type diffFunc func(prev, current *state) []Event
+
+func (this *registry) queryKey(k string) queryFunc {
+	return func(waitIndex uint64, cancel <-chan struct{}) (*state, uint64, error) {
+		p, index, err := this.kv.get(k, waitIndex, this.wait, cancel)
+		if err != nil {
+			return nil, 0, err
+		}
+		if p == nil {
+			return &state{version: InvalidVersion}, index, nil
+		}
+		return &state{exists: true, version: Version(p.ModifyIndex), value: p.Value}, index, nil
+	}
+}
+
+func (this *registry) queryMembers(k string) queryFunc {
+	prefix := k + "/"
+	if k == "" {
+		prefix = ""
+	}
+	return func(waitIndex uint64, cancel <-chan struct{}) (*state, uint64, error) {
+		keys, index, err := this.kv.keys(prefix, waitIndex, this.wait, cancel)
+		if err != nil {
+			return nil, 0, err
+		}
+		names := []string{}
+		for _, p := range children(k, keys) {
+			names = append(names, p.String())
+		}
+		return &state{exists: len(names) > 0, members: names}, index, nil
+	}
+}
+
+func diffNode(kind EventKind, path Path) diffFunc {
+	return func(prev, current *state) []Event {
+		fire := false
+		switch kind {
+		case EventCreate:
+			fire = !prev.exists && current.exists
+		case EventDelete:
+			fire = prev.exists && !current.exists
+		case EventChange:
+			fire = prev.exists && current.exists && prev.version != current.version
+		}
+		if fire {
+			return []Event{{Kind: kind, Path: path.String(), Version: current.version, Value: current.value}}
+		}
+		return nil
+	}
+}
+
+// Fires according to the criteria of the trigger.  The matcher is set up with the initial state
+// on the first diff.
+func diffMembers(t Members) diffFunc {
+	var matcher *MembersMatcher
+	return func(prev, current *state) []Event {
+		if matcher == nil {
+			matcher = NewMembersMatcher(t, prev.members)
+		}
+		if change := matcher.Update(current.members); change != nil {
+			return []Event{change.Event()}
+		}
+		return nil
+	}
+}
+
+// Queries that fail are retried, since the agent may be unreachable for a while.  If consul refuses
+// the query, with a 4xx status, an EventError is delivered and the channel is closed.
+func (this *registry) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	if m, is := t.(*Members); is {
+		t = *m
+	}
+	var query queryFunc
+	var diff diffFunc
+	var path Path
+	switch t := t.(type) {
+	case Create:
+		query, diff, path = this.queryKey(toKey(t.Path)), diffNode(EventCreate, t.Path), t.Path
+	case Change:
+		query, diff, path = this.queryKey(toKey(t.Path)), diffNode(EventChange, t.Path), t.Path
+	case Delete:
+		query, diff, path = this.queryKey(toKey(t.Path)), diffNode(EventDelete, t.Path), t.Path
+	case Members:
+		query, diff, path = this.queryMembers(toKey(t.Path)), diffMembers(t), t.Path
+	default:
+		return nil, ErrBadTrigger
+	}
+
+	// The initial state is taken here so that changes made right after the trigger is set are not missed.
+	current, index, err := query(0, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 8)
+	cancel := make(chan struct{})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-this.done:
+		}
+		close(cancel)
+	}()
+
+	go func() {
+		defer close(events)
+		for {
+			next, nextIndex, err := query(index, cancel)
+			select {
+			case <-cancel:
+				return
+			default:
+			}
+			if status, is := err.(*UnexpectedStatus); is && status.Status < 500 {
+				// Refused by consul, e.g. denied by an acl.  Asking again won't help.
+				select {
+				case events <- Event{Kind: EventError, Path: path.String(), Version: InvalidVersion, Err: err}:
+				case <-cancel:
+				}
+				return
+			}
+			if err != nil {
+				glog.Warningln("consul-watch: Query failed, retrying. err=", err)
+				select {
+				case <-time.After(time.Second):
+				case <-cancel:
+					return
+				}
+				continue
+			}
+			// Per consul docs, reset the index if it goes backwards.  Never use 0 since that's not blocking.
+			if nextIndex < index || nextIndex == 0 {
+				nextIndex = 1
+			}
+			for _, e := range diff(current, next) {
+				select {
+				case events <- e:
+				case <-cancel:
+					return
+				}
+			}
+			current, index = next, nextIndex
+		}
+	}()
+	return events, nil
+}
@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"encoding/json"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	net "net/url"
+	"sync"
+)
+
+// A registered instance.  Closing it removes the instance from the registry.
+type Announcement struct {
+	reg  namespace.Registry
+	path namespace.Path
+	done chan struct{}
+	once sync.Once
+	err  error
+}
+
+// Registers the instance as an ephemeral node at <path of url>/<name>/<host:port>, with the instance
+// as json in the value.  Since the node is ephemeral, the instance goes away with the process.  In zk,
+// the client recreates the node when the session is reestablished.  The instance is removed when the
+// announcement is closed or the context is done.
+func Announce(ctx context.Context, url string, instance ServiceInstance) (*Announcement, error) {
+	if instance.Name == "" {
+		return nil, ErrNoName
+	}
+	u, err := net.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(instance)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := namespace.Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	p := namespace.NewPath(u.Path, instance.Name, instance.HostPort())
+	if _, err := reg.Put(p, value, true); err != nil {
+		reg.Close()
+		return nil, err
+	}
+	glog.Infoln("Announced", instance.Name, "at", p)
+	a := &Announcement{reg: reg, path: p, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			a.Close()
+		case <-a.done:
+		}
+	}()
+	return a, nil
+}
+
+func (this *Announcement) Path() namespace.Path {
+	return this.path
+}
+
+func (this *Announcement) Close() error {
+	this.once.Do(func() {
+		close(this.done)
+		if err := this.reg.Delete(this.path); err != nil && err != namespace.ErrNotExist {
+			this.err = err
+		}
+		this.reg.Close()
+	})
+	return this.err
+}
@@ -0,0 +1,150 @@
+package discovery
+
+import (
+	"encoding/json"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"net/http"
+	net "net/url"
+	"sync"
+)
+
+// The live set of instances of a service.  The set is reloaded whenever the members of the service
+// node change.  Services implements server.Upstream, so a ReverseProxy can forward to the instances.
+type Services struct {
+	reg  namespace.Registry
+	path namespace.Path
+
+	lock      sync.Mutex
+	instances []ServiceInstance
+	next      int
+	updates   []chan []ServiceInstance
+	stop      context.CancelFunc
+	once      sync.Once
+}
+
+// Finds the instances of the service at the url, e.g. zk://host:2181/services/web for the instances
+// announced with zk://host:2181/services and name web.  The set is kept up to date until it's closed or
+// the context is done.
+func Discover(ctx context.Context, url string) (*Services, error) {
+	u, err := net.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := namespace.Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	p := namespace.NewPath(u.Path)
+	// Some backends can't watch the members of a node that does not exist yet.
+	if _, err := reg.Txn(namespace.OpCreate{Path: p, Value: []byte{}}); err != nil {
+		if txnErr, is := err.(*namespace.TxnError); !is || txnErr.Err != namespace.ErrNodeExists {
+			reg.Close()
+			return nil, err
+		}
+	}
+	watch, stop := context.WithCancel(ctx)
+	members, err := reg.Trigger(watch, namespace.Members{Path: p})
+	if err != nil {
+		stop()
+		reg.Close()
+		return nil, err
+	}
+	services := &Services{reg: reg, path: p, stop: stop}
+	if err := services.reload(); err != nil {
+		services.Close()
+		return nil, err
+	}
+	go func() {
+		// The channel is closed when the context is done or the set is closed.
+		for _ = range members {
+			if err := services.reload(); err != nil {
+				glog.Warningln("Cannot reload instances of", p, "err=", err)
+			}
+		}
+		services.Close()
+	}()
+	return services, nil
+}
+
+func (this *Services) reload() error {
+	children, err := this.reg.List(this.path)
+	if err != nil {
+		return err
+	}
+	instances := []ServiceInstance{}
+	for _, child := range children {
+		value, _, err := this.reg.Get(child)
+		if err == namespace.ErrNotExist {
+			continue
+		} else if err != nil {
+			return err
+		}
+		instance := ServiceInstance{}
+		if err := json.Unmarshal(value, &instance); err != nil {
+			glog.Warningln("Skipping bad instance at", child, "err=", err)
+			continue
+		}
+		instances = append(instances, instance)
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.instances = instances
+	for _, u := range this.updates {
+		// Replaces the update not received yet, if any.  The only sender holds the lock, so there's room.
+		select {
+		case <-u:
+		default:
+		}
+		u <- instances
+	}
+	return nil
+}
+
+// Returns the current instances.
+func (this *Services) Instances() []ServiceInstance {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return append([]ServiceInstance{}, this.instances...)
+}
+
+// Returns a channel that receives the instances whenever they change.  If the receiver is not keeping
+// up, an update not received yet is replaced by the newer one, so the last one received is current.
+func (this *Services) Updates() <-chan []ServiceInstance {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	u := make(chan []ServiceInstance, 1)
+	this.updates = append(this.updates, u)
+	return u
+}
+
+// The host:port of the current instances.
+func (this *Services) Hosts() []string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	hosts := []string{}
+	for _, instance := range this.instances {
+		hosts = append(hosts, instance.HostPort())
+	}
+	return hosts
+}
+
+// Picks an instance round robin and returns its host:port.
+func (this *Services) Select(req *http.Request) (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if len(this.instances) == 0 {
+		return "", ErrNoInstances
+	}
+	this.next = (this.next + 1) % len(this.instances)
+	return this.instances[this.next].HostPort(), nil
+}
+
+func (this *Services) Close() error {
+	this.once.Do(func() {
+		this.stop()
+		this.reg.Close()
+	})
+	return nil
+}
@@ -0,0 +1,129 @@
+package discovery
+
+import (
+	"fmt"
+	_ "github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/server"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var delay = 200 * time.Millisecond
+
+func TestDiscovery(t *testing.T) { TestingT(t) }
+
+type TestSuiteDiscovery struct{}
+
+var _ = Suite(&TestSuiteDiscovery{})
+
+func (suite *TestSuiteDiscovery) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteDiscovery) TearDownSuite(c *C) {
+}
+
+// Starts a backend that replies with its name and returns the instance for it.
+func backend(c *C, name string) (*httptest.Server, ServiceInstance) {
+	s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(resp, name)
+	}))
+	host, port, err := net.SplitHostPort(s.Listener.Addr().String())
+	c.Assert(err, IsNil)
+	p, err := strconv.Atoi(port)
+	c.Assert(err, IsNil)
+	return s, ServiceInstance{Name: "web", Host: host, Port: p, Metadata: map[string]string{"name": name}}
+}
+
+func get(c *C, url string) (int, string) {
+	resp, err := http.Get(url)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	return resp.StatusCode, string(body)
+}
+
+func (suite *TestSuiteDiscovery) TestAnnounceDiscover(c *C) {
+	ctx := context.Background()
+	services, err := Discover(ctx, "mem://discovery/services/web")
+	c.Assert(err, IsNil)
+	defer services.Close()
+	c.Assert(len(services.Instances()), Equals, 0)
+	updates := services.Updates()
+
+	_, err = Announce(ctx, "mem://discovery/services", ServiceInstance{Host: "a"})
+	c.Assert(err, Equals, ErrNoName)
+
+	s1, i1 := backend(c, "one")
+	defer s1.Close()
+	a1, err := Announce(ctx, "mem://discovery/services", i1)
+	c.Assert(err, IsNil)
+	c.Assert(a1.Path().String(), Equals, "/services/web/"+i1.HostPort())
+
+	select {
+	case instances := <-updates:
+		c.Assert(len(instances), Equals, 1)
+		c.Assert(instances[0], DeepEquals, i1)
+	case <-time.After(delay):
+		c.Fatal("Should get update")
+	}
+
+	actx, cancel := context.WithCancel(ctx)
+	s2, i2 := backend(c, "two")
+	defer s2.Close()
+	_, err = Announce(actx, "mem://discovery/services", i2)
+	c.Assert(err, IsNil)
+	<-updates
+	c.Assert(len(services.Instances()), Equals, 2)
+
+	// Proxy to the instances
+	proxy := httptest.NewServer(server.NewReverseProxy().SetForwardUpstream(services))
+	defer proxy.Close()
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		code, body := get(c, proxy.URL+"/")
+		c.Assert(code, Equals, http.StatusOK)
+		seen[body] = true
+	}
+	c.Assert(seen, DeepEquals, map[string]bool{"one": true, "two": true})
+
+	// Withdraw by cancelling the context, and by closing
+	cancel()
+	<-updates
+	c.Assert(services.Instances(), DeepEquals, []ServiceInstance{i1})
+
+	c.Assert(a1.Close(), IsNil)
+	<-updates
+	c.Assert(len(services.Instances()), Equals, 0)
+
+	code, _ := get(c, proxy.URL+"/")
+	c.Assert(code, Equals, http.StatusServiceUnavailable)
+}
+
+func (suite *TestSuiteDiscovery) TestUpdatesKeepLast(c *C) {
+	ctx := context.Background()
+	services, err := Discover(ctx, "mem://discovery-last/services/web")
+	c.Assert(err, IsNil)
+	defer services.Close()
+	updates := services.Updates()
+
+	// Not received while the instances change.
+	for _, host := range []string{"a", "b"} {
+		_, err := Announce(ctx, "mem://discovery-last/services", ServiceInstance{Name: "web", Host: host, Port: 80})
+		c.Assert(err, IsNil)
+	}
+	deadline := time.Now().Add(delay)
+	for len(services.Instances()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(len(services.Instances()), Equals, 2)
+	c.Assert(<-updates, DeepEquals, services.Instances())
+	c.Assert(services.Hosts(), DeepEquals, []string{"a:80", "b:80"})
+}
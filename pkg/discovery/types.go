@@ -0,0 +1,23 @@
+package discovery
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoInstances = errors.New("error-no-instances")
+	ErrNoName      = errors.New("error-no-service-name")
+)
+
+// An instance of a service, stored as json in the value of its node.
+type ServiceInstance struct {
+	Name     string            `json:"name"`
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (this ServiceInstance) HostPort() string {
+	return fmt.Sprintf("%s:%d", this.Host, this.Port)
+}
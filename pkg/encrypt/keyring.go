@@ -0,0 +1,175 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/resource"
+	"golang.org/x/net/context"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Marks an encrypted value.  The value is prefix + key id + ":" + base64 of the nonce and the sealed data,
+// with "seq:" before the base64 for the values of sequential nodes.
+const Prefix = "encrypted:"
+
+var (
+	ErrBadKey        = errors.New("error-bad-key")
+	ErrBadKeyId      = errors.New("error-bad-key-id")
+	ErrUnknownKey    = errors.New("error-unknown-key")
+	ErrNoCurrentKey  = errors.New("error-no-current-key")
+	ErrBadCiphertext = errors.New("error-bad-ciphertext")
+	ErrNotEncrypted  = errors.New("error-not-encrypted")
+)
+
+// A set of AES keys by id.  Values are encrypted with the current key and decrypted with the key named
+// in the value, so keys can be rotated by adding a new key, making it current and keeping the old ones
+// until all the values are written again.
+type Keyring struct {
+	lock    sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string]cipher.AEAD{}}
+}
+
+// Adds the key, which must be 16, 24 or 32 bytes for AES-128, AES-192 or AES-256.  The first key
+// added becomes the current key.
+func (this *Keyring) Add(id string, key []byte) error {
+	if id == "" || strings.Contains(id, ":") {
+		return ErrBadKeyId
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return ErrBadKey
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.keys[id] = gcm
+	if this.current == "" {
+		this.current = id
+	}
+	return nil
+}
+
+// Fetches the key from the url, e.g. file:///etc/keys/2016-01 or zk://host:2181/keys/2016-01, and adds
+// it.  The key can be the raw bytes or base64 encoded.
+func (this *Keyring) Load(ctx context.Context, id, url string) error {
+	key, err := resource.Fetch(ctx, url)
+	if err != nil {
+		return err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(key))); err == nil {
+		switch len(decoded) {
+		case 16, 24, 32:
+			key = decoded
+		}
+	}
+	return this.Add(id, key)
+}
+
+// Sets the key used for encrypting.
+func (this *Keyring) Use(id string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if _, has := this.keys[id]; !has {
+		return ErrUnknownKey
+	}
+	this.current = id
+	return nil
+}
+
+func (this *Keyring) Current() string {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	return this.current
+}
+
+// Encrypts the value of the node at the path.  The path is bound to the value, so it can't be decrypted
+// as the value of another node, e.g. after a copy of a secret to a place readable by more people.
+func (this *Keyring) Encrypt(path Path, plain []byte) ([]byte, error) {
+	return this.seal(path, "", plain)
+}
+
+// Encrypts the value of a sequential node created at the path, whose name is the base name of the path
+// followed by the sequence number.  The path without the sequence number is bound to the value.
+func (this *Keyring) EncryptSequential(path Path, plain []byte) ([]byte, error) {
+	return this.seal(path, sequentialMode+":", plain)
+}
+
+// Marks the values of sequential nodes.
+const sequentialMode = "seq"
+
+// The additional data authenticated with the value: the key id and the path.
+func additionalData(id string, path Path) []byte {
+	return []byte(id + "\x00" + NewPath(path.String()).String())
+}
+
+func (this *Keyring) seal(path Path, mode string, plain []byte) ([]byte, error) {
+	this.lock.RLock()
+	id, gcm := this.current, this.keys[this.current]
+	this.lock.RUnlock()
+	if gcm == nil {
+		return nil, ErrNoCurrentKey
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plain, additionalData(id, path))
+	return []byte(Prefix + id + ":" + mode + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Returns true if the value is encrypted.
+func IsEncrypted(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(Prefix))
+}
+
+// Decrypts the value of the node at the path.  Values that are not encrypted are returned as is.
+// Values encrypted for another path fail with ErrBadCiphertext.
+func (this *Keyring) Decrypt(path Path, value []byte) ([]byte, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	parts := strings.SplitN(string(value[len(Prefix):]), ":", 3)
+	switch {
+	case len(parts) == 3 && parts[1] == sequentialMode:
+		// Bound to the path without the sequence number.
+		name := path.String()
+		if len(name) < SequenceDigits {
+			return nil, ErrBadCiphertext
+		}
+		path = NewPath(name[:len(name)-SequenceDigits])
+		parts = []string{parts[0], parts[2]}
+	case len(parts) != 2:
+		return nil, ErrBadCiphertext
+	}
+	id := parts[0]
+	this.lock.RLock()
+	gcm, has := this.keys[id]
+	this.lock.RUnlock()
+	if !has {
+		return nil, ErrUnknownKey
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return nil, ErrBadCiphertext
+	}
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData(id, path))
+	if err != nil {
+		return nil, ErrBadCiphertext
+	}
+	return plain, nil
+}
@@ -0,0 +1,206 @@
+package encrypt
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	net "net/url"
+	"strings"
+)
+
+// A registry that encrypts the values written under the given paths, or all values if there are no
+// paths, and decrypts the encrypted values it reads, wherever they are, including the values of the
+// events of triggers.  Values that are not encrypted are read as is, so existing plain values keep
+// working until they are written again.
+type registry struct {
+	Registry
+	keys   *Keyring
+	paths  []Path
+	strict bool
+}
+
+func New(reg Registry, keys *Keyring, paths ...Path) Registry {
+	return &registry{Registry: reg, keys: keys, paths: paths}
+}
+
+// Like New, but the values read under the paths must be encrypted, so that someone who can write to
+// the backend can't swap a secret for a plain value of their choice.  Plain values fail with
+// ErrNotEncrypted, except empty ones, e.g. of parents created implicitly.
+func NewStrict(reg Registry, keys *Keyring, paths ...Path) Registry {
+	return &registry{Registry: reg, keys: keys, paths: paths, strict: true}
+}
+
+// Makes Dial return registries that encrypt the values under the urls, e.g. zk://host:2181/secrets.
+// Consumers such as the get template function then read the decrypted values without any change.
+func Install(keys *Keyring, urls ...string) error {
+	return install(New, keys, urls)
+}
+
+// Like Install, with registries that reject plain values under the urls.  See NewStrict.
+func InstallStrict(keys *Keyring, urls ...string) error {
+	return install(NewStrict, keys, urls)
+}
+
+func install(decorate func(Registry, *Keyring, ...Path) Registry, keys *Keyring, urls []string) error {
+	parsed := []*net.URL{}
+	for _, url := range urls {
+		u, err := net.Parse(url)
+		if err != nil {
+			return err
+		}
+		parsed = append(parsed, u)
+	}
+	RegisterDecorator(func(url net.URL, reg Registry) Registry {
+		paths := []Path{}
+		for _, u := range parsed {
+			if u.Scheme == url.Scheme && u.Host == url.Host {
+				paths = append(paths, NewPath(u.Path))
+			}
+		}
+		if len(paths) == 0 {
+			return reg
+		}
+		return decorate(reg, keys, paths...)
+	})
+	return nil
+}
+
+func (this *registry) encrypted(key Path) bool {
+	if len(this.paths) == 0 {
+		return true
+	}
+	k := NewPath(key.String()).String()
+	for _, p := range this.paths {
+		if p.String() == "/" || k == p.String() || strings.HasPrefix(k, p.String()+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *registry) encrypt(key Path, value []byte) ([]byte, error) {
+	if !this.encrypted(key) {
+		return value, nil
+	}
+	return this.keys.Encrypt(key, value)
+}
+
+func (this *registry) decrypt(key Path, value []byte) ([]byte, error) {
+	if this.strict && len(value) > 0 && !IsEncrypted(value) && this.encrypted(key) {
+		return nil, ErrNotEncrypted
+	}
+	return this.keys.Decrypt(key, value)
+}
+
+func (this *registry) Get(key Path) ([]byte, Version, error) {
+	value, version, err := this.Registry.Get(key)
+	if err != nil {
+		return value, version, err
+	}
+	plain, err := this.decrypt(key, value)
+	if err != nil {
+		return nil, InvalidVersion, err
+	}
+	return plain, version, nil
+}
+
+// Decrypts the values of the events.  A value that can't be decrypted stops the trigger with an
+// EventError.
+func (this *registry) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := this.Registry.Trigger(ctx, t)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		defer func() {
+			cancel()
+			for _ = range events {
+			}
+		}()
+		for e := range events {
+			if e.Value != nil {
+				plain, err := this.decrypt(NewPath(e.Path), e.Value)
+				if err != nil {
+					e = Event{Kind: EventError, Path: e.Path, Version: InvalidVersion, Err: err}
+				} else {
+					e.Value = plain
+				}
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+			if e.Kind == EventError {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// The size is the length of the decrypted value.
+func (this *registry) Stat(key Path) (*Stat, error) {
+	stat, err := this.Registry.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	value, _, err := this.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	stat.Size = len(value)
+	return stat, nil
+}
+
+func (this *registry) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	sealed, err := this.encrypt(key, value)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	return this.Registry.Put(key, sealed, ephemeral)
+}
+
+func (this *registry) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	sealed, err := this.encrypt(key, value)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	return this.Registry.PutVersion(key, sealed, version)
+}
+
+func (this *registry) Txn(ops ...Op) ([]OpResult, error) {
+	sealed := make([]Op, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op := op.(type) {
+		case OpCreate:
+			if op.Sequential && this.encrypted(op.Path) {
+				op.Value, err = this.keys.EncryptSequential(op.Path, op.Value)
+			} else {
+				op.Value, err = this.encrypt(op.Path, op.Value)
+			}
+			sealed[i] = op
+		case OpSet:
+			op.Value, err = this.encrypt(op.Path, op.Value)
+			sealed[i] = op
+		default:
+			sealed[i] = op
+		}
+		if err != nil {
+			return AbortTxn(ops, i, err)
+		}
+	}
+	return this.Registry.Txn(sealed...)
+}
+
+// Passes the optional interface through.
+func (this *registry) IsEphemeral(key Path) (bool, error) {
+	if reporter, is := this.Registry.(EphemeralReporter); is {
+		return reporter.IsEphemeral(key)
+	}
+	return false, nil
+}
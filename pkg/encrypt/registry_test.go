@@ -0,0 +1,225 @@
+package encrypt
+
+import (
+	"encoding/base64"
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/template"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	net "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncrypt(t *testing.T) { TestingT(t) }
+
+type TestSuiteEncrypt struct{}
+
+var _ = Suite(&TestSuiteEncrypt{})
+
+func (suite *TestSuiteEncrypt) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteEncrypt) TearDownSuite(c *C) {
+}
+
+func (suite *TestSuiteEncrypt) TestKeyring(c *C) {
+	p := namespace.NewPath("/secrets/a")
+	keys := NewKeyring()
+	_, err := keys.Encrypt(p, []byte("secret"))
+	c.Assert(err, Equals, ErrNoCurrentKey)
+	c.Assert(keys.Add("k1", []byte("short")), Equals, ErrBadKey)
+	c.Assert(keys.Add("k:1", []byte("0123456789abcdef")), Equals, ErrBadKeyId)
+
+	// Load from a file, base64 encoded
+	dir, err := ioutil.TempDir("", "encrypt")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "k1")
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	c.Assert(ioutil.WriteFile(file, []byte(key+"\n"), 0600), IsNil)
+	c.Assert(keys.Load(context.Background(), "k1", "file://"+file), IsNil)
+	c.Assert(keys.Current(), Equals, "k1")
+
+	sealed, err := keys.Encrypt(p, []byte("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(sealed), Prefix+"k1:"), Equals, true)
+	plain, err := keys.Decrypt(p, sealed)
+	c.Assert(err, IsNil)
+	c.Assert(string(plain), Equals, "secret")
+
+	// Rotation: old values still decrypt
+	c.Assert(keys.Add("k2", []byte("fedcba9876543210")), IsNil)
+	c.Assert(keys.Use("k2"), IsNil)
+	sealed2, err := keys.Encrypt(p, []byte("secret"))
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(string(sealed2), Prefix+"k2:"), Equals, true)
+	plain, err = keys.Decrypt(p, sealed)
+	c.Assert(err, IsNil)
+	c.Assert(string(plain), Equals, "secret")
+
+	// Tampering and unknown keys
+	_, err = keys.Decrypt(p, sealed[:len(sealed)-4])
+	c.Assert(err, Equals, ErrBadCiphertext)
+	_, err = NewKeyring().Decrypt(p, sealed)
+	c.Assert(err, Equals, ErrUnknownKey)
+
+	// Bound to the path
+	_, err = keys.Decrypt(namespace.NewPath("/secrets/b"), sealed)
+	c.Assert(err, Equals, ErrBadCiphertext)
+	sealed, err = keys.EncryptSequential(namespace.NewPath("/queue/item-"), []byte("secret"))
+	c.Assert(err, IsNil)
+	plain, err = keys.Decrypt(namespace.NewPath("/queue/item-0000000007"), sealed)
+	c.Assert(err, IsNil)
+	c.Assert(string(plain), Equals, "secret")
+	_, err = keys.Decrypt(namespace.NewPath("/other/item-0000000007"), sealed)
+	c.Assert(err, Equals, ErrBadCiphertext)
+	plain, err = NewKeyring().Decrypt(p, []byte("plain"))
+	c.Assert(err, IsNil)
+	c.Assert(string(plain), Equals, "plain")
+}
+
+func (suite *TestSuiteEncrypt) TestInstall(c *C) {
+	ctx := context.Background()
+	keys := NewKeyring()
+	c.Assert(keys.Add("k1", []byte("0123456789abcdef")), IsNil)
+	c.Assert(Install(keys, "mem://encrypt/secrets"), IsNil)
+
+	// A session that is not dialed, to see what is stored
+	raw, err := mem.NewService(ctx, net.URL{Scheme: "mem", Host: "encrypt"}, nil)
+	c.Assert(err, IsNil)
+	defer raw.Close()
+	_, err = raw.Put(namespace.NewPath("/secrets/old"), []byte("plain"), false)
+	c.Assert(err, IsNil)
+
+	reg, err := namespace.Dial(ctx, "mem://encrypt")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	_, err = reg.Put(namespace.NewPath("/secrets/db/password"), []byte("s3cret"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Txn(namespace.OpCreate{Path: namespace.NewPath("/secrets/token"), Value: []byte("t0ken")})
+	c.Assert(err, IsNil)
+	_, err = reg.Put(namespace.NewPath("/public"), []byte("public"), false)
+	c.Assert(err, IsNil)
+
+	for p, v := range map[string]string{"/secrets/db/password": "s3cret", "/secrets/token": "t0ken"} {
+		stored, _, err := raw.Get(namespace.NewPath(p))
+		c.Assert(err, IsNil)
+		c.Assert(IsEncrypted(stored), Equals, true)
+		c.Assert(strings.Contains(string(stored), v), Equals, false)
+
+		value, _, err := reg.Get(namespace.NewPath(p))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, v)
+	}
+	stored, _, err := raw.Get(namespace.NewPath("/public"))
+	c.Assert(err, IsNil)
+	c.Assert(string(stored), Equals, "public")
+	value, _, err := reg.Get(namespace.NewPath("/secrets/old"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "plain")
+
+	// Templates read the plain values
+	_, err = reg.Put(namespace.NewPath("/tmpl"), []byte(`password={{get "mem://encrypt/secrets/db/password"}}`), false)
+	c.Assert(err, IsNil)
+	applied, err := template.Execute(ctx, "mem://encrypt/tmpl")
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "password=s3cret")
+}
+
+func (suite *TestSuiteEncrypt) TestPathBound(c *C) {
+	ctx := context.Background()
+	keys := NewKeyring()
+	c.Assert(keys.Add("k1", []byte("0123456789abcdef")), IsNil)
+	raw, err := mem.NewService(ctx, net.URL{Scheme: "mem", Host: "encrypt-bound"}, nil)
+	c.Assert(err, IsNil)
+	defer raw.Close()
+	reg := New(raw, keys, namespace.NewPath("/secrets"))
+
+	_, err = reg.Put(namespace.NewPath("/secrets/admin"), []byte("root"), false)
+	c.Assert(err, IsNil)
+
+	// Copied by someone who can write to the backend but has no key.
+	stored, _, err := raw.Get(namespace.NewPath("/secrets/admin"))
+	c.Assert(err, IsNil)
+	_, err = raw.Put(namespace.NewPath("/secrets/guest"), stored, false)
+	c.Assert(err, IsNil)
+	_, _, err = reg.Get(namespace.NewPath("/secrets/guest"))
+	c.Assert(err, Equals, ErrBadCiphertext)
+
+	// Sequential nodes
+	created, err := namespace.CreateSequential(reg, namespace.NewPath("/secrets/queue/item-"), []byte("job"), false)
+	c.Assert(err, IsNil)
+	value, _, err := reg.Get(created)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "job")
+}
+
+func (suite *TestSuiteEncrypt) TestStrict(c *C) {
+	ctx := context.Background()
+	keys := NewKeyring()
+	c.Assert(keys.Add("k1", []byte("0123456789abcdef")), IsNil)
+	raw, err := mem.NewService(ctx, net.URL{Scheme: "mem", Host: "encrypt-strict"}, nil)
+	c.Assert(err, IsNil)
+	defer raw.Close()
+	reg := NewStrict(raw, keys, namespace.NewPath("/secrets"))
+
+	_, err = reg.Put(namespace.NewPath("/secrets/db/password"), []byte("s3cret"), false)
+	c.Assert(err, IsNil)
+	value, _, err := reg.Get(namespace.NewPath("/secrets/db/password"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "s3cret")
+
+	// Swapped for a plain value
+	_, err = raw.Put(namespace.NewPath("/secrets/db/password"), []byte("mine"), false)
+	c.Assert(err, IsNil)
+	_, _, err = reg.Get(namespace.NewPath("/secrets/db/password"))
+	c.Assert(err, Equals, ErrNotEncrypted)
+
+	// Parents created implicitly, and plain values elsewhere
+	value, _, err = reg.Get(namespace.NewPath("/secrets/db"))
+	c.Assert(err, IsNil)
+	c.Assert(len(value), Equals, 0)
+	_, err = raw.Put(namespace.NewPath("/public"), []byte("public"), false)
+	c.Assert(err, IsNil)
+	value, _, err = reg.Get(namespace.NewPath("/public"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "public")
+}
+
+func (suite *TestSuiteEncrypt) TestTrigger(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keys := NewKeyring()
+	c.Assert(keys.Add("k1", []byte("0123456789abcdef")), IsNil)
+	raw, err := mem.NewService(ctx, net.URL{Scheme: "mem", Host: "encrypt-trigger"}, nil)
+	c.Assert(err, IsNil)
+	defer raw.Close()
+	reg := NewStrict(raw, keys, namespace.NewPath("/secrets"))
+
+	p := namespace.NewPath("/secrets/token")
+	_, err = reg.Put(p, []byte("t0"), false)
+	c.Assert(err, IsNil)
+	events, err := reg.Trigger(ctx, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+
+	_, err = reg.Put(p, []byte("t1"), false)
+	c.Assert(err, IsNil)
+	e := <-events
+	c.Assert(e.Kind, Equals, namespace.EventChange)
+	c.Assert(string(e.Value), Equals, "t1")
+
+	// A plain value stops the trigger.
+	_, err = raw.Put(p, []byte("mine"), false)
+	c.Assert(err, IsNil)
+	e = <-events
+	c.Assert(e.Kind, Equals, namespace.EventError)
+	c.Assert(e.Err, Equals, ErrNotEncrypted)
+	_, open := <-events
+	c.Assert(open, Equals, false)
+}
@@ -0,0 +1,45 @@
+package file
+
+import (
+	"golang.org/x/net/context"
+	"os"
+	"time"
+)
+
+type pollIntervalContextKey int
+
+const (
+	PollIntervalContextKey pollIntervalContextKey = 1
+)
+
+type rootContextKey int
+
+const (
+	RootContextKey rootContextKey = 1
+)
+
+func ContextGetPollInterval(ctx context.Context) time.Duration {
+	if v, ok := (ctx.Value(PollIntervalContextKey)).(time.Duration); ok {
+		return v
+	}
+	return DefaultPollInterval
+}
+
+func ContextPutPollInterval(ctx context.Context, t time.Duration) context.Context {
+	return context.WithValue(ctx, PollIntervalContextKey, t)
+}
+
+// The directory holding the nodes: from the context, else from the environment, else DefaultRoot.
+func ContextGetRoot(ctx context.Context) string {
+	if v, ok := (ctx.Value(RootContextKey)).(string); ok {
+		return v
+	}
+	if v := os.Getenv(EnvRoot); v != "" {
+		return v
+	}
+	return DefaultRoot
+}
+
+func ContextPutRoot(ctx context.Context, root string) context.Context {
+	return context.WithValue(ctx, RootContextKey, root)
+}
@@ -0,0 +1,306 @@
+package file
+
+import (
+	"encoding/json"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Each node is a directory on disk.  The value and metadata are kept in files of reserved names
+// inside the directory and the subdirectories are the children.  Any directory is a node, so
+// existing directory trees can be read as is, with empty values and version 0.
+
+func isReserved(name string) bool {
+	return strings.HasPrefix(name, ".ns.")
+}
+
+// Checks that a node can be written in the directory: no part of the path below the root may use a
+// reserved name, and the directory, if it exists, must be one.
+func writable(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(filepath.ToSlash(rel), "/") {
+		if isReserved(name) {
+			return ErrReservedName
+		}
+	}
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		return nil
+	case err != nil:
+		return err
+	case !info.IsDir():
+		return ErrNotNode
+	}
+	return nil
+}
+
+func exists(dir string) (bool, error) {
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+// Opens and locks the metadata file.  The caller must call unlock when done.
+func lock(dir string, exclusive bool) (*os.File, error) {
+	flag, how := os.O_RDONLY, syscall.LOCK_SH
+	if exclusive {
+		flag, how = os.O_RDWR|os.O_CREATE, syscall.LOCK_EX
+	}
+	f, err := os.OpenFile(filepath.Join(dir, MetaFile), flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func unlock(f *os.File) {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	f.Close()
+}
+
+func readMeta(f *os.File) (*meta, error) {
+	m := new(meta)
+	if f == nil {
+		return m, nil
+	}
+	buff, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(buff) == 0 {
+		return m, nil
+	}
+	if err := json.Unmarshal(buff, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeMeta(f *os.File, m *meta) error {
+	buff, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buff, 0)
+	return err
+}
+
+// Writes to a temp file and renames so readers never see a partial value.
+func writeValue(dir string, value []byte) error {
+	tmp, err := ioutil.TempFile(dir, ".ns.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(value)
+	tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, ValueFile))
+}
+
+func readValue(dir string) ([]byte, error) {
+	buff, err := ioutil.ReadFile(filepath.Join(dir, ValueFile))
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	return buff, err
+}
+
+func readNode(dir string) ([]byte, *meta, error) {
+	if has, err := exists(dir); err != nil {
+		return nil, nil, err
+	} else if !has {
+		return nil, nil, ErrNotExist
+	}
+	f, err := lock(dir, false)
+	switch {
+	case os.IsNotExist(err):
+		f = nil
+	case err != nil:
+		return nil, nil, err
+	default:
+		defer unlock(f)
+	}
+	m, err := readMeta(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	value, err := readValue(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, m, nil
+}
+
+// The modification time is the one of the value, or of the directory for nodes without a value.
+func statNode(dir string) (*Stat, error) {
+	value, m, err := readNode(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := children(dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(filepath.Join(dir, ValueFile))
+	if os.IsNotExist(err) {
+		info, err = os.Stat(dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Stat{
+		Version:   m.Version,
+		Created:   m.Created,
+		Modified:  info.ModTime(),
+		Size:      len(value),
+		Children:  len(names),
+		Ephemeral: m.Owner != "",
+		Owner:     m.Owner,
+	}, nil
+}
+
+// Returns the names of the child directories, sorted.
+func children(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, info := range infos {
+		if info.IsDir() && !isReserved(info.Name()) {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Creates the node and all its missing parents.  Parents are never ephemeral.
+func createNode(dir string, value []byte, owner string) (Version, error) {
+	parent := filepath.Dir(dir)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return InvalidVersion, err
+	}
+	_, pm, err := readNode(parent)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	if pm.Owner != "" {
+		return InvalidVersion, ErrNoChildrenForEphemerals
+	}
+	if err := os.Mkdir(dir, 0755); os.IsExist(err) {
+		return InvalidVersion, ErrNodeExists
+	} else if err != nil {
+		return InvalidVersion, err
+	}
+	f, err := lock(dir, true)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	defer unlock(f)
+	if err := writeValue(dir, value); err != nil {
+		return InvalidVersion, err
+	}
+	m := &meta{Version: 0, Owner: owner, Created: time.Now()}
+	return m.Version, writeMeta(f, m)
+}
+
+// Sets the value with CAS.  InvalidVersion matches any version.
+func setNode(dir string, value []byte, version Version) (Version, error) {
+	if has, err := exists(dir); err != nil {
+		return InvalidVersion, err
+	} else if !has {
+		return InvalidVersion, ErrNotExist
+	}
+	f, err := lock(dir, true)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	defer unlock(f)
+	m, err := readMeta(f)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	if version != InvalidVersion && version != m.Version {
+		return InvalidVersion, ErrBadVersion
+	}
+	if err := writeValue(dir, value); err != nil {
+		return InvalidVersion, err
+	}
+	m.Version++
+	return m.Version, writeMeta(f, m)
+}
+
+// Deletes the node with CAS.  InvalidVersion matches any version.  Only nodes without children and
+// without any files other than the reserved ones can be deleted.
+func deleteNode(dir string, version Version) error {
+	return deleteNodeIf(dir, func(m *meta) error {
+		if version != InvalidVersion && version != m.Version {
+			return ErrBadVersion
+		}
+		return nil
+	})
+}
+
+// Deletes the node if the check of its metadata, made with the node locked, passes.
+func deleteNodeIf(dir string, check func(*meta) error) error {
+	if has, err := exists(dir); err != nil {
+		return err
+	} else if !has {
+		return ErrNotExist
+	}
+	f, err := lock(dir, true)
+	if err != nil {
+		return err
+	}
+	defer unlock(f)
+	m, err := readMeta(f)
+	if err != nil {
+		return err
+	}
+	if err := check(m); err != nil {
+		return err
+	}
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !isReserved(info.Name()) {
+			return ErrNotEmpty
+		}
+	}
+	for _, info := range infos {
+		if err := os.Remove(filepath.Join(dir, info.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Remove(dir)
+}
@@ -0,0 +1,34 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Wakes up a watcher when something changes in the directories it watches, so changes are delivered
+// without waiting for the next poll.  Implemented with inotify on linux.  Elsewhere, newNotifier fails
+// and the watchers only poll.
+type notifier interface {
+	// Watches the directories, in place of those watched before.
+	watch(dirs []string)
+
+	// Receives after changes.  Closed when the notifier is closed.
+	changes() <-chan int
+
+	close()
+}
+
+// The directories to watch for a node: its own, for the value and the children, and the closest
+// ancestor that exists under the root, for the node, or one of its missing parents, to be created.
+func watchedDirs(root, dir string) []string {
+	dirs := []string{dir}
+	for p := filepath.Dir(dir); len(p) >= len(root); p = filepath.Dir(p) {
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			return append(dirs, p)
+		}
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	return dirs
+}
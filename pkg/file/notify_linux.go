@@ -0,0 +1,88 @@
+package file
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+const notifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Notifications with inotify.  The events are not parsed: any of them wakes up the watcher, which
+// then looks at the disk as it does when polling.
+type inotify struct {
+	fd      int
+	file    *os.File
+	changed chan int
+
+	lock    sync.Mutex
+	closed  bool
+	watches map[string]int // watch descriptors by directory
+}
+
+func newNotifier() (notifier, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	// Non blocking, so that reads wait in the runtime and end when the file is closed.  The file's Fd
+	// is not used, since it makes the file blocking again.
+	this := &inotify{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		changed: make(chan int, 1),
+		watches: map[string]int{},
+	}
+	go this.read()
+	return this, nil
+}
+
+func (this *inotify) read() {
+	defer close(this.changed)
+	buff := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := this.file.Read(buff); err != nil {
+			return
+		}
+		select {
+		case this.changed <- 1:
+		default: // A wake up is already pending.
+		}
+	}
+}
+
+func (this *inotify) watch(dirs []string) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return
+	}
+	keep := map[string]bool{}
+	for _, dir := range dirs {
+		wd, err := syscall.InotifyAddWatch(this.fd, dir, notifyMask)
+		if err != nil {
+			continue // Gone since looked at.  The next poll finds out.
+		}
+		this.watches[dir] = wd
+		keep[dir] = true
+	}
+	for dir, wd := range this.watches {
+		if !keep[dir] {
+			// Fails if the directory is gone, which removed the watch already.
+			syscall.InotifyRmWatch(this.fd, uint32(wd))
+			delete(this.watches, dir)
+		}
+	}
+}
+
+func (this *inotify) changes() <-chan int {
+	return this.changed
+}
+
+func (this *inotify) close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.closed = true
+	this.file.Close()
+}
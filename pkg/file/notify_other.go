@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package file
+
+import (
+	"errors"
+)
+
+func newNotifier() (notifier, error) {
+	return nil, errors.New("error-notify-not-supported")
+}
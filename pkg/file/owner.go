@@ -0,0 +1,64 @@
+package file
+
+import (
+	"fmt"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The owner of ephemeral nodes is a registry, named host:pid:session so that the registries on the
+// same host can tell when the process that owns a node is gone.
+func owner(host string, session int64) string {
+	return fmt.Sprintf("%s:%d:%d", host, os.Getpid(), session)
+}
+
+// Whether the owner is a process of the host that does not run anymore.  Owners on other hosts, e.g.
+// sharing the directory over nfs, cannot be checked and are never stale.  A pid reused by another
+// process keeps the nodes until that process exits.
+func stale(host, owner string) bool {
+	parts := strings.Split(owner, ":")
+	if len(parts) != 3 || parts[0] != host {
+		return false
+	}
+	pid, err := strconv.Atoi(parts[1])
+	if err != nil || pid == os.Getpid() {
+		return false
+	}
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// Removes the ephemeral nodes under the root whose owners are stale.  Ephemeral nodes have no
+// children, so their directories are not walked.
+func sweep(root, host string) {
+	filepath.Walk(root, func(dir string, info os.FileInfo, err error) error {
+		switch {
+		case err != nil:
+			return nil // e.g. removed since listed
+		case !info.IsDir():
+			return nil
+		case isReserved(info.Name()):
+			return filepath.SkipDir
+		}
+		_, m, err := readNode(dir)
+		if err != nil || m.Owner == "" {
+			return nil
+		}
+		if stale(host, m.Owner) {
+			err := deleteNodeIf(dir, func(current *meta) error {
+				if current.Owner != m.Owner {
+					return ErrBadVersion // created again since read
+				}
+				return nil
+			})
+			if err == nil {
+				glog.Infoln("Removed ephemeral node", dir, "of", m.Owner)
+			}
+		}
+		return filepath.SkipDir
+	})
+}
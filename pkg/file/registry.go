@@ -0,0 +1,322 @@
+package file
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	. "github.com/conductant/gohm/pkg/store"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var sessions int64
+
+// Note that the resource package already binds the file protocol to reading plain files, so
+// there's no resource.Register here.  Templates can still use the get, list and exists functions.
+func init() {
+	Register("file", NewService)
+}
+
+// A registry where the node paths map onto directories under a root directory on local disk. For
+// example, with the root /var/lib/ns, file:///services/web is the node /services/web, with its value
+// stored in /var/lib/ns/services/web/.ns.value.  Ephemeral nodes are removed when the registry closes,
+// or, if the process dies first, by the next registry on the host to look for them, see SweepInterval.
+//
+// Changes are found with inotify where it's available, and else by polling.  Polling goes on less
+// often with inotify, see NotifiedPollInterval, since changes made by other hosts on network
+// filesystems are not notified.  The notify=false option of the url, e.g. file:///services?notify=false,
+// turns notifications off, to poll at the interval on such filesystems.
+type registry struct {
+	url      url.URL
+	root     string
+	host     string
+	id       string
+	interval time.Duration
+	notify   bool
+	close    Dispose
+
+	lock      sync.Mutex
+	closed    bool
+	done      chan int
+	ephemeral map[string]bool
+	watches   map[chan int]bool
+}
+
+// Optional parameters are the poll interval, in Duration, and the root directory, see ContextGetRoot.
+// The interval option of the url, e.g. file:///services?interval=1s, takes precedence.  The notify
+// option turns inotify off, see registry.  The root is created if missing.
+func NewService(ctx context.Context, u url.URL, close Dispose) (Registry, error) {
+	interval := ContextGetPollInterval(ctx)
+	if v := ContextGetOptions(ctx).Params.Get("interval"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, &BadOption{Name: "interval", Value: v}
+		}
+		interval = d
+	}
+	notify := true
+	if v := ContextGetOptions(ctx).Params.Get("notify"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, &BadOption{Name: "notify", Value: v}
+		}
+		notify = b
+	}
+	root, err := filepath.Abs(ContextGetRoot(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	reg := &registry{
+		url:       url.URL{Scheme: u.Scheme, Host: u.Host},
+		root:      root,
+		host:      host,
+		id:        owner(host, atomic.AddInt64(&sessions, 1)),
+		interval:  interval,
+		notify:    notify,
+		close:     close,
+		done:      make(chan int),
+		ephemeral: map[string]bool{},
+		watches:   map[chan int]bool{},
+	}
+	go reg.sweep()
+	return reg, nil
+}
+
+// Removes the ephemeral nodes of the processes that are gone, now and then every SweepInterval.
+func (this *registry) sweep() {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+	for {
+		sweep(this.root, this.host)
+		select {
+		case <-ticker.C:
+		case <-this.done:
+			return
+		}
+	}
+}
+
+func (this *registry) dir(key Path) string {
+	return filepath.Join(this.root, filepath.FromSlash(NewPath(key.String()).String()))
+}
+
+// Returns the directory of a node to write, see writable.
+func (this *registry) writable(key Path) (string, error) {
+	dir := this.dir(key)
+	return dir, writable(this.root, dir)
+}
+
+func (this *registry) check() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (this *registry) Close() error {
+	ok := true
+	if this.close != nil {
+		this.close.Propose() <- this
+		ok = <-this.close.Accept()
+	}
+	if !ok {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	close(this.done)
+	for w, _ := range this.watches {
+		close(w)
+	}
+	for dir, _ := range this.ephemeral {
+		if err := deleteNode(dir, InvalidVersion); err != nil {
+			glog.Warningln("Cannot remove ephemeral node", dir, "err=", err)
+		}
+	}
+	glog.Infoln("Closed file registry", this.id)
+	return nil
+}
+
+func (this *registry) Id() url.URL {
+	return this.url
+}
+
+func (this *registry) Exists(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	return exists(this.dir(key))
+}
+
+func (this *registry) Get(key Path) ([]byte, Version, error) {
+	if err := this.check(); err != nil {
+		return nil, InvalidVersion, err
+	}
+	value, m, err := readNode(this.dir(key))
+	if err != nil {
+		return nil, InvalidVersion, err
+	}
+	return value, m.Version, nil
+}
+
+func (this *registry) IsEphemeral(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	_, m, err := readNode(this.dir(key))
+	if err != nil {
+		return false, err
+	}
+	return m.Owner != "", nil
+}
+
+func (this *registry) Stat(key Path) (*Stat, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return statNode(this.dir(key))
+}
+
+func (this *registry) List(key Path) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	names, err := children(this.dir(key))
+	if err != nil {
+		return nil, err
+	}
+	paths := []Path{}
+	for _, n := range names {
+		paths = append(paths, NewPath(key.String(), n))
+	}
+	return paths, nil
+}
+
+func (this *registry) Delete(key Path) error {
+	return this.DeleteVersion(key, InvalidVersion)
+}
+
+func (this *registry) DeleteVersion(key Path, version Version) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	dir := this.dir(key)
+	if err := deleteNode(dir, version); err != nil {
+		return err
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.ephemeral, dir)
+	return nil
+}
+
+func (this *registry) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	dir, err := this.writable(key)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	if ephemeral {
+		v, err := createNode(dir, value, this.id)
+		if err != nil {
+			return InvalidVersion, err
+		}
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		this.ephemeral[dir] = true
+		return v, nil
+	}
+	for {
+		v, err := setNode(dir, value, InvalidVersion)
+		if err != ErrNotExist {
+			return v, err
+		}
+		v, err = createNode(dir, value, "")
+		if err != ErrNodeExists {
+			return v, err
+		}
+		// Lost the race to another writer. Try to set again.
+	}
+}
+
+func (this *registry) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	dir, err := this.writable(key)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	return setNode(dir, value, version)
+}
+
+func (this *registry) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	if m, is := t.(*Members); is {
+		t = *m
+	}
+	var w *watcher
+	var err error
+	switch t := t.(type) {
+	case Create:
+		w, err = newWatcher(t.Path, this.root, this.dir(t.Path), pollNode(EventCreate))
+	case Change:
+		w, err = newWatcher(t.Path, this.root, this.dir(t.Path), pollNode(EventChange))
+	case Delete:
+		w, err = newWatcher(t.Path, this.root, this.dir(t.Path), pollNode(EventDelete))
+	case Members:
+		w, err = newWatcher(t.Path, this.root, this.dir(t.Path), pollMembers(t))
+	default:
+		err = ErrBadTrigger
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan int)
+	this.lock.Lock()
+	this.watches[done] = true
+	this.lock.Unlock()
+
+	go w.run(this.interval, this.notify, done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			this.lock.Lock()
+			defer this.lock.Unlock()
+			if _, has := this.watches[done]; has && !this.closed {
+				delete(this.watches, done)
+				close(done)
+			}
+		case <-w.stopped:
+			// Closed by the registry, or failed.
+			this.lock.Lock()
+			defer this.lock.Unlock()
+			delete(this.watches, done)
+		}
+	}()
+	return w.events, nil
+}
@@ -0,0 +1,483 @@
+package file
+
+import (
+	"fmt"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/testutil/nstest"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	net "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var (
+	interval = 20 * time.Millisecond
+	delay    = 5 * interval
+)
+
+func TestRegistry(t *testing.T) { TestingT(t) }
+
+type TestSuiteRegistry struct {
+	root string
+}
+
+var _ = Suite(&TestSuiteRegistry{})
+
+func (suite *TestSuiteRegistry) SetUpSuite(c *C) {
+	dir, err := ioutil.TempDir("", "file-registry")
+	c.Assert(err, IsNil)
+	suite.root = dir
+}
+
+func (suite *TestSuiteRegistry) TearDownSuite(c *C) {
+	os.RemoveAll(suite.root)
+}
+
+func (suite *TestSuiteRegistry) context() context.Context {
+	return ContextPutRoot(ContextPutPollInterval(context.Background(), interval), suite.root)
+}
+
+func (suite *TestSuiteRegistry) service(c *C) namespace.Registry {
+	reg, err := NewService(suite.context(), net.URL{Scheme: "file"}, nil)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+func (suite *TestSuiteRegistry) TestUsage(c *C) {
+	reg, err := namespace.Dial(suite.context(), "file:///usage")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/usage/test")
+	v := []byte("test")
+	_, err = reg.Put(p, v, false)
+	c.Assert(err, IsNil)
+	read, _, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, v)
+
+	// Stored as plain files under the root
+	onDisk, err := ioutil.ReadFile(filepath.Join(suite.root, p.String(), ValueFile))
+	c.Assert(err, IsNil)
+	c.Assert(onDisk, DeepEquals, v)
+
+	check := map[namespace.Path]int{}
+	for i := 0; i < 10; i++ {
+		cp := p.Sub(fmt.Sprintf("child-%d", i))
+		_, err = reg.Put(cp, []byte{0}, false)
+		c.Assert(err, IsNil)
+		check[cp] = i
+	}
+
+	list, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, len(check))
+	for _, p := range list {
+		_, has := check[p]
+		c.Assert(has, Equals, true)
+	}
+
+	c.Assert(reg.Delete(p), Equals, namespace.ErrNotEmpty)
+
+	for i := 0; i < 10; i++ {
+		err = reg.Delete(p.Sub(fmt.Sprintf("child-%d", i)))
+		c.Assert(err, IsNil)
+	}
+	list, err = reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 0)
+
+	exists, err := reg.Exists(p.Sub("child-0"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	_, _, err = reg.Get(p.Sub("child-0"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	// Plain directories are nodes too, and other files are left alone.
+	plain := namespace.NewPath("/usage/plain")
+	dir := filepath.Join(suite.root, plain.String())
+	c.Assert(os.MkdirAll(filepath.Join(dir, "sub"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "README"), []byte("hello"), 0644), IsNil)
+	read, version, err := reg.Get(plain)
+	c.Assert(err, IsNil)
+	c.Assert(len(read), Equals, 0)
+	c.Assert(version, Equals, namespace.Version(0))
+	list, err = reg.List(plain)
+	c.Assert(err, IsNil)
+	c.Assert(list, DeepEquals, []namespace.Path{plain.Sub("sub")})
+	c.Assert(reg.Delete(plain.Sub("sub")), IsNil)
+	c.Assert(reg.Delete(plain), Equals, namespace.ErrNotEmpty)
+}
+
+func (suite *TestSuiteRegistry) TestReservedNames(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/reserved")
+	_, err := reg.Put(p, []byte("test"), false)
+	c.Assert(err, IsNil)
+
+	for _, bad := range []namespace.Path{p.Sub(ValueFile), p.Sub(MetaFile, "child"), p.Sub(".ns.other")} {
+		_, err = reg.Put(bad, []byte("bad"), false)
+		c.Assert(err, Equals, ErrReservedName)
+		_, err = reg.Put(bad, []byte("bad"), true)
+		c.Assert(err, Equals, ErrReservedName)
+		_, err = reg.PutVersion(bad, []byte("bad"), namespace.InvalidVersion)
+		c.Assert(err, Equals, ErrReservedName)
+		_, err = reg.Txn(namespace.OpCreate{Path: bad, Value: []byte("bad")})
+		c.Assert(err, Not(IsNil))
+	}
+
+	// Other files are not nodes either.
+	c.Assert(ioutil.WriteFile(filepath.Join(suite.root, "reserved", "README"), []byte("hello"), 0644), IsNil)
+	_, err = reg.Put(p.Sub("README"), []byte("bad"), false)
+	c.Assert(err, Equals, ErrNotNode)
+
+	read, _, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(read), Equals, "test")
+}
+
+func (suite *TestSuiteRegistry) TestVersions(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/versions")
+	version, err := reg.Put(p, []byte("test"), false)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, namespace.Version(0))
+
+	version2, err := reg.PutVersion(p, []byte{1}, version)
+	c.Assert(err, IsNil)
+	c.Assert(version2 > version, Equals, true)
+
+	_, err = reg.PutVersion(p, []byte{2}, version)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
+
+	_, err = reg.PutVersion(p.Sub("missing"), []byte{2}, version)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	c.Assert(reg.DeleteVersion(p, version), Equals, namespace.ErrBadVersion)
+
+	value, version3, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(version3, Equals, version2)
+	c.Assert(value, DeepEquals, []byte{1})
+
+	c.Assert(reg.DeleteVersion(p, version3), IsNil)
+	_, _, err = reg.Get(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
+
+func (suite *TestSuiteRegistry) TestEphemeral(c *C) {
+	session1 := suite.service(c)
+	session2 := suite.service(c)
+	defer session2.Close()
+
+	p := namespace.NewPath("/ephemeral/node")
+	_, err := session1.Put(p, []byte("test"), true)
+	c.Assert(err, IsNil)
+
+	_, err = session1.Put(p, []byte("test"), true)
+	c.Assert(err, Equals, namespace.ErrNodeExists)
+
+	_, err = session2.Put(p.Sub("child"), []byte("test"), false)
+	c.Assert(err, Equals, namespace.ErrNoChildrenForEphemerals)
+
+	exists, err := session2.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	c.Assert(session1.Close(), IsNil)
+
+	exists, err = session2.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	exists, err = session2.Exists(p.Dir())
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	_, _, err = session1.Get(p)
+	c.Assert(err, Equals, namespace.ErrClosed)
+}
+
+func (suite *TestSuiteRegistry) TestEphemeralOfDeadProcess(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+	host, err := os.Hostname()
+	c.Assert(err, IsNil)
+
+	// A process that exited without removing its nodes.
+	cmd := exec.Command("true")
+	c.Assert(cmd.Run(), IsNil)
+	dead := fmt.Sprintf("%s:%d:1", host, cmd.Process.Pid)
+
+	p := namespace.NewPath("/ephemeral/dead")
+	for name, owner := range map[string]string{"dead": dead, "remote": "elsewhere:1:1"} {
+		dir := filepath.Join(suite.root, p.Sub(name).String())
+		_, err := createNode(dir, []byte{}, owner)
+		c.Assert(err, IsNil)
+	}
+
+	sweep(suite.root, host)
+	exists, err := reg.Exists(p.Sub("dead"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	exists, err = reg.Exists(p.Sub("remote"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	// The nodes of the live registries stay.
+	_, err = reg.Put(p.Sub("live"), []byte{}, true)
+	c.Assert(err, IsNil)
+	sweep(suite.root, host)
+	exists, err = reg.Exists(p.Sub("live"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+}
+
+func (suite *TestSuiteRegistry) TestStat(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/stat/node")
+	_, err := reg.Stat(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	_, err = reg.Put(p, []byte("12345"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("e"), []byte{}, true)
+	c.Assert(err, IsNil)
+	stat, err := reg.Stat(p)
+	c.Assert(err, IsNil)
+	c.Assert(stat.Version, Equals, namespace.Version(0))
+	c.Assert(stat.Size, Equals, 5)
+	c.Assert(stat.Children, Equals, 1)
+	c.Assert(stat.Ephemeral, Equals, false)
+	c.Assert(stat.Created.IsZero(), Equals, false)
+	c.Assert(stat.Modified.IsZero(), Equals, false)
+
+	_, err = reg.Put(p, []byte("123"), false)
+	c.Assert(err, IsNil)
+	changed, err := reg.Stat(p)
+	c.Assert(err, IsNil)
+	c.Assert(changed.Version, Equals, namespace.Version(1))
+	c.Assert(changed.Size, Equals, 3)
+	c.Assert(changed.Created.Equal(stat.Created), Equals, true)
+
+	ephemeral, err := reg.Stat(p.Sub("e"))
+	c.Assert(err, IsNil)
+	c.Assert(ephemeral.Ephemeral, Equals, true)
+	c.Assert(ephemeral.Owner, Not(Equals), "")
+}
+
+func (suite *TestSuiteRegistry) TestTriggers(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/triggers/node")
+
+	watch, stop := context.WithCancel(context.Background())
+	created, err := reg.Trigger(watch, namespace.Create{Path: p})
+	c.Assert(err, IsNil)
+	changed, err := reg.Trigger(watch, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+	deleted, err := reg.Trigger(watch, namespace.Delete{Path: p})
+	c.Assert(err, IsNil)
+	members, err := reg.Trigger(watch, namespace.Members{Path: p})
+	c.Assert(err, IsNil)
+	_, err = reg.Trigger(watch, &namespace.Create{Path: p})
+	c.Assert(err, Equals, namespace.ErrBadTrigger)
+
+	_, err = reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+
+	events := nstest.Collect(created, 1, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventCreate)
+	c.Assert(events[0].Path, Equals, p.String())
+
+	// Give the change trigger a chance to see the creation.
+	time.Sleep(delay)
+
+	for i := 2; i <= 3; i++ {
+		_, err = reg.Put(p, []byte{byte(i)}, false)
+		c.Assert(err, IsNil)
+		events = nstest.Collect(changed, 1, delay)
+		c.Assert(len(events), Equals, 1)
+		c.Assert(events[0].Kind, Equals, namespace.EventChange)
+		c.Assert(events[0].Version, Equals, namespace.Version(i-1))
+		c.Assert(events[0].Value, DeepEquals, []byte{byte(i)})
+	}
+
+	_, err = reg.Put(p.Sub("a"), []byte{1}, false)
+	c.Assert(err, IsNil)
+	changes := nstest.CollectMembers(members, 1, delay)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].After, DeepEquals, []string{p.Sub("a").String()})
+
+	c.Assert(reg.Delete(p.Sub("a")), IsNil)
+	changes = nstest.CollectMembers(members, 1, delay)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].AfterCount, Equals, 0)
+
+	c.Assert(reg.Delete(p), IsNil)
+	events = nstest.Collect(deleted, 1, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventDelete)
+
+	stop()
+
+	_, open := <-created
+	c.Assert(open, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestTriggersNotifiedOrPolled(c *C) {
+	for _, t := range []struct {
+		url      string
+		interval time.Duration
+	}{
+		// Notified at once, even though the poll interval is long.
+		{"file:///notified", time.Hour},
+		// Polled only.
+		{"file:///polled?notify=false", interval},
+	} {
+		ctx := ContextPutRoot(ContextPutPollInterval(context.Background(), t.interval), suite.root)
+		reg, err := namespace.Dial(ctx, t.url)
+		c.Assert(err, IsNil)
+
+		p := namespace.NewPath("/triggers/notify", fmt.Sprint(t.interval), "node")
+		watch, stop := context.WithCancel(context.Background())
+		created, err := reg.Trigger(watch, namespace.Create{Path: p})
+		c.Assert(err, IsNil)
+
+		// The parents are missing too.
+		_, err = reg.Put(p, []byte{1}, false)
+		c.Assert(err, IsNil)
+		events := nstest.Collect(created, 1, delay)
+		c.Assert(len(events), Equals, 1)
+		c.Assert(events[0].Kind, Equals, namespace.EventCreate)
+
+		changed, err := reg.Trigger(watch, namespace.Change{Path: p})
+		c.Assert(err, IsNil)
+		version, err := reg.Put(p, []byte{2}, false)
+		c.Assert(err, IsNil)
+		events = nstest.Collect(changed, 1, delay)
+		c.Assert(len(events), Equals, 1)
+		c.Assert(events[0].Version, Equals, version)
+
+		stop()
+		c.Assert(reg.Close(), IsNil)
+	}
+
+	_, err := namespace.Dial(suite.context(), "file:///bad?notify=maybe")
+	c.Assert(err, DeepEquals, &namespace.BadOption{Name: "notify", Value: "maybe"})
+}
+
+func (suite *TestSuiteRegistry) TestTriggerFails(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/triggers/corrupt")
+	_, err := reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+	changed, err := reg.Trigger(context.Background(), namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+
+	err = ioutil.WriteFile(filepath.Join(suite.root, p.String(), MetaFile), []byte("{corrupt"), 0644)
+	c.Assert(err, IsNil)
+
+	// The error is the last event.
+	events := nstest.Collect(changed, 2, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventError)
+	c.Assert(events[0].Err, NotNil)
+	_, open := <-changed
+	c.Assert(open, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestTxn(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	a := namespace.NewPath("/txn/a")
+	b := namespace.NewPath("/txn/new/b")
+	version, err := reg.Put(a, []byte("a"), false)
+	c.Assert(err, IsNil)
+
+	_, err = reg.Txn(
+		namespace.OpSet{Path: a, Value: []byte("a2"), Version: version},
+		namespace.OpCreate{Path: b, Value: []byte("b")},
+		namespace.OpDelete{Path: a.Dir(), Version: namespace.InvalidVersion},
+	)
+	c.Assert(err, NotNil)
+	c.Assert(err.(*namespace.TxnError).Index, Equals, 2)
+	c.Assert(err.(*namespace.TxnError).Err, Equals, namespace.ErrNotEmpty)
+
+	// The created parent is removed too.
+	exists, err := reg.Exists(b.Dir())
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	value, v, err := reg.Get(a)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("a"))
+	c.Assert(v, Equals, version)
+
+	results, err := reg.Txn(
+		namespace.OpSet{Path: a, Value: []byte("a2"), Version: version},
+		namespace.OpCreate{Path: b, Value: []byte("b"), Ephemeral: true},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(results[0].Version, Equals, version+1)
+	value, _, err = reg.Get(b)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("b"))
+
+	// Ephemeral nodes created in a txn are removed on close.
+	other := suite.service(c)
+	defer other.Close()
+	c.Assert(reg.Close(), IsNil)
+	exists, err = other.Exists(b)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestSequential(c *C) {
+	reg := suite.service(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/sequential")
+	// Concurrent creates get different numbers.
+	created := make(chan namespace.Path, 10)
+	for i := 0; i < 10; i++ {
+		go func() {
+			path, err := namespace.CreateSequential(reg, p.Sub("job-"), []byte("job"), false)
+			c.Assert(err, IsNil)
+			created <- path
+		}()
+	}
+	seen := map[int64]bool{}
+	for i := 0; i < 10; i++ {
+		seq, has := namespace.SequenceOf(<-created)
+		c.Assert(has, Equals, true)
+		c.Assert(seen[seq], Equals, false)
+		seen[seq] = true
+	}
+	list, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 10)
+	c.Assert(list[9].String(), Equals, p.Sub("job-0000000009").String())
+
+	// Numbers are not reused after the node with the highest one is deleted.
+	c.Assert(reg.Delete(list[9]), IsNil)
+	next, err := namespace.CreateSequential(reg, p.Sub("job-"), []byte("job"), false)
+	c.Assert(err, IsNil)
+	c.Assert(next.String(), Equals, p.Sub("job-0000000010").String())
+}
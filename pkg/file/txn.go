@@ -0,0 +1,167 @@
+package file
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Transactions in the process are serialized.  They are not isolated from writers in other processes;
+// if an operation fails, the operations already applied are undone.
+var txnLock sync.Mutex
+
+// Writes the value and metadata as they were before the transaction.
+func restoreNode(dir string, value []byte, m *meta) error {
+	f, err := lock(dir, true)
+	if err != nil {
+		return err
+	}
+	defer unlock(f)
+	if err := writeValue(dir, value); err != nil {
+		return err
+	}
+	return writeMeta(f, m)
+}
+
+// Returns the ancestors of the directory that do not exist, deepest first.
+func missingParents(dir string) ([]string, error) {
+	missing := []string{}
+	for p := filepath.Dir(dir); p != filepath.Dir(p); p = filepath.Dir(p) {
+		if has, err := exists(p); err != nil {
+			return nil, err
+		} else if has {
+			break
+		}
+		missing = append(missing, p)
+	}
+	return missing, nil
+}
+
+// Sequential nodes are emulated, see EmulateSequential.
+func (this *registry) Txn(ops ...Op) ([]OpResult, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return EmulateSequential(this, this.txn, this.sequence, ops)
+}
+
+// The counter is kept in the metadata of the parent, so it goes away with the parent.  The parent is
+// created if missing, and stays even if the transaction fails.
+func (this *registry) sequence(parent Path, start int64) (int64, error) {
+	dir, err := this.writable(parent)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+	f, err := lock(dir, true)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock(f)
+	m, err := readMeta(f)
+	if err != nil {
+		return 0, err
+	}
+	if m.Sequence < start {
+		m.Sequence = start
+	}
+	m.Sequence++
+	return m.Sequence - 1, writeMeta(f, m)
+}
+
+func (this *registry) txn(ops ...Op) ([]OpResult, error) {
+	txnLock.Lock()
+	defer txnLock.Unlock()
+
+	undo := []func(){}
+	ephemeral := map[string]bool{}
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		dir := this.dir(PathOf(op))
+		version := InvalidVersion
+		var err error
+		switch op := op.(type) {
+		case OpCheck:
+			var m *meta
+			if _, m, err = readNode(dir); err == nil {
+				if op.Version != InvalidVersion && op.Version != m.Version {
+					err = ErrBadVersion
+				} else {
+					version = m.Version
+				}
+			}
+		case OpCreate:
+			var parents []string
+			if err = writable(this.root, dir); err != nil {
+				break
+			}
+			if parents, err = missingParents(dir); err != nil {
+				break
+			}
+			owner := ""
+			if op.Ephemeral {
+				owner = this.id
+			}
+			version, err = createNode(dir, op.Value, owner)
+			created := err == nil
+			if created {
+				ephemeral[dir] = op.Ephemeral
+			}
+			// The parents may have been created even if the node was not.
+			undo = append(undo, func() {
+				if created {
+					deleteNode(dir, InvalidVersion)
+				}
+				for _, p := range parents {
+					deleteNode(p, InvalidVersion)
+				}
+			})
+		case OpSet:
+			var value []byte
+			var m *meta
+			if err = writable(this.root, dir); err != nil {
+				break
+			}
+			if value, m, err = readNode(dir); err != nil {
+				break
+			}
+			if version, err = setNode(dir, op.Value, op.Version); err == nil {
+				undo = append(undo, func() { restoreNode(dir, value, m) })
+			}
+		case OpDelete:
+			var value []byte
+			var m *meta
+			if value, m, err = readNode(dir); err != nil {
+				break
+			}
+			if err = deleteNode(dir, op.Version); err == nil {
+				ephemeral[dir] = false
+				undo = append(undo, func() {
+					createNode(dir, value, m.Owner)
+					restoreNode(dir, value, m)
+				})
+			}
+		}
+		if err != nil {
+			for j := len(undo) - 1; j >= 0; j-- {
+				undo[j]()
+			}
+			return AbortTxn(ops, i, err)
+		}
+		results[i] = OpResult{Path: PathOf(op), Version: version}
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for dir, e := range ephemeral {
+		if e {
+			this.ephemeral[dir] = true
+		} else {
+			delete(this.ephemeral, dir)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,49 @@
+package file
+
+import (
+	"errors"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"time"
+)
+
+var (
+	// Names starting with .ns. are taken by the files of the nodes, see ValueFile.
+	ErrReservedName = errors.New("error-reserved-name")
+
+	// A file that is not a directory is where the node would be.
+	ErrNotNode = errors.New("error-not-a-node")
+)
+
+const (
+	// How often the triggers check the disk for changes.
+	DefaultPollInterval = 100 * time.Millisecond
+
+	// How often the triggers check the disk when they are notified of the changes, in case a change is
+	// missed, e.g. one made by another host on a network filesystem.
+	NotifiedPollInterval = 5 * time.Second
+
+	// Directory holding the nodes, when not set in the context or the environment.
+	DefaultRoot = "/var/lib/ns"
+
+	// Environment variable to use when the root is not set in the context.
+	EnvRoot = "NS_FILE_ROOT"
+
+	// How often a registry looks for ephemeral nodes left by processes that are gone.
+	SweepInterval = 10 * time.Second
+
+	// Name of the file in the node's directory holding the value.
+	ValueFile = ".ns.value"
+
+	// Name of the file in the node's directory holding the metadata.  Also used for locking.
+	MetaFile = ".ns.meta"
+)
+
+// Metadata of a node, stored as json in the MetaFile.
+type meta struct {
+	Version Version   `json:"version"`
+	Owner   string    `json:"owner,omitempty"` // Set for ephemeral nodes to the id of the owning registry, see owner.
+	Created time.Time `json:"created"`         // Zero for directories that were not created as nodes.
+
+	// Next sequence number of the sequential children.
+	Sequence int64 `json:"sequence,omitempty"`
+}
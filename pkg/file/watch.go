@@ -0,0 +1,177 @@
+package file
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"time"
+)
+
+// Snapshot of the state of a node on disk, as seen by a watcher.
+type state struct {
+	exists  bool
+	version Version
+	value   []byte
+	members []string
+}
+
+// Compares the previous and current states and returns the events to send, if any.
+type pollFunc func(path Path, dir string, prev *state) (*state, []Event, error)
+
+type watcher struct {
+	path    Path
+	root    string
+	dir     string
+	poll    pollFunc
+	current *state
+	events  chan Event
+	stopped chan int
+}
+
+// The initial state is taken here so that changes made right after the trigger is set are not missed.
+func newWatcher(path Path, root, dir string, poll pollFunc) (*watcher, error) {
+	current, _, err := poll(path, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{
+		path:    path,
+		root:    root,
+		dir:     dir,
+		poll:    poll,
+		current: current,
+		events:  make(chan Event, 8),
+		stopped: make(chan int),
+	}, nil
+}
+
+// A node that does not exist is a state like any other.  Other errors, e.g. a metadata file that
+// cannot be read, are returned.
+func snapshot(dir string, withMembers bool) (*state, error) {
+	missing := &state{version: InvalidVersion}
+	value, m, err := readNode(dir)
+	switch {
+	case err == ErrNotExist:
+		return missing, nil
+	case err != nil:
+		return nil, err
+	}
+	s := &state{exists: true, version: m.Version, value: value}
+	if withMembers {
+		names, err := children(dir)
+		switch {
+		case err == ErrNotExist:
+			return missing, nil // deleted since read
+		case err != nil:
+			return nil, err
+		}
+		s.members = names
+	}
+	return s, nil
+}
+
+// Polls for the creation, change or deletion of the node itself.
+func pollNode(t EventKind) pollFunc {
+	return func(path Path, dir string, prev *state) (*state, []Event, error) {
+		current, err := snapshot(dir, false)
+		if err != nil || prev == nil {
+			return current, nil, err
+		}
+		fire := false
+		switch t {
+		case EventCreate:
+			fire = !prev.exists && current.exists
+		case EventDelete:
+			fire = prev.exists && !current.exists
+		case EventChange:
+			fire = prev.exists && current.exists && prev.version != current.version
+		}
+		if fire {
+			return current, []Event{Event{Kind: t, Path: path.String(), Version: current.version, Value: current.value}}, nil
+		}
+		return current, nil, nil
+	}
+}
+
+// Polls for the addition and removal of children, and fires according to the criteria of the trigger.
+func pollMembers(t Members) pollFunc {
+	var matcher *MembersMatcher
+	return func(path Path, dir string, prev *state) (*state, []Event, error) {
+		current, err := snapshot(dir, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		members := []string{}
+		for _, name := range current.members {
+			members = append(members, path.Sub(name).String())
+		}
+		if prev == nil {
+			matcher = NewMembersMatcher(t, members)
+			return current, nil, nil
+		}
+		if change := matcher.Update(members); change != nil {
+			return current, []Event{change.Event()}, nil
+		}
+		return current, nil, nil
+	}
+}
+
+// Polls until done.  With notify, the disk is also looked at as soon as the notifier sees a change,
+// and polling is only a fallback, every NotifiedPollInterval unless the interval is longer.  If a poll
+// fails, an EventError is delivered and the watcher stops.
+func (this *watcher) run(interval time.Duration, notify bool, done <-chan int) {
+	defer func() {
+		close(this.events)
+		close(this.stopped)
+	}()
+	var n notifier
+	var changes <-chan int
+	every := interval
+	if notify {
+		var err error
+		if n, err = newNotifier(); err != nil {
+			glog.V(100).Infoln("Polling only for", this.path, "err=", err)
+		} else {
+			defer n.close()
+			changes = n.changes()
+			if every < NotifiedPollInterval {
+				every = NotifiedPollInterval
+			}
+		}
+	}
+	ticker := time.NewTicker(every)
+	defer func() { ticker.Stop() }()
+	for {
+		// Watched before looking, so that no change in between is missed.
+		if n != nil {
+			n.watch(watchedDirs(this.root, this.dir))
+		}
+		current, events, err := this.poll(this.path, this.dir, this.current)
+		if err != nil {
+			events = []Event{{Kind: EventError, Path: this.path.String(), Version: InvalidVersion, Err: err}}
+		} else {
+			this.current = current
+		}
+		for _, e := range events {
+			select {
+			case this.events <- e:
+			case <-done:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+		select {
+		case <-ticker.C:
+		case _, open := <-changes:
+			if !open {
+				glog.Warningln("Notifications stopped, polling only for", this.path)
+				n, changes = nil, nil
+				ticker.Stop()
+				ticker = time.NewTicker(interval)
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package mem
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	. "github.com/conductant/gohm/pkg/store"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+var sessions int64
+
+func init() {
+	Register("mem", NewService)
+}
+
+// A registry is a session against an in-process tree identified by the host of the url.  For example,
+// mem://test/path/to/node and mem://test/other share the same tree.  The trees live for the lifetime
+// of the process, while ephemeral nodes and triggers live only as long as the registry is open.
+type registry struct {
+	url     url.URL
+	tree    *tree
+	session string // reported as the owner of its ephemeral nodes
+	close   Dispose
+	lock    sync.Mutex
+	closed  bool
+}
+
+func NewService(ctx context.Context, u url.URL, close Dispose) (Registry, error) {
+	id := url.URL{Scheme: u.Scheme, Host: u.Host}
+	return &registry{
+		url:     id,
+		tree:    getTree(u.Host),
+		session: strconv.FormatInt(atomic.AddInt64(&sessions, 1), 10),
+		close:   close,
+	}, nil
+}
+
+func (this *registry) check() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (this *registry) Close() error {
+	ok := true
+	if this.close != nil {
+		// Same protocol as the zk implementation: propose and wait for the store to accept.
+		this.close.Propose() <- this
+		ok = <-this.close.Accept()
+	}
+	if ok {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if !this.closed {
+			this.closed = true
+			this.tree.closeSession(this)
+			glog.Infoln("Closed mem registry", this.url.String())
+		}
+	}
+	return nil
+}
+
+func (this *registry) Id() url.URL {
+	return this.url
+}
+
+func (this *registry) Exists(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	return this.tree.exists(key.String()), nil
+}
+
+func (this *registry) Get(key Path) ([]byte, Version, error) {
+	if err := this.check(); err != nil {
+		return nil, InvalidVersion, err
+	}
+	return this.tree.get(key.String())
+}
+
+func (this *registry) IsEphemeral(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	return this.tree.ephemeral(key.String())
+}
+
+func (this *registry) Stat(key Path) (*Stat, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return this.tree.stat(key.String())
+}
+
+func (this *registry) Glob(pattern string) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return this.tree.glob(pattern)
+}
+
+func (this *registry) List(key Path) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	children, err := this.tree.list(key.String())
+	if err != nil {
+		return nil, err
+	}
+	paths := []Path{}
+	for _, c := range children {
+		paths = append(paths, NewPath(c))
+	}
+	return paths, nil
+}
+
+func (this *registry) Delete(key Path) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	return this.tree.delete(key.String(), InvalidVersion)
+}
+
+func (this *registry) DeleteVersion(key Path, version Version) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	return this.tree.delete(key.String(), version)
+}
+
+func (this *registry) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	var owner *registry
+	if ephemeral {
+		owner = this
+	}
+	return this.tree.put(key.String(), value, owner)
+}
+
+func (this *registry) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	return this.tree.putVersion(key.String(), value, version)
+}
+
+func (this *registry) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	if m, is := t.(*Members); is {
+		t = *m
+	}
+	var w *watcher
+	switch t := t.(type) {
+	case Create:
+		w = newWatcher(this, t, t.Path)
+	case Change:
+		w = newWatcher(this, t, t.Path)
+	case Delete:
+		w = newWatcher(this, t, t.Path)
+	case Members:
+		w = newWatcher(this, t, t.Path)
+	default:
+		return nil, ErrBadTrigger
+	}
+	this.tree.watch(w)
+	go w.run()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			this.tree.unwatch(w)
+			w.close()
+		case <-w.done:
+		}
+	}()
+	return w.events, nil
+}
+
+func (this *registry) Txn(ops ...Op) ([]OpResult, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return this.tree.txn(ops, this)
+}
@@ -0,0 +1,522 @@
+package mem
+
+import (
+	"fmt"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/template"
+	"github.com/conductant/gohm/pkg/testutil/nstest"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+	"testing"
+	"time"
+)
+
+var delay = 200 * time.Millisecond
+
+func TestRegistry(t *testing.T) { TestingT(t) }
+
+type TestSuiteRegistry struct{}
+
+var _ = Suite(&TestSuiteRegistry{})
+
+func (suite *TestSuiteRegistry) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteRegistry) TearDownSuite(c *C) {
+}
+
+func (suite *TestSuiteRegistry) TestUsage(c *C) {
+	url := "mem://usage"
+	reg, err := namespace.Dial(context.Background(), url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/namespace/test")
+	v := []byte("test")
+	_, err = reg.Put(p, v, false)
+	c.Assert(err, IsNil)
+	read, _, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, v)
+
+	// Parents are created
+	exists, err := reg.Exists(p.Dir())
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	check := map[namespace.Path]int{}
+	for i := 0; i < 10; i++ {
+		cp := p.Sub(fmt.Sprintf("child-%d", i))
+		_, err = reg.Put(cp, []byte{0}, false)
+		c.Assert(err, IsNil)
+		check[cp] = i
+	}
+
+	list, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, len(check))
+	for _, p := range list {
+		_, has := check[p]
+		c.Assert(has, Equals, true)
+	}
+
+	// Cannot delete a node with children
+	c.Assert(reg.Delete(p), Equals, namespace.ErrNotEmpty)
+
+	for i := 0; i < 10; i++ {
+		err = reg.Delete(p.Sub(fmt.Sprintf("child-%d", i)))
+		c.Assert(err, IsNil)
+	}
+	list, err = reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 0)
+
+	exists, err = reg.Exists(p.Sub("child-0"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	_, _, err = reg.Get(p.Sub("child-0"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	_, err = reg.List(p.Sub("child-0"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	c.Assert(reg.Delete(p.Sub("child-0")), Equals, namespace.ErrNotExist)
+
+	// Same host shares the same tree
+	other, err := NewService(context.Background(), net.URL{Scheme: "mem", Host: "usage"}, nil)
+	c.Assert(err, IsNil)
+	read, _, err = other.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(read, DeepEquals, v)
+	other.Close()
+
+	// Different host is a different tree
+	other, err = NewService(context.Background(), net.URL{Scheme: "mem", Host: "usage2"}, nil)
+	c.Assert(err, IsNil)
+	exists, err = other.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	other.Close()
+}
+
+func (suite *TestSuiteRegistry) TestVersions(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://versions")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/version")
+	v := []byte("test")
+	version, err := reg.Put(p, v, false)
+	c.Assert(err, IsNil)
+	c.Assert(version, Not(Equals), namespace.InvalidVersion)
+
+	read, version2, err := reg.Get(p)
+	c.Assert(read, DeepEquals, v)
+	c.Assert(version, Equals, version2)
+
+	version3, err := reg.PutVersion(p, []byte{1}, version2)
+	c.Assert(err, IsNil)
+	c.Assert(version3 > version2, Equals, true)
+
+	_, err = reg.PutVersion(p, []byte{2}, version2)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
+
+	_, err = reg.PutVersion(p.Sub("missing"), []byte{2}, version2)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	err = reg.DeleteVersion(p, version)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
+
+	cv, version4, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(version4, Equals, version3)
+	c.Assert(cv, DeepEquals, []byte{1})
+
+	err = reg.DeleteVersion(p, version4)
+	c.Assert(err, IsNil)
+
+	_, _, err = reg.Get(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
+
+func (suite *TestSuiteRegistry) TestEphemeral(c *C) {
+	url := net.URL{Scheme: "mem", Host: "ephemeral"}
+	session1, err := NewService(context.Background(), url, nil)
+	c.Assert(err, IsNil)
+	session2, err := NewService(context.Background(), url, nil)
+	c.Assert(err, IsNil)
+	defer session2.Close()
+
+	p := namespace.NewPath("/unit-test/registry/ephemeral")
+	_, err = session1.Put(p, []byte("test"), true)
+	c.Assert(err, IsNil)
+
+	// Ephemeral nodes are always created
+	_, err = session1.Put(p, []byte("test"), true)
+	c.Assert(err, Equals, namespace.ErrNodeExists)
+
+	_, err = session1.Put(p.Sub("child"), []byte("test"), false)
+	c.Assert(err, Equals, namespace.ErrNoChildrenForEphemerals)
+
+	exists, err := session2.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	watch, stop := context.WithCancel(context.Background())
+	deleted, err := session2.Trigger(watch, namespace.Delete{Path: p})
+	c.Assert(err, IsNil)
+	defer stop()
+
+	c.Assert(session1.Close(), IsNil)
+
+	events := nstest.Collect(deleted, 1, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventDelete)
+
+	exists, err = session2.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	// The parent is not ephemeral
+	exists, err = session2.Exists(p.Dir())
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	_, _, err = session1.Get(p)
+	c.Assert(err, Equals, namespace.ErrClosed)
+}
+
+func (suite *TestSuiteRegistry) TestFollow(c *C) {
+	ctx := context.Background()
+	url := "mem://follow"
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/follow")
+
+	_, err = namespace.Link(reg, p.Sub("1"), url+p.Sub("2").String())
+	c.Assert(err, IsNil)
+	_, err = namespace.Link(reg, p.Sub("2"), "mem://follow-other"+p.Sub("3").String())
+	c.Assert(err, IsNil)
+
+	other, err := namespace.Dial(ctx, "mem://follow-other")
+	c.Assert(err, IsNil)
+	defer other.Close()
+	_, err = other.Put(p.Sub("3"), []byte("end"), false)
+	c.Assert(err, IsNil)
+
+	u, err := net.Parse(url + p.Sub("1").String())
+	c.Assert(err, IsNil)
+	path, value, version, err := namespace.FollowUrl(ctx, *u)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("end"))
+	c.Assert(path.String(), Equals, "mem://follow-other"+p.Sub("3").String())
+	c.Assert(version, Not(Equals), namespace.InvalidVersion)
+}
+
+func (suite *TestSuiteRegistry) TestTemplate(c *C) {
+	ctx := context.Background()
+	url := "mem://template"
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/template")
+	_, err = reg.Put(p.Sub("value"), []byte("test"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("members", "a"), []byte("a"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("members", "b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("tmpl"), []byte(`{{get "mem://template/unit-test/template/value"}}`+
+		`{{range list "mem://template/unit-test/template/members"}} {{.Path}}={{get .}}{{end}}`+
+		` {{exists "mem://template/unit-test/template/none"}}`), false)
+	c.Assert(err, IsNil)
+
+	applied, err := template.Execute(ctx, url+p.Sub("tmpl").String())
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "test /unit-test/template/members/a=a /unit-test/template/members/b=b false")
+}
+
+func (suite *TestSuiteRegistry) TestTriggerCreate(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://trigger")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/create")
+
+	watch, stop := context.WithCancel(context.Background())
+	created, err := reg.Trigger(watch, namespace.Create{Path: p})
+	c.Assert(err, IsNil)
+
+	_, err = reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p, []byte{2}, false)
+	c.Assert(err, IsNil)
+
+	events := nstest.Collect(created, 2, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Path, Equals, p.String())
+	c.Assert(events[0].Kind, Equals, namespace.EventCreate)
+
+	stop()
+	_, open := <-created
+	c.Assert(open, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestTriggerChange(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://trigger")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/change")
+
+	watch, stop := context.WithCancel(context.Background())
+	changed, err := reg.Trigger(watch, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+
+	for i := 1; i <= 4; i++ {
+		_, err = reg.Put(p, []byte{byte(i)}, false)
+		c.Assert(err, IsNil)
+	}
+
+	events := nstest.Collect(changed, 4, delay)
+	c.Assert(len(events), Equals, 3)
+	for i, e := range events {
+		c.Assert(e.Kind, Equals, namespace.EventChange)
+		c.Assert(e.Version, Equals, namespace.Version(i+1))
+		c.Assert(e.Value, DeepEquals, []byte{byte(i + 2)})
+	}
+	stop()
+}
+
+func (suite *TestSuiteRegistry) TestTriggerDelete(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://trigger")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/delete")
+
+	watch, stop := context.WithCancel(context.Background())
+	deleted, err := reg.Trigger(watch, namespace.Delete{Path: p})
+	c.Assert(err, IsNil)
+
+	_, err = reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+	err = reg.Delete(p)
+	c.Assert(err, IsNil)
+
+	events := nstest.Collect(deleted, 2, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].Kind, Equals, namespace.EventDelete)
+	stop()
+}
+
+func (suite *TestSuiteRegistry) TestTriggerMembers(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://trigger")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/members")
+
+	_, err = reg.Put(p, []byte{1}, false)
+	c.Assert(err, IsNil)
+
+	watch, stop := context.WithCancel(context.Background())
+	members, err := reg.Trigger(watch, namespace.Members{Path: p})
+	c.Assert(err, IsNil)
+
+	for i := 1; i <= 3; i++ {
+		_, err = reg.Put(p.Sub(fmt.Sprintf("%d", i)), []byte{1}, false)
+		c.Assert(err, IsNil)
+	}
+	// Changing the value of a member does not count
+	_, err = reg.Put(p.Sub("1"), []byte{2}, false)
+	c.Assert(err, IsNil)
+
+	err = reg.Delete(p.Sub("3"))
+	c.Assert(err, IsNil)
+
+	events := nstest.CollectMembers(members, 5, delay)
+	c.Assert(len(events), Equals, 4)
+	for _, e := range events {
+		c.Assert(e.Path, Equals, p.String())
+	}
+	c.Assert(events[0].BeforeCount, Equals, 0)
+	c.Assert(events[0].After, DeepEquals, []string{p.Sub("1").String()})
+	c.Assert(events[3].BeforeCount, Equals, 3)
+	c.Assert(events[3].AfterCount, Equals, 2)
+	stop()
+}
+
+func (suite *TestSuiteRegistry) TestTriggerMembersRange(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://trigger")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/trigger/members-range")
+
+	// Fires when there are 3 or more members
+	watch, stop := context.WithCancel(context.Background())
+	quorum, err := reg.Trigger(watch, *(&namespace.Members{Path: p}).SetMin(3))
+	c.Assert(err, IsNil)
+	defer stop()
+
+	// Fires when the count changes by 2.  The setters return pointers, which are taken as well.
+	delta, err := reg.Trigger(watch, (&namespace.Members{Path: p}).SetDelta(2))
+	c.Assert(err, IsNil)
+
+	_, err = reg.Trigger(watch, &namespace.Create{Path: p})
+	c.Assert(err, Equals, namespace.ErrBadTrigger)
+
+	for i := 1; i <= 4; i++ {
+		_, err = reg.Put(p.Sub(fmt.Sprintf("%d", i)), []byte{1}, false)
+		c.Assert(err, IsNil)
+	}
+	events := nstest.CollectMembers(quorum, 2, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].BeforeCount, Equals, 0)
+	c.Assert(events[0].AfterCount, Equals, 3)
+
+	events = nstest.CollectMembers(delta, 3, delay)
+	c.Assert(len(events), Equals, 2)
+	c.Assert(events[0].AfterCount, Equals, 2)
+	c.Assert(events[1].BeforeCount, Equals, 2)
+	c.Assert(events[1].AfterCount, Equals, 4)
+
+	// Dropping below and coming back fires again
+	c.Assert(reg.Delete(p.Sub("1")), IsNil)
+	c.Assert(reg.Delete(p.Sub("2")), IsNil)
+	_, err = reg.Put(p.Sub("5"), []byte{1}, false)
+	c.Assert(err, IsNil)
+	events = nstest.CollectMembers(quorum, 2, delay)
+	c.Assert(len(events), Equals, 1)
+	c.Assert(events[0].AfterCount, Equals, 3)
+}
+
+func (suite *TestSuiteRegistry) TestTxn(c *C) {
+	reg, err := namespace.Dial(context.Background(), "mem://txn")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	a := namespace.NewPath("/unit-test/registry/txn/a")
+	b := namespace.NewPath("/unit-test/registry/txn/b")
+	version, err := reg.Put(a, []byte("a"), false)
+	c.Assert(err, IsNil)
+
+	watch, stop := context.WithCancel(context.Background())
+	members, err := reg.Trigger(watch, namespace.Members{Path: a.Dir()})
+	c.Assert(err, IsNil)
+	defer stop()
+
+	// The last op fails, so the create of b and the set of a are rolled back.
+	results, err := reg.Txn(
+		namespace.OpCreate{Path: b, Value: []byte("b")},
+		namespace.OpSet{Path: a, Value: []byte("a2"), Version: version},
+		namespace.OpCheck{Path: a, Version: version + 10},
+	)
+	c.Assert(err, NotNil)
+	txnErr, is := err.(*namespace.TxnError)
+	c.Assert(is, Equals, true)
+	c.Assert(txnErr.Index, Equals, 2)
+	c.Assert(txnErr.Err, Equals, namespace.ErrBadVersion)
+	c.Assert(results[0].Err, Equals, namespace.ErrRolledBack)
+	c.Assert(results[2].Err, Equals, namespace.ErrBadVersion)
+
+	exists, err := reg.Exists(b)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	value, v, err := reg.Get(a)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("a"))
+	c.Assert(v, Equals, version)
+	c.Assert(len(nstest.CollectMembers(members, 1, delay)), Equals, 0)
+
+	// Now all of them are applied.
+	results, err = reg.Txn(
+		namespace.OpCheck{Path: a, Version: version},
+		namespace.OpCreate{Path: b, Value: []byte("b")},
+		namespace.OpSet{Path: a, Value: []byte("a2"), Version: namespace.InvalidVersion},
+		namespace.OpDelete{Path: a, Version: version + 1},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(len(results), Equals, 4)
+	c.Assert(results[2].Version, Equals, version+1)
+	// The events are delivered after the txn, so the members changed once, from a to b.
+	changes := nstest.CollectMembers(members, 2, delay)
+	c.Assert(len(changes), Equals, 1)
+	c.Assert(changes[0].Before, DeepEquals, []string{a.String()})
+	c.Assert(changes[0].After, DeepEquals, []string{b.String()})
+
+	exists, err = reg.Exists(a)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+	value, _, err = reg.Get(b)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("b"))
+}
+
+func (suite *TestSuiteRegistry) TestStat(c *C) {
+	ctx := context.Background()
+	reg, err := namespace.Dial(ctx, "mem://stat")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/stat")
+	_, err = reg.Stat(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	before := time.Now()
+	_, err = reg.Put(p, []byte("12345"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("e"), []byte{}, true)
+	c.Assert(err, IsNil)
+	stat, err := reg.Stat(p)
+	c.Assert(err, IsNil)
+	c.Assert(stat.Version, Equals, namespace.Version(0))
+	c.Assert(stat.Size, Equals, 5)
+	c.Assert(stat.Children, Equals, 1)
+	c.Assert(stat.Ephemeral, Equals, false)
+	c.Assert(stat.Created.Before(before), Equals, false)
+	c.Assert(stat.Modified, Equals, stat.Created)
+
+	time.Sleep(10 * time.Millisecond)
+	_, err = reg.Put(p, []byte("123"), false)
+	c.Assert(err, IsNil)
+	changed, err := reg.Stat(p)
+	c.Assert(err, IsNil)
+	c.Assert(changed.Version, Equals, namespace.Version(1))
+	c.Assert(changed.Size, Equals, 3)
+	c.Assert(changed.Created, Equals, stat.Created)
+	c.Assert(changed.Modified.After(stat.Modified), Equals, true)
+
+	// The owner of an ephemeral node is the session that created it.
+	other, err := NewService(ctx, net.URL{Scheme: "mem", Host: "stat"}, nil)
+	c.Assert(err, IsNil)
+	defer other.Close()
+	ephemeral, err := other.Stat(p.Sub("e"))
+	c.Assert(err, IsNil)
+	c.Assert(ephemeral.Ephemeral, Equals, true)
+	c.Assert(ephemeral.Owner, Not(Equals), "")
+	_, err = other.Put(p.Sub("f"), []byte{}, true)
+	c.Assert(err, IsNil)
+	owned, err := other.Stat(p.Sub("f"))
+	c.Assert(err, IsNil)
+	c.Assert(owned.Owner, Not(Equals), ephemeral.Owner)
+
+	// Relative to the chroot, and in templates.
+	chroot, err := namespace.Dial(ctx, "mem://stat?chroot="+p.Dir().String())
+	c.Assert(err, IsNil)
+	defer chroot.Close()
+	stat, err = chroot.Stat(namespace.NewPath("/stat"))
+	c.Assert(err, IsNil)
+	c.Assert(stat.Size, Equals, 3)
+
+	_, err = reg.Put(namespace.NewPath("/tmpl"), []byte(`{{with stat "mem://stat`+p.String()+`"}}`+
+		`{{.Version}} {{.Size}} {{.Children}} {{.Modified.IsZero}}{{end}}`), false)
+	c.Assert(err, IsNil)
+	applied, err := template.Execute(ctx, "mem://stat/tmpl")
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "1 3 2 false")
+}
@@ -0,0 +1,11 @@
+package mem
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/resource"
+)
+
+// Binds the mem protocol to the generic Source implementation in the namespace package.
+func init() {
+	resource.Register("mem", namespace.Source)
+}
@@ -0,0 +1,389 @@
+package mem
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	p "path"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	treesLock sync.Mutex
+	trees     = map[string]*tree{}
+)
+
+// A node in the tree.  Ephemeral nodes have an owner, which is the registry (session) that
+// created them.  When the owner closes, the node is removed.
+// The sequence is the number of children created, as the cversion in zk, and numbers the sequential
+// children.
+type node struct {
+	value    []byte
+	version  Version
+	owner    *registry
+	children map[string]bool
+	sequence int64
+	created  time.Time
+	modified time.Time
+}
+
+// The tree is shared by all registries dialed with the same host, for the lifetime of the process.
+// This plays the role of the server in a real backend, while each registry is a session.
+type tree struct {
+	lock     sync.Mutex
+	nodes    map[string]*node
+	watchers map[*watcher]bool
+
+	// While a transaction is applied, events are held back and the undo functions are recorded.
+	inTxn bool
+	held  []Event
+	undo  []func()
+}
+
+func getTree(name string) *tree {
+	treesLock.Lock()
+	defer treesLock.Unlock()
+	t, has := trees[name]
+	if !has {
+		now := time.Now()
+		t = &tree{
+			nodes:    map[string]*node{"/": &node{value: []byte{}, children: map[string]bool{}, created: now, modified: now}},
+			watchers: map[*watcher]bool{},
+		}
+		trees[name] = t
+	}
+	return t
+}
+
+func clean(key string) string {
+	return p.Clean(p.Join("/", key))
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	c := make([]byte, len(b))
+	copy(c, b)
+	return c
+}
+
+func (this *tree) get(key string) ([]byte, Version, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	n, has := this.nodes[clean(key)]
+	if !has {
+		return nil, InvalidVersion, ErrNotExist
+	}
+	return copyBytes(n.value), n.version, nil
+}
+
+func (this *tree) ephemeral(key string) (bool, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	n, has := this.nodes[clean(key)]
+	if !has {
+		return false, ErrNotExist
+	}
+	return n.owner != nil, nil
+}
+
+func (this *tree) stat(key string) (*Stat, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	n, has := this.nodes[clean(key)]
+	if !has {
+		return nil, ErrNotExist
+	}
+	s := &Stat{
+		Version:   n.version,
+		Created:   n.created,
+		Modified:  n.modified,
+		Size:      len(n.value),
+		Children:  len(n.children),
+		Ephemeral: n.owner != nil,
+	}
+	if n.owner != nil {
+		s.Owner = n.owner.session
+	}
+	return s, nil
+}
+
+func (this *tree) exists(key string) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	_, has := this.nodes[clean(key)]
+	return has
+}
+
+func (this *tree) list(key string) ([]string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	k := clean(key)
+	if _, has := this.nodes[k]; !has {
+		return nil, ErrNotExist
+	}
+	return this.children(k), nil
+}
+
+// Matches the pattern against all the nodes, in one pass under the lock.
+func (this *tree) glob(pattern string) ([]Path, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	paths := []Path{}
+	for k, _ := range this.nodes {
+		paths = append(paths, NewPath(k))
+	}
+	return FilterGlob(pattern, paths)
+}
+
+// Must hold lock.  Returns the full paths of the children, sorted.
+func (this *tree) children(k string) []string {
+	children := []string{}
+	if n, has := this.nodes[k]; has {
+		for c, _ := range n.children {
+			children = append(children, p.Join(k, c))
+		}
+	}
+	sort.Strings(children)
+	return children
+}
+
+// Creates a node and all its missing parents.  Parents are never ephemeral.  Must hold lock.
+func (this *tree) create(key string, value []byte, owner *registry) (Version, error) {
+	if _, has := this.nodes[key]; has {
+		return InvalidVersion, ErrNodeExists
+	}
+	dir := p.Dir(key)
+	parent, has := this.nodes[dir]
+	if !has {
+		if _, err := this.create(dir, []byte{}, nil); err != nil {
+			return InvalidVersion, err
+		}
+		parent = this.nodes[dir]
+	}
+	if parent.owner != nil {
+		return InvalidVersion, ErrNoChildrenForEphemerals
+	}
+	now := time.Now()
+	n := &node{value: copyBytes(value), version: 0, owner: owner, children: map[string]bool{}, created: now,
+		modified: now}
+	if n.value == nil {
+		n.value = []byte{}
+	}
+	this.nodes[key] = n
+	parent.children[p.Base(key)] = true
+	parent.sequence++
+	if this.inTxn {
+		this.undo = append(this.undo, func() {
+			delete(this.nodes, key)
+			delete(parent.children, p.Base(key))
+			parent.sequence--
+		})
+	}
+	this.notify(Event{Kind: EventCreate, Path: key, Version: n.version, Value: copyBytes(n.value)})
+	return n.version, nil
+}
+
+// Must hold lock.
+func (this *tree) set(key string, value []byte, version Version) (Version, error) {
+	n, has := this.nodes[key]
+	if !has {
+		return InvalidVersion, ErrNotExist
+	}
+	if version != InvalidVersion && version != n.version {
+		return InvalidVersion, ErrBadVersion
+	}
+	if this.inTxn {
+		value, version, modified := n.value, n.version, n.modified
+		this.undo = append(this.undo, func() {
+			n.value, n.version, n.modified = value, version, modified
+		})
+	}
+	n.value = copyBytes(value)
+	n.version++
+	n.modified = time.Now()
+	this.notify(Event{Kind: EventChange, Path: key, Version: n.version, Value: copyBytes(n.value)})
+	return n.version, nil
+}
+
+// Must hold lock.
+func (this *tree) remove(key string, version Version) error {
+	n, has := this.nodes[key]
+	if !has {
+		return ErrNotExist
+	}
+	if key == "/" {
+		return ErrNotEmpty
+	}
+	if version != InvalidVersion && version != n.version {
+		return ErrBadVersion
+	}
+	if len(n.children) > 0 {
+		return ErrNotEmpty
+	}
+	delete(this.nodes, key)
+	parent, has := this.nodes[p.Dir(key)]
+	if has {
+		delete(parent.children, p.Base(key))
+	}
+	if this.inTxn {
+		this.undo = append(this.undo, func() {
+			this.nodes[key] = n
+			parent.children[p.Base(key)] = true
+		})
+	}
+	this.notify(Event{Kind: EventDelete, Path: key, Version: n.version})
+	return nil
+}
+
+// Create or set.  Ephemeral nodes are always created, so if the node already exists, error is returned.
+func (this *tree) put(key string, value []byte, owner *registry) (Version, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	k := clean(key)
+	if _, has := this.nodes[k]; has && owner == nil {
+		return this.set(k, value, InvalidVersion)
+	}
+	return this.create(k, value, owner)
+}
+
+func (this *tree) putVersion(key string, value []byte, version Version) (Version, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.set(clean(key), value, version)
+}
+
+func (this *tree) delete(key string, version Version) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.remove(clean(key), version)
+}
+
+// Applies all or none of the operations.  The events are sent only if all the operations succeed.
+func (this *tree) txn(ops []Op, owner *registry) ([]OpResult, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	this.inTxn, this.held, this.undo = true, []Event{}, []func(){}
+	defer func() {
+		this.inTxn, this.held, this.undo = false, nil, nil
+	}()
+
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		key := clean(PathOf(op).String())
+		version := InvalidVersion
+		var err error
+		switch op := op.(type) {
+		case OpCheck:
+			if n, has := this.nodes[key]; !has {
+				err = ErrNotExist
+			} else if op.Version != InvalidVersion && op.Version != n.version {
+				err = ErrBadVersion
+			} else {
+				version = n.version
+			}
+		case OpCreate:
+			var o *registry
+			if op.Ephemeral {
+				o = owner
+			}
+			if op.Sequential {
+				seq := int64(0)
+				if parent, has := this.nodes[p.Dir(key)]; has {
+					seq = parent.sequence
+				}
+				key = SequentialPath(NewPath(key), seq).String()
+			}
+			version, err = this.create(key, op.Value, o)
+		case OpSet:
+			version, err = this.set(key, op.Value, op.Version)
+		case OpDelete:
+			err = this.remove(key, op.Version)
+		}
+		if err != nil {
+			for j := len(this.undo) - 1; j >= 0; j-- {
+				this.undo[j]()
+			}
+			return AbortTxn(ops, i, err)
+		}
+		results[i] = OpResult{Path: PathOf(op), Version: version}
+		if create, is := op.(OpCreate); is && create.Sequential {
+			results[i].Path = NewPath(key)
+		}
+	}
+
+	held := this.held
+	this.inTxn = false
+	for _, e := range held {
+		this.notify(e)
+	}
+	return results, nil
+}
+
+// Removes all the ephemeral nodes and watches of the given session.
+func (this *tree) closeSession(owner *registry) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	keys := []string{}
+	for k, n := range this.nodes {
+		if n.owner == owner {
+			keys = append(keys, k)
+		}
+	}
+	for _, k := range keys {
+		this.remove(k, InvalidVersion)
+	}
+	for w, _ := range this.watchers {
+		if w.owner == owner {
+			delete(this.watchers, w)
+			w.close()
+		}
+	}
+}
+
+func (this *tree) watch(w *watcher) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if t, is := w.trigger.(Members); is {
+		w.members = NewMembersMatcher(t, this.children(w.path))
+	}
+	this.watchers[w] = true
+}
+
+func (this *tree) unwatch(w *watcher) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	delete(this.watchers, w)
+}
+
+// Must hold lock.  Delivery to the watchers never blocks the writer.
+func (this *tree) notify(e Event) {
+	if this.inTxn {
+		this.held = append(this.held, e)
+		return
+	}
+	for w, _ := range this.watchers {
+		switch w.trigger.(type) {
+		case Create:
+			if e.Kind == EventCreate && w.path == e.Path {
+				w.enqueue(e)
+			}
+		case Change:
+			if e.Kind == EventChange && w.path == e.Path {
+				w.enqueue(e)
+			}
+		case Delete:
+			if e.Kind == EventDelete && w.path == e.Path {
+				w.enqueue(e)
+			}
+		case Members:
+			if (e.Kind == EventCreate || e.Kind == EventDelete) && w.path == p.Dir(e.Path) && e.Path != "/" {
+				if change := w.members.Update(this.children(w.path)); change != nil {
+					w.enqueue(change.Event())
+				}
+			}
+		}
+	}
+}
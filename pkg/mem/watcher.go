@@ -0,0 +1,79 @@
+package mem
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"sync"
+)
+
+// A watcher queues up the events matching its trigger and delivers them in order
+// on the events channel in its own goroutine, so that writers are never blocked by slow readers.
+type watcher struct {
+	trigger Trigger
+	path    string
+	owner   *registry
+	events  chan Event
+	members *MembersMatcher // for Members triggers
+
+	lock    sync.Mutex
+	queue   []Event
+	signal  chan int
+	done    chan int
+	stopped bool
+}
+
+func newWatcher(owner *registry, trigger Trigger, path Path) *watcher {
+	return &watcher{
+		trigger: trigger,
+		path:    clean(path.String()),
+		owner:   owner,
+		events:  make(chan Event, 8),
+		queue:   []Event{},
+		signal:  make(chan int, 1),
+		done:    make(chan int),
+	}
+}
+
+func (this *watcher) enqueue(e Event) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.stopped {
+		return
+	}
+	this.queue = append(this.queue, e)
+	select {
+	case this.signal <- 1:
+	default:
+	}
+}
+
+func (this *watcher) close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.stopped {
+		this.stopped = true
+		close(this.done)
+	}
+}
+
+func (this *watcher) run() {
+	defer close(this.events)
+	for {
+		this.lock.Lock()
+		pending := this.queue
+		this.queue = []Event{}
+		this.lock.Unlock()
+
+		for _, e := range pending {
+			select {
+			case this.events <- e:
+			case <-this.done:
+				return
+			}
+		}
+		select {
+		case <-this.signal:
+		case <-this.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package mirror
+
+import (
+	"bytes"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"path"
+	"sort"
+	"strings"
+)
+
+type DiffKind int
+
+const (
+	Added   DiffKind = iota + 1 // the node is in the source only
+	Changed                     // the values differ
+	Deleted                     // the node is in the target only
+)
+
+var (
+	diff_kinds = map[DiffKind]string{
+		Added:   "added",
+		Changed: "changed",
+		Deleted: "deleted",
+	}
+)
+
+func (this DiffKind) String() string {
+	return diff_kinds[this]
+}
+
+// A difference between the source and the target.  The path is relative to the roots of the two
+// trees, e.g. /a for both /prod/a in the source and /staging/a in the target.
+type Difference struct {
+	Kind DiffKind
+	Path Path
+
+	// The target node was changed by someone else since the mirror last wrote it.  See MirrorOptions.Policy.
+	Conflict bool
+
+	// The difference was applied to the target.  Always false for Diff.
+	Applied bool
+
+	// Set on the last difference delivered by Mirror.Start, if syncing failed.
+	Err error
+}
+
+func (this Difference) String() string {
+	s := this.Kind.String() + " " + this.Path.String()
+	if this.Conflict {
+		s += " conflict"
+	}
+	if this.Err != nil {
+		s += " error=" + this.Err.Error()
+	}
+	return s
+}
+
+// Decides which nodes are mirrored, given their path relative to the root.  A node that is left out
+// is left out with all its descendants.  The roots are always mirrored.
+type Filter func(Path) bool
+
+// Leaves out the nodes whose relative path matches any of the patterns, in the syntax of path.Match,
+// e.g. /locks or /services/*/leader.
+func Exclude(patterns ...string) Filter {
+	return func(p Path) bool {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, p.String()); matched {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+type entry struct {
+	value     []byte
+	version   Version
+	ephemeral bool
+}
+
+// Reads the node at rel under root, and its descendants if deep, keyed by the paths relative to root.
+// A missing node is an empty tree.
+func read(reg Registry, root, rel Path, deep bool, filter Filter) (map[string]entry, error) {
+	reporter, canReport := reg.(EphemeralReporter)
+	tree := map[string]entry{}
+	err := Walk(reg, NewPath(root.String(), rel.String()), func(p Path, value []byte, version Version) error {
+		rel := relative(root, p)
+		if rel.String() != "/" && filter != nil && !filter(rel) {
+			return SkipChildren
+		}
+		e := entry{value: value, version: version}
+		if canReport {
+			ephemeral, err := reporter.IsEphemeral(p)
+			if err != nil && err != ErrNotExist {
+				return err
+			}
+			e.ephemeral = ephemeral
+		}
+		tree[rel.String()] = e
+		if !deep {
+			return SkipChildren
+		}
+		return nil
+	})
+	return tree, err
+}
+
+func relative(root, p Path) Path {
+	return NewPath(strings.TrimPrefix(p.String(), root.String()))
+}
+
+// Compares the trees.  Additions and changes come parents first, deletions children first, so that
+// they can be applied in order.
+func compare(src, dst map[string]entry) []Difference {
+	diffs := []Difference{}
+	for _, p := range sorted(src) {
+		if d, has := dst[p]; !has {
+			diffs = append(diffs, Difference{Kind: Added, Path: NewPath(p)})
+		} else if !bytes.Equal(d.value, src[p].value) {
+			diffs = append(diffs, Difference{Kind: Changed, Path: NewPath(p)})
+		}
+	}
+	deleted := sorted(dst)
+	for i := len(deleted) - 1; i >= 0; i-- {
+		if _, has := src[deleted[i]]; !has {
+			diffs = append(diffs, Difference{Kind: Deleted, Path: NewPath(deleted[i])})
+		}
+	}
+	return diffs
+}
+
+// Sorted so that parents come before their children.
+func sorted(tree map[string]entry) []string {
+	paths := []string{}
+	for p, _ := range tree {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Returns what it takes for the subtree at dstRoot to be the same as the one at srcRoot.  Versions are
+// not comparable across registries, so only the values are compared.
+func Diff(src Registry, srcRoot Path, dst Registry, dstRoot Path, filter Filter) ([]Difference, error) {
+	from, err := read(src, srcRoot, NewPath("/"), true, filter)
+	if err != nil {
+		return nil, err
+	}
+	to, err := read(dst, dstRoot, NewPath("/"), true, filter)
+	if err != nil {
+		return nil, err
+	}
+	return compare(from, to), nil
+}
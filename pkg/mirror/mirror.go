@@ -0,0 +1,347 @@
+package mirror
+
+import (
+	"bytes"
+	"errors"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"strings"
+	"sync"
+)
+
+// Returned internally when the target changed between reading and writing it.
+var errRaced = errors.New("error-raced")
+
+type MirrorOptions struct {
+	// Nodes left out of the mirror.  Default is all the nodes.
+	Filter Filter
+
+	// What to do with target nodes changed by someone else since the mirror last wrote them.
+	// ConflictOverwrite (the default) makes the target the same as the source anyway,
+	// ConflictSkip leaves such nodes as they are, and ConflictFailOnVersionMismatch stops with a
+	// VersionMismatch.  Until the mirror has written a target node, any difference at that node is a
+	// conflict, since it's not known who wrote it.
+	Policy ConflictPolicy
+
+	// Leaves out the ephemeral nodes of the source, if the source implements EphemeralReporter.
+	// Otherwise they are copied as persistent nodes.
+	SkipEphemeral bool
+}
+
+// A mirror makes a subtree of the target registry the same as a subtree of the source registry, e.g.
+// a disaster recovery copy of a zk tree in another cluster, or a staging tree seeded from production.
+// The registries can be of different schemes.  Versions are not comparable across registries, so the
+// mirror remembers the version of the target nodes it wrote to tell the changes made by others.
+type Mirror struct {
+	src     Registry
+	srcRoot Path
+	dst     Registry
+	dstRoot Path
+	options MirrorOptions
+
+	lock    sync.Mutex
+	written map[string]Version // version of the target nodes in sync, by relative path
+}
+
+func New(src Registry, srcRoot Path, dst Registry, dstRoot Path, options MirrorOptions) *Mirror {
+	return &Mirror{
+		src:     src,
+		srcRoot: srcRoot,
+		dst:     dst,
+		dstRoot: dstRoot,
+		options: options,
+		written: map[string]Version{},
+	}
+}
+
+// Makes the target the same as the source, once.  Returns the differences found, with Applied set for
+// the ones written to the target.
+func (this *Mirror) Sync() ([]Difference, error) {
+	diffs, _, err := this.sync(NewPath("/"), true)
+	return diffs, err
+}
+
+// Syncs the node at rel, and its descendants if deep.  Returns the differences and the source nodes.
+func (this *Mirror) sync(rel Path, deep bool) ([]Difference, map[string]entry, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	diffs := []Difference{}
+	for {
+		applied, src, err := this.pass(rel, deep)
+		diffs = append(diffs, applied...)
+		if err != errRaced {
+			return diffs, src, err
+		}
+	}
+}
+
+func (this *Mirror) pass(rel Path, deep bool) ([]Difference, map[string]entry, error) {
+	src, err := read(this.src, this.srcRoot, rel, deep, this.options.Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := read(this.dst, this.dstRoot, rel, deep, this.options.Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	if this.options.SkipEphemeral {
+		for p, e := range src {
+			if e.ephemeral {
+				delete(src, p)
+				delete(dst, p)
+			}
+		}
+	}
+	for p, e := range dst {
+		if s, has := src[p]; has && bytes.Equal(s.value, e.value) {
+			this.written[p] = e.version
+		}
+	}
+	for p, _ := range this.written {
+		if _, has := dst[p]; !has && under(rel, p, deep) {
+			if _, has := src[p]; !has {
+				delete(this.written, p)
+			}
+		}
+	}
+	applied := []Difference{}
+	for _, d := range compare(src, dst) {
+		if d.Kind == Deleted && !deep {
+			continue // The children are not known.  A deep sync deletes the subtree.
+		}
+		d, err := this.apply(d, src, dst)
+		applied = append(applied, d)
+		if err != nil {
+			return applied, src, err
+		}
+	}
+	return applied, src, nil
+}
+
+// Whether the relative path p is rel, or one of its descendants if deep.
+func under(rel Path, p string, deep bool) bool {
+	switch {
+	case p == rel.String():
+		return true
+	case !deep:
+		return false
+	case rel.String() == "/":
+		return true
+	}
+	return strings.HasPrefix(p, rel.String()+"/")
+}
+
+func (this *Mirror) apply(d Difference, src, dst map[string]entry) (Difference, error) {
+	p := d.Path.String()
+	target := NewPath(this.dstRoot.String(), p)
+	current, exists := dst[p]
+	last, known := this.written[p]
+	d.Conflict = (exists && (!known || current.version != last)) || (!exists && known)
+	if d.Conflict {
+		switch this.options.Policy {
+		case ConflictSkip:
+			return d, nil
+		case ConflictFailOnVersionMismatch:
+			mismatch := &VersionMismatch{Path: target, Expected: InvalidVersion, Actual: InvalidVersion}
+			if known {
+				mismatch.Expected = last
+			}
+			if exists {
+				mismatch.Actual = current.version
+			}
+			return d, mismatch
+		}
+	}
+	var version Version
+	var err error
+	switch {
+	case d.Kind == Deleted && d.Conflict:
+		err = this.dst.Delete(target)
+	case d.Kind == Deleted:
+		err = this.dst.DeleteVersion(target, last)
+	case d.Kind == Changed && !d.Conflict:
+		version, err = this.dst.PutVersion(target, src[p].value, last)
+	default:
+		version, err = this.dst.Put(target, src[p].value, false)
+	}
+	switch {
+	case err == ErrBadVersion:
+		return d, errRaced
+	case err == ErrNotExist && d.Kind == Deleted:
+	case err != nil:
+		return d, err
+	}
+	if d.Kind == Deleted {
+		delete(this.written, p)
+	} else {
+		this.written[p] = version
+	}
+	d.Applied = true
+	return d, nil
+}
+
+// Syncs the target, then keeps it in sync with triggers on the source until the context is done.
+// The differences found are delivered on the channel, including the ones of the first sync.  If
+// syncing fails, a Difference with Err is delivered before the channel is closed.  The source root
+// must exist.
+func (this *Mirror) Start(ctx context.Context) (<-chan Difference, error) {
+	exists, err := this.src.Exists(this.srcRoot)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotExist
+	}
+	w := &watcher{
+		mirror:  this,
+		ctx:     ctx,
+		watches: map[string]context.CancelFunc{},
+		tasks:   make(chan task),
+	}
+	diffs, err := w.sync(NewPath("/"), true)
+	if err != nil {
+		w.stop()
+		return nil, err
+	}
+	out := make(chan Difference)
+	go w.loop(diffs, out)
+	return out, nil
+}
+
+type task struct {
+	rel   Path
+	event Event
+}
+
+// Keeps a Change and a Members trigger on each source node of the mirror, and syncs the nodes the
+// events are about.
+type watcher struct {
+	mirror  *Mirror
+	ctx     context.Context
+	watches map[string]context.CancelFunc // by relative path
+	tasks   chan task
+}
+
+func (this *watcher) loop(pending []Difference, out chan<- Difference) {
+	defer close(out)
+	defer this.stop()
+	for {
+		var send chan<- Difference
+		var next Difference
+		if len(pending) > 0 {
+			send, next = out, pending[0]
+		}
+		select {
+		case send <- next:
+			pending = pending[1:]
+		case t := <-this.tasks:
+			diffs, err := this.handle(t)
+			pending = append(pending, diffs...)
+			if err != nil {
+				glog.Warningln("Mirror stopped at", t.rel, "err=", err)
+				pending = append(pending, Difference{Path: t.rel, Err: err})
+				for _, d := range pending {
+					select {
+					case out <- d:
+					case <-this.ctx.Done():
+						return
+					}
+				}
+				return
+			}
+		case <-this.ctx.Done():
+			return
+		}
+	}
+}
+
+func (this *watcher) handle(t task) ([]Difference, error) {
+	switch t.event.Kind {
+	case EventChange:
+		diffs, _, err := this.mirror.sync(t.rel, false)
+		return diffs, err
+	case EventSession:
+		return nil, nil
+	case EventError:
+		glog.Warningln("Trigger on", t.rel, "failed, err=", t.event.Err)
+		if cancel, has := this.watches[t.rel.String()]; has {
+			cancel()
+			delete(this.watches, t.rel.String())
+		}
+	}
+	return this.sync(t.rel, true)
+}
+
+// Syncs the subtree and updates the watches, until the subtree is in sync with all its nodes watched.
+func (this *watcher) sync(rel Path, deep bool) ([]Difference, error) {
+	diffs := []Difference{}
+	for {
+		applied, src, err := this.mirror.sync(rel, deep)
+		diffs = append(diffs, applied...)
+		if err != nil {
+			return diffs, err
+		}
+		for p, cancel := range this.watches {
+			if _, has := src[p]; !has && under(rel, p, true) {
+				cancel()
+				delete(this.watches, p)
+			}
+		}
+		added := false
+		for _, p := range sorted(src) {
+			if _, has := this.watches[p]; has {
+				continue
+			}
+			if err := this.watch(NewPath(p)); err != nil {
+				return diffs, err
+			}
+			added = true
+		}
+		// Changes made before the new triggers were set are picked up by another pass.
+		if !added {
+			return diffs, nil
+		}
+	}
+}
+
+func (this *watcher) watch(rel Path) error {
+	ctx, cancel := context.WithCancel(this.ctx)
+	p := NewPath(this.mirror.srcRoot.String(), rel.String())
+	for _, t := range []Trigger{Change{Path: p}, Members{Path: p}} {
+		events, err := this.mirror.src.Trigger(ctx, t)
+		if err == ErrNotExist {
+			break // Deleted in the meantime.  The members trigger of the parent tells.
+		} else if err != nil {
+			cancel()
+			return err
+		}
+		go this.forward(ctx, rel, events)
+	}
+	this.watches[rel.String()] = cancel
+	return nil
+}
+
+func (this *watcher) forward(ctx context.Context, rel Path, events <-chan Event) {
+	for e := range events {
+		select {
+		case this.tasks <- task{rel: rel, event: e}:
+		case <-ctx.Done():
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		// The trigger stopped on its own, e.g. the registry was closed.
+		select {
+		case this.tasks <- task{rel: rel, event: Event{Kind: EventError, Err: ErrClosed}}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (this *watcher) stop() {
+	for p, cancel := range this.watches {
+		cancel()
+		delete(this.watches, p)
+	}
+}
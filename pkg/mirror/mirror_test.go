@@ -0,0 +1,241 @@
+package mirror
+
+import (
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+	"testing"
+	"time"
+)
+
+var delay = 2 * time.Second
+
+func TestMirror(t *testing.T) { TestingT(t) }
+
+type TestSuiteMirror struct{}
+
+var _ = Suite(&TestSuiteMirror{})
+
+func (suite *TestSuiteMirror) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteMirror) TearDownSuite(c *C) {
+}
+
+func registry(c *C, host string) namespace.Registry {
+	reg, err := mem.NewService(context.Background(), net.URL{Scheme: "mem", Host: host}, nil)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+func put(c *C, reg namespace.Registry, p, value string) namespace.Version {
+	version, err := reg.Put(namespace.NewPath(p), []byte(value), false)
+	c.Assert(err, IsNil)
+	return version
+}
+
+func get(c *C, reg namespace.Registry, p string) string {
+	value, _, err := reg.Get(namespace.NewPath(p))
+	if err == namespace.ErrNotExist {
+		return "<none>"
+	}
+	c.Assert(err, IsNil)
+	return string(value)
+}
+
+// The kinds and paths of the differences, e.g. "added /a".
+func summary(diffs []Difference) []string {
+	out := []string{}
+	for _, d := range diffs {
+		out = append(out, d.Kind.String()+" "+d.Path.String())
+	}
+	return out
+}
+
+// Reads differences from the channel until the one at the path, or the timeout.
+func waitFor(c *C, diffs <-chan Difference, kind DiffKind, p string) Difference {
+	timeout := time.After(delay)
+	for {
+		select {
+		case d := <-diffs:
+			c.Assert(d.Err, IsNil)
+			if d.Kind == kind && d.Path.String() == p {
+				return d
+			}
+		case <-timeout:
+			c.Fatal("No difference", kind, p)
+		}
+	}
+}
+
+func (suite *TestSuiteMirror) TestDiff(c *C) {
+	src, dst := registry(c, "diff-src"), registry(c, "diff-dst")
+	defer src.Close()
+	defer dst.Close()
+
+	put(c, src, "/prod/a", "a")
+	put(c, src, "/prod/a/b", "b")
+	put(c, src, "/prod/c", "c")
+	put(c, src, "/prod/locks/x", "x")
+	put(c, dst, "/staging/a", "a")
+	put(c, dst, "/staging/c", "old")
+	put(c, dst, "/staging/d", "d")
+	put(c, dst, "/staging/d/e", "e")
+
+	diffs, err := Diff(src, namespace.NewPath("/prod"), dst, namespace.NewPath("/staging"), Exclude("/locks"))
+	c.Assert(err, IsNil)
+	c.Assert(summary(diffs), DeepEquals, []string{
+		"added /a/b", "changed /c", "deleted /d/e", "deleted /d",
+	})
+	for _, d := range diffs {
+		c.Assert(d.Applied, Equals, false)
+	}
+
+	// Nothing is written.
+	c.Assert(get(c, dst, "/staging/c"), Equals, "old")
+
+	// A missing tree is empty.
+	diffs, err = Diff(src, namespace.NewPath("/prod/a"), dst, namespace.NewPath("/none"), nil)
+	c.Assert(err, IsNil)
+	c.Assert(summary(diffs), DeepEquals, []string{"added /", "added /b"})
+}
+
+func (suite *TestSuiteMirror) TestSync(c *C) {
+	src, dst := registry(c, "sync-src"), registry(c, "sync-dst")
+	defer src.Close()
+	defer dst.Close()
+
+	put(c, src, "/prod/a", "a")
+	put(c, src, "/prod/a/b", "b")
+	put(c, dst, "/staging/c", "c")
+
+	m := New(src, namespace.NewPath("/prod"), dst, namespace.NewPath("/staging"), MirrorOptions{})
+	diffs, err := m.Sync()
+	c.Assert(err, IsNil)
+	c.Assert(summary(diffs), DeepEquals, []string{"added /a", "added /a/b", "deleted /c"})
+	c.Assert(diffs[0].Conflict, Equals, false)
+	c.Assert(diffs[2].Conflict, Equals, true) // not written by the mirror
+	c.Assert(diffs[2].Applied, Equals, true)
+	c.Assert(get(c, dst, "/staging/a/b"), Equals, "b")
+	c.Assert(get(c, dst, "/staging/c"), Equals, "<none>")
+
+	diffs, err = m.Sync()
+	c.Assert(err, IsNil)
+	c.Assert(len(diffs), Equals, 0)
+
+	// Changed in the source only.
+	put(c, src, "/prod/a", "a2")
+	diffs, err = m.Sync()
+	c.Assert(err, IsNil)
+	c.Assert(summary(diffs), DeepEquals, []string{"changed /a"})
+	c.Assert(diffs[0].Conflict, Equals, false)
+	c.Assert(get(c, dst, "/staging/a"), Equals, "a2")
+}
+
+func (suite *TestSuiteMirror) TestConflicts(c *C) {
+	src, dst := registry(c, "conflict-src"), registry(c, "conflict-dst")
+	defer src.Close()
+	defer dst.Close()
+
+	put(c, src, "/prod/a", "a")
+	for i, policy := range []namespace.ConflictPolicy{
+		namespace.ConflictSkip, namespace.ConflictFailOnVersionMismatch, namespace.ConflictOverwrite,
+	} {
+		put(c, src, "/prod/a", "a")
+		staging := namespace.NewPathf("/staging-%d", i)
+		m := New(src, namespace.NewPath("/prod"), dst, staging, MirrorOptions{Policy: policy})
+		_, err := m.Sync()
+		c.Assert(err, IsNil)
+
+		// Changed in both.
+		version := put(c, dst, staging.Sub("a").String(), "local")
+		put(c, src, "/prod/a", "a2")
+		diffs, err := m.Sync()
+		c.Assert(summary(diffs), DeepEquals, []string{"changed /a"})
+		c.Assert(diffs[0].Conflict, Equals, true)
+
+		switch policy {
+		case namespace.ConflictSkip:
+			c.Assert(err, IsNil)
+			c.Assert(diffs[0].Applied, Equals, false)
+			c.Assert(get(c, dst, staging.Sub("a").String()), Equals, "local")
+		case namespace.ConflictFailOnVersionMismatch:
+			mismatch, is := err.(*namespace.VersionMismatch)
+			c.Assert(is, Equals, true)
+			c.Assert(mismatch.Path, Equals, staging.Sub("a"))
+			c.Assert(mismatch.Actual, Equals, version)
+			c.Assert(get(c, dst, staging.Sub("a").String()), Equals, "local")
+		case namespace.ConflictOverwrite:
+			c.Assert(err, IsNil)
+			c.Assert(diffs[0].Applied, Equals, true)
+			c.Assert(get(c, dst, staging.Sub("a").String()), Equals, "a2")
+		}
+	}
+}
+
+func (suite *TestSuiteMirror) TestSkipEphemeral(c *C) {
+	src, dst := registry(c, "ephemeral-src"), registry(c, "ephemeral-dst")
+	defer src.Close()
+	defer dst.Close()
+
+	put(c, src, "/prod/a", "a")
+	_, err := src.Put(namespace.NewPath("/prod/a/e"), []byte("e"), true)
+	c.Assert(err, IsNil)
+
+	m := New(src, namespace.NewPath("/prod"), dst, namespace.NewPath("/staging"), MirrorOptions{SkipEphemeral: true})
+	diffs, err := m.Sync()
+	c.Assert(err, IsNil)
+	c.Assert(summary(diffs), DeepEquals, []string{"added /", "added /a"})
+}
+
+func (suite *TestSuiteMirror) TestStart(c *C) {
+	src, dst := registry(c, "start-src"), registry(c, "start-dst")
+	defer src.Close()
+	defer dst.Close()
+
+	put(c, src, "/prod/a", "a")
+	m := New(src, namespace.NewPath("/prod"), dst, namespace.NewPath("/staging"), MirrorOptions{
+		Filter: Exclude("/locks"),
+		Policy: namespace.ConflictSkip,
+	})
+	ctx, stop := context.WithCancel(context.Background())
+	diffs, err := m.Start(ctx)
+	c.Assert(err, IsNil)
+	waitFor(c, diffs, Added, "/a")
+	c.Assert(get(c, dst, "/staging/a"), Equals, "a")
+
+	// New nodes, at any depth.
+	put(c, src, "/prod/b/c", "c")
+	waitFor(c, diffs, Added, "/b/c")
+	c.Assert(get(c, dst, "/staging/b/c"), Equals, "c")
+
+	put(c, src, "/prod/b/c", "c2")
+	waitFor(c, diffs, Changed, "/b/c")
+	c.Assert(get(c, dst, "/staging/b/c"), Equals, "c2")
+
+	c.Assert(src.Delete(namespace.NewPath("/prod/b/c")), IsNil)
+	waitFor(c, diffs, Deleted, "/b/c")
+	c.Assert(get(c, dst, "/staging/b/c"), Equals, "<none>")
+
+	// Left out.
+	put(c, src, "/prod/locks/x", "x")
+
+	// Changed in the target, then in the source.
+	put(c, dst, "/staging/a", "local")
+	put(c, src, "/prod/a", "a2")
+	d := waitFor(c, diffs, Changed, "/a")
+	c.Assert(d.Conflict, Equals, true)
+	c.Assert(d.Applied, Equals, false)
+	c.Assert(get(c, dst, "/staging/a"), Equals, "local")
+	c.Assert(get(c, dst, "/staging/locks"), Equals, "<none>")
+
+	stop()
+	for _ = range diffs {
+	}
+
+	// The source root must exist.
+	_, err = New(src, namespace.NewPath("/none"), dst, namespace.NewPath("/x"), MirrorOptions{}).Start(context.Background())
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
@@ -0,0 +1,177 @@
+package namespace
+
+import (
+	"bytes"
+	"errors"
+	"github.com/conductant/gohm/pkg/encoding"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	net "net/url"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrNotPointer = errors.New("error-bind-target-not-pointer")
+
+// A typed value bound to a registry node.  The value is decoded again whenever the node changes.
+type Binding struct {
+	reg         Registry
+	path        Path
+	contentType encoding.ContentType
+	target      reflect.Value
+	onChange    func(interface{})
+
+	lock    sync.Mutex // serializes updates
+	value   []byte     // decoded last
+	current atomic.Value
+	version Version
+	cancel  context.CancelFunc
+	once    sync.Once
+
+	targetLock sync.RWMutex // held while the target is written
+}
+
+// Fetches the node at the url, unmarshals it into target, which must be a pointer, and keeps it up to
+// date until the binding is closed or the context is done.  The node is watched for creation, change
+// and deletion, and each new value is decoded into a new value of the target's type, which is then
+// swapped in atomically: Value returns it and onChange, if not nil, is called with it.  The target
+// itself is overwritten too, while holding the lock of RLock, so code that reads the target
+// concurrently with updates must hold RLock, or use Value or onChange instead.  A value that cannot be
+// decoded or a deleted node leaves the last good value in place, and a node created again is decoded
+// even if its version is the same as before.
+func Bind(ctx context.Context, url string, target interface{}, contentType encoding.ContentType,
+	onChange func(interface{})) (*Binding, error) {
+
+	t := reflect.ValueOf(target)
+	if t.Kind() != reflect.Ptr || t.IsNil() {
+		return nil, ErrNotPointer
+	}
+	u, err := net.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	reg, err := Dial(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	binding := &Binding{
+		reg:         reg,
+		path:        NewPath(u.Path),
+		contentType: contentType,
+		target:      t,
+		onChange:    onChange,
+		version:     InvalidVersion,
+	}
+	ctx, binding.cancel = context.WithCancel(ctx)
+	// Watch before reading so that no change is missed.
+	events := make(chan Event)
+	var wg sync.WaitGroup
+	for _, trigger := range []Trigger{Create{Path: binding.path}, Change{Path: binding.path}, Delete{Path: binding.path}} {
+		ch, err := reg.Trigger(ctx, trigger)
+		if err != nil {
+			binding.Close()
+			return nil, err
+		}
+		wg.Add(1)
+		go func(ch <-chan Event) {
+			defer wg.Done()
+			// The binding stops as soon as any of its triggers does.
+			defer binding.cancel()
+			for e := range ch {
+				select {
+				case events <- e:
+				case <-ctx.Done():
+				}
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	if err := binding.load(); err != nil {
+		binding.Close()
+		return nil, err
+	}
+	go func() {
+		for e := range events {
+			if ctx.Err() != nil {
+				continue
+			}
+			switch e.Kind {
+			case EventDelete:
+				binding.lock.Lock()
+				binding.version = InvalidVersion
+				binding.lock.Unlock()
+			case EventError:
+				glog.Warningln("Watch of", binding.path, "failed, err=", e.Err)
+			default:
+				if err := binding.load(); err != nil {
+					glog.Warningln("Keeping last value of", binding.path, "err=", err)
+				}
+			}
+		}
+		binding.Close()
+	}()
+	return binding, nil
+}
+
+func (this *Binding) load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	buff, version, err := this.reg.Get(this.path)
+	if err != nil {
+		return err
+	}
+	// Compares the values and not the versions, which start over when the node is created again.
+	if this.value != nil && bytes.Equal(buff, this.value) {
+		this.version = version
+		return nil
+	}
+	value := reflect.New(this.target.Elem().Type())
+	if err := encoding.Unmarshal(this.contentType, bytes.NewBuffer(buff), value.Interface()); err != nil {
+		return err
+	}
+	this.value, this.version = buff, version
+	this.targetLock.Lock()
+	this.target.Elem().Set(value.Elem())
+	this.targetLock.Unlock()
+	this.current.Store(value.Interface())
+	if this.onChange != nil {
+		this.onChange(value.Interface())
+	}
+	return nil
+}
+
+// Locks the target for reading, since updates write it.  Not needed for Value.
+func (this *Binding) RLock() {
+	this.targetLock.RLock()
+}
+
+func (this *Binding) RUnlock() {
+	this.targetLock.RUnlock()
+}
+
+// Returns the latest value, as a pointer of the same type as the target.  The value must not be
+// modified since it's shared with other readers.
+func (this *Binding) Value() interface{} {
+	return this.current.Load()
+}
+
+// Returns the version of the node the current value was decoded from, or InvalidVersion if the node
+// was deleted since.
+func (this *Binding) Version() Version {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.version
+}
+
+func (this *Binding) Close() error {
+	this.once.Do(func() {
+		this.cancel()
+		this.reg.Close()
+	})
+	return nil
+}
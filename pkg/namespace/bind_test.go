@@ -0,0 +1,95 @@
+package namespace_test
+
+import (
+	"github.com/conductant/gohm/pkg/encoding"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"time"
+)
+
+func (suite *TestSuiteRegistry) TestBind(c *C) {
+	type settings struct {
+		Name    string            `json:"name"`
+		Timeout encoding.Duration `json:"timeout"`
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	url := memUrl("bind")
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/bind")
+	_, err = namespace.Bind(ctx, url+p.String(), &settings{}, encoding.ContentTypeJSON, nil)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	_, err = namespace.Bind(ctx, url+p.String(), settings{}, encoding.ContentTypeJSON, nil)
+	c.Assert(err, Equals, namespace.ErrNotPointer)
+
+	_, err = reg.Put(p, []byte(`{"name":"one","timeout":"1s"}`), false)
+	c.Assert(err, IsNil)
+
+	changes := make(chan *settings, 10)
+	target := &settings{}
+	binding, err := namespace.Bind(ctx, url+p.String(), target, encoding.ContentTypeJSON,
+		func(v interface{}) { changes <- v.(*settings) })
+	c.Assert(err, IsNil)
+	c.Assert(target.Name, Equals, "one")
+	c.Assert(target.Timeout.Duration, Equals, time.Second)
+	c.Assert(binding.Value().(*settings).Name, Equals, "one")
+	<-changes
+
+	_, err = reg.Put(p, []byte(`{"name":"two","timeout":"2s"}`), false)
+	c.Assert(err, IsNil)
+	select {
+	case v := <-changes:
+		c.Assert(v.Name, Equals, "two")
+		c.Assert(v.Timeout.Duration, Equals, 2*time.Second)
+	case <-time.After(delay):
+		c.Fatal("Should get change")
+	}
+	c.Assert(binding.Value().(*settings).Name, Equals, "two")
+	binding.RLock()
+	c.Assert(target.Name, Equals, "two")
+	binding.RUnlock()
+
+	// Bad values are ignored
+	_, err = reg.Put(p, []byte(`{"name":`), false)
+	c.Assert(err, IsNil)
+	time.Sleep(delay)
+	c.Assert(len(changes), Equals, 0)
+	c.Assert(binding.Value().(*settings).Name, Equals, "two")
+
+	// Deleting keeps the value, and the node created again is followed, at the same version as before.
+	err = reg.Delete(p)
+	c.Assert(err, IsNil)
+	time.Sleep(delay)
+	c.Assert(binding.Version(), Equals, namespace.InvalidVersion)
+	c.Assert(binding.Value().(*settings).Name, Equals, "two")
+	version, err := reg.Put(p, []byte(`{"name":"one","timeout":"1s"}`), false)
+	c.Assert(err, IsNil)
+	select {
+	case v := <-changes:
+		c.Assert(v.Name, Equals, "one")
+	case <-time.After(delay):
+		c.Fatal("Should get change")
+	}
+	c.Assert(binding.Version(), Equals, version)
+	_, err = reg.Put(p, []byte(`{"name":"two","timeout":"2s"}`), false)
+	c.Assert(err, IsNil)
+	select {
+	case v := <-changes:
+		c.Assert(v.Name, Equals, "two")
+	case <-time.After(delay):
+		c.Fatal("Should get change")
+	}
+
+	// No more updates once the context is done
+	cancel()
+	time.Sleep(delay)
+	_, err = reg.Put(p, []byte(`{"name":"three"}`), false)
+	c.Assert(err, IsNil)
+	time.Sleep(delay)
+	c.Assert(len(changes), Equals, 0)
+	c.Assert(binding.Value().(*settings).Name, Equals, "two")
+}
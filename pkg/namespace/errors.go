@@ -1,7 +1,23 @@
 package namespace
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Errors returned by backend implementations that do not have their own native error values.
+var (
+	ErrNotExist                = errors.New("error-not-exist")
+	ErrNodeExists              = errors.New("error-node-exists")
+	ErrBadVersion              = errors.New("error-bad-version")
+	ErrNotEmpty                = errors.New("error-not-empty")
+	ErrNoChildrenForEphemerals = errors.New("error-no-children-for-ephemerals")
+	ErrClosed                  = errors.New("error-registry-closed")
+	ErrReadOnly                = errors.New("error-registry-read-only")
+	ErrBadPattern              = errors.New("error-bad-pattern")
+	ErrBadTrigger              = errors.New("error-bad-trigger")
 )
 
 type NotSupportedProtocol struct {
@@ -11,3 +27,52 @@ type NotSupportedProtocol struct {
 func (this *NotSupportedProtocol) Error() string {
 	return fmt.Sprintf("not-supported: %s", this.Protocol)
 }
+
+// Returned when restoring a snapshot over a node that has changed since the snapshot was taken.
+type VersionMismatch struct {
+	Path     Path
+	Expected Version
+	Actual   Version
+}
+
+func (this *VersionMismatch) Error() string {
+	return fmt.Sprintf("version-mismatch: %s expected=%d actual=%d", this.Path, this.Expected, this.Actual)
+}
+
+// Returned when following links comes back to a node already visited.  The chain ends with the node
+// that closes the cycle.
+type LinkCycle struct {
+	Chain []url.URL
+}
+
+func (this *LinkCycle) Error() string {
+	return fmt.Sprintf("link-cycle: %s", chainString(this.Chain))
+}
+
+// Returned when following links takes more hops than allowed.
+type TooManyHops struct {
+	Max   int
+	Chain []url.URL
+}
+
+func (this *TooManyHops) Error() string {
+	return fmt.Sprintf("too-many-hops: max=%d %s", this.Max, chainString(this.Chain))
+}
+
+func chainString(chain []url.URL) string {
+	s := make([]string, len(chain))
+	for i, u := range chain {
+		s[i] = u.String()
+	}
+	return strings.Join(s, " -> ")
+}
+
+// Returned by Dial when a query parameter of the url has a bad value.
+type BadOption struct {
+	Name  string
+	Value string
+}
+
+func (this *BadOption) Error() string {
+	return fmt.Sprintf("bad-option: %s=%s", this.Name, this.Value)
+}
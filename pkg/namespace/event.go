@@ -0,0 +1,81 @@
+package namespace
+
+import (
+	"encoding/json"
+)
+
+type EventKind int
+
+const (
+	EventCreate  EventKind = iota + 1 // the node was created
+	EventChange                       // the value of the node changed
+	EventDelete                       // the node was deleted
+	EventMembers                      // the members changed and matched the criteria; see Members
+	EventSession                      // the state of the session changed; see State
+	EventError                        // the trigger failed; see Err.  Always the last event.
+)
+
+var (
+	event_kinds = map[EventKind]string{
+		EventCreate:  "create",
+		EventChange:  "change",
+		EventDelete:  "delete",
+		EventMembers: "members",
+		EventSession: "session",
+		EventError:   "error",
+	}
+)
+
+// Event delivered on the channel returned by Registry.Trigger.
+//
+// Delivery guarantees, for all the backends:
+//   - The events of a trigger are delivered in the order they happened.
+//   - Changes close together may be coalesced into one event by backends that poll (file) or re-arm
+//     watches (zk, consul), so consumers should read the current state rather than count events.
+//   - Version and Value are those right after the change, when the backend reports them (mem, file,
+//     consul).  Otherwise they are InvalidVersion and nil.
+//   - Session changes (zk) are delivered as EventSession and do not close the channel.
+//   - The channel is closed when the context is done or the registry is closed.  If the trigger
+//     fails, an EventError is delivered before the channel is closed.  Failures that may go away,
+//     e.g. a lost connection, are retried instead (consul) or reported as EventSession (zk).  What
+//     fails a trigger: a node that cannot be read (file), a query refused by the server (consul), an
+//     error setting the watch again (zk), or an error from the gateway (nsgw).
+type Event struct {
+	Kind    EventKind
+	Path    string // the node of the trigger
+	Version Version
+	Value   []byte
+	Members *MembersChange // for EventMembers
+	State   string         // for EventSession, e.g. disconnected, expired
+	Err     error          // for EventError
+}
+
+func (this EventKind) String() string {
+	return event_kinds[this]
+}
+
+func (this Event) AsMap() map[string]interface{} {
+	m := map[string]interface{}{
+		"kind":    this.Kind.String(),
+		"path":    this.Path,
+		"version": this.Version,
+	}
+	if this.Value != nil {
+		m["value"] = string(this.Value)
+	}
+	if this.Members != nil {
+		m["members"] = this.Members
+	}
+	if this.State != "" {
+		m["state"] = this.State
+	}
+	if this.Err != nil {
+		m["error"] = this.Err.Error()
+	}
+	return m
+}
+
+func (this Event) JSON() string {
+	buff, _ := json.Marshal(this.AsMap())
+	return string(buff)
+}
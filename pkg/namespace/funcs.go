@@ -13,6 +13,8 @@ func init() {
 	template.RegisterFunc("exists", ExistsTemplateFunc)
 	template.RegisterFunc("get", GetTemplateFunc)
 	template.RegisterFunc("list", ListTemplateFunc)
+	template.RegisterFunc("glob", GlobTemplateFunc)
+	template.RegisterFunc("stat", StatTemplateFunc)
 }
 
 func registryAndPath(ctx context.Context, url string) (Registry, Path, error) {
@@ -34,22 +36,43 @@ func ListTemplateFunc(ctx context.Context) interface{} {
 		if err != nil {
 			return nil, err
 		}
-		// To make this compatible we need to include the registry id url as prefix
 		list, err := reg.List(path)
 		if err != nil {
 			return nil, err
 		}
-		out := make([]*net.URL, len(list))
-		for i, v := range list {
-			fullUrl := new(net.URL)
-			*fullUrl = reg.Id()
-			fullUrl.Path = v.String()
-			out[i] = fullUrl
+		return toUrls(reg, list), nil
+	}
+}
+
+// Template function that returns the urls of the nodes matching the pattern in the path of the url,
+// e.g. {{ range glob "zk://host/services/*/instances/*" }}.  See MatchGlob for the syntax.  A ? in the
+// pattern must be escaped as %3F, or it starts the query of the url.
+func GlobTemplateFunc(ctx context.Context) interface{} {
+	return func(url string) ([]*net.URL, error) {
+		reg, path, err := registryAndPath(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		list, err := Glob(reg, path.String())
+		if err != nil {
+			return nil, err
 		}
-		return out, nil
+		return toUrls(reg, list), nil
 	}
 }
 
+// To make this compatible we need to include the registry id url as prefix
+func toUrls(reg Registry, list []Path) []*net.URL {
+	out := make([]*net.URL, len(list))
+	for i, v := range list {
+		fullUrl := new(net.URL)
+		*fullUrl = reg.Id()
+		fullUrl.Path = v.String()
+		out[i] = fullUrl
+	}
+	return out
+}
+
 // Template function that returns a True or False that a path exists
 func ExistsTemplateFunc(ctx context.Context) interface{} {
 	return func(url string) (bool, error) {
@@ -82,3 +105,14 @@ func GetTemplateFunc(ctx context.Context) interface{} {
 		return string(buff), nil
 	}
 }
+
+// Template function that returns the metadata of the node at the url, e.g. {{ (stat "zk://host/a").Modified }}
+func StatTemplateFunc(ctx context.Context) interface{} {
+	return func(url string) (*Stat, error) {
+		reg, path, err := registryAndPath(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+		return reg.Stat(path)
+	}
+}
@@ -0,0 +1,216 @@
+package namespace
+
+import (
+	p "path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Number of nodes listed in parallel by Glob.
+	globConcurrency = 8
+)
+
+// Optional interface for registries that can match a glob pattern themselves, e.g. with one query of
+// the backend, instead of Glob listing the nodes level by level.
+type Globber interface {
+	Glob(pattern string) ([]Path, error)
+}
+
+// Splits the pattern in its segments and checks them.
+func globSegments(pattern string) ([]string, error) {
+	segments := []string{}
+	for _, s := range strings.Split(NewPath(pattern).String(), "/") {
+		if s == "" {
+			continue
+		}
+		if s != "**" {
+			if _, err := p.Match(s, ""); err != nil {
+				return nil, ErrBadPattern
+			}
+		}
+		segments = append(segments, s)
+	}
+	return segments, nil
+}
+
+func hasMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// Whether the path matches the glob pattern.  In the pattern, ** matches any number of segments,
+// including none, and the other segments are matched against one segment of the path as in
+// path.Match, e.g. /services/*/instances/** matches /services/web/instances and all its descendants.
+// Returns false if the pattern is malformed.
+func MatchGlob(pattern string, path Path) bool {
+	segments, err := globSegments(pattern)
+	if err != nil {
+		return false
+	}
+	parts := []string{}
+	for _, s := range strings.Split(path.String(), "/") {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return matchSegments(segments, parts)
+}
+
+func matchSegments(segments, parts []string) bool {
+	for i, s := range segments {
+		if s == "**" {
+			for j := i; j <= len(parts); j++ {
+				if matchSegments(segments[i+1:], parts[j:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if i >= len(parts) {
+			return false
+		}
+		if matched, _ := p.Match(s, parts[i]); !matched {
+			return false
+		}
+	}
+	return len(segments) == len(parts)
+}
+
+// The part of the pattern before the first segment with wildcards, e.g. /services for
+// /services/*/instances.  Backends that push the glob down list the nodes under it.
+func GlobPrefix(pattern string) Path {
+	prefix := NewPath("/")
+	segments, _ := globSegments(pattern)
+	for _, s := range segments {
+		if hasMeta(s) || s == "**" {
+			break
+		}
+		prefix = prefix.Sub(s)
+	}
+	return prefix
+}
+
+// A node reached while matching, and the index of the next segment of the pattern to match.
+type globState struct {
+	path  Path
+	next  int
+	known bool // the node is known to exist, i.e. it was listed
+}
+
+// Returns the paths of the nodes that match the pattern, sorted.  See MatchGlob for the syntax.  If
+// the registry implements Globber, the match is left to it.  Otherwise the nodes are listed level by
+// level, in parallel, and only the segments with wildcards are listed.
+func Glob(reg Registry, pattern string) ([]Path, error) {
+	if globber, is := reg.(Globber); is {
+		return globber.Glob(pattern)
+	}
+	return globList(reg, pattern)
+}
+
+// Matches the pattern by listing the nodes.
+func globList(reg Registry, pattern string) ([]Path, error) {
+	segments, err := globSegments(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock sync.Mutex
+	seen := map[string]bool{}
+	matched := map[string]bool{}
+	next := []globState{}
+	var add func(globState)
+	add = func(s globState) {
+		key := s.path.String() + "#" + strconv.Itoa(s.next)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		next = append(next, s)
+		if s.next < len(segments) && segments[s.next] == "**" {
+			add(globState{path: s.path, next: s.next + 1, known: s.known})
+		}
+	}
+	add(globState{path: NewPath("/"), next: 0})
+
+	for len(next) > 0 {
+		level := next
+		next = []globState{}
+		err := parallelIndex(len(level), globConcurrency, func(i int) error {
+			s := level[i]
+			if s.next == len(segments) {
+				exists := s.known
+				if !exists {
+					var err error
+					if exists, err = reg.Exists(s.path); err != nil {
+						return err
+					}
+				}
+				if exists {
+					lock.Lock()
+					matched[s.path.String()] = true
+					lock.Unlock()
+				}
+				return nil
+			}
+			segment := segments[s.next]
+			if !hasMeta(segment) && segment != "**" {
+				lock.Lock()
+				add(globState{path: s.path.Sub(segment), next: s.next + 1})
+				lock.Unlock()
+				return nil
+			}
+			children, err := reg.List(s.path)
+			switch err {
+			case nil:
+			case ErrNotExist:
+				return nil
+			default:
+				return err
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			for _, child := range children {
+				if segment == "**" {
+					add(globState{path: child, next: s.next, known: true})
+				} else if ok, _ := p.Match(segment, child.Base()); ok {
+					add(globState{path: child, next: s.next + 1, known: true})
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := []Path{}
+	for m, _ := range matched {
+		result = append(result, NewPath(m))
+	}
+	sort.Sort(byPath(result))
+	return result, nil
+}
+
+// Matches the pattern against the paths, e.g. all the nodes under GlobPrefix(pattern), for the
+// backends that implement Globber.  Returns the matching paths, sorted.
+func FilterGlob(pattern string, paths []Path) ([]Path, error) {
+	if _, err := globSegments(pattern); err != nil {
+		return nil, err
+	}
+	matched := []Path{}
+	for _, path := range paths {
+		if MatchGlob(pattern, path) {
+			matched = append(matched, path)
+		}
+	}
+	sort.Sort(byPath(matched))
+	return matched, nil
+}
+
+type byPath []Path
+
+func (this byPath) Len() int           { return len(this) }
+func (this byPath) Less(i, j int) bool { return this[i].String() < this[j].String() }
+func (this byPath) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
@@ -0,0 +1,78 @@
+package namespace_test
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/template"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+// Hides the Glob of the registry, so that namespace.Glob lists the nodes instead.
+type listOnly struct {
+	namespace.Registry
+}
+
+func (suite *TestSuiteRegistry) TestGlob(c *C) {
+	ctx := context.Background()
+	url := memUrl("glob")
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	for _, p := range []string{
+		"/services/web/instances/1/port", "/services/web/instances/2/port", "/services/web/config",
+		"/services/db/instances/1/port", "/services/dbx", "/other/port",
+	} {
+		_, err := reg.Put(namespace.NewPath(p), []byte(p), false)
+		c.Assert(err, IsNil)
+	}
+	paths := func(list []namespace.Path) []string {
+		out := []string{}
+		for _, p := range list {
+			out = append(out, p.String())
+		}
+		return out
+	}
+	for _, t := range []struct {
+		pattern string
+		matched []string
+	}{
+		{"/services/*/instances/*", []string{
+			"/services/db/instances/1", "/services/web/instances/1", "/services/web/instances/2"}},
+		{"/services/web/instances/**", []string{"/services/web/instances", "/services/web/instances/1",
+			"/services/web/instances/1/port", "/services/web/instances/2", "/services/web/instances/2/port"}},
+		{"/**/port", []string{"/other/port", "/services/db/instances/1/port",
+			"/services/web/instances/1/port", "/services/web/instances/2/port"}},
+		{"/services/db?", []string{"/services/dbx"}},
+		{"/services/web/config", []string{"/services/web/config"}},
+		{"/services/none/*", []string{}},
+	} {
+		pushed, err := namespace.Glob(reg, t.pattern)
+		c.Assert(err, IsNil)
+		c.Assert(paths(pushed), DeepEquals, t.matched, Commentf("%s", t.pattern))
+		listed, err := namespace.Glob(listOnly{reg}, t.pattern)
+		c.Assert(err, IsNil)
+		c.Assert(paths(listed), DeepEquals, t.matched, Commentf("%s", t.pattern))
+	}
+	_, err = namespace.Glob(reg, "/services/[")
+	c.Assert(err, Equals, namespace.ErrBadPattern)
+	_, err = namespace.Glob(listOnly{reg}, "/services/[")
+	c.Assert(err, Equals, namespace.ErrBadPattern)
+
+	// Relative to the chroot.
+	chroot, err := namespace.Dial(ctx, url+"?chroot=/services")
+	c.Assert(err, IsNil)
+	defer chroot.Close()
+	matched, err := namespace.Glob(chroot, "/*/instances/1")
+	c.Assert(err, IsNil)
+	c.Assert(paths(matched), DeepEquals, []string{"/db/instances/1", "/web/instances/1"})
+
+	// Instead of nested range list.
+	_, err = reg.Put(namespace.NewPath("/tmpl"), []byte(
+		`{{range glob "`+url+`/services/*/instances/*/port"}}{{get .String}} {{end}}`), false)
+	c.Assert(err, IsNil)
+	applied, err := template.Execute(ctx, url+"/tmpl")
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "/services/db/instances/1/port /services/web/instances/1/port "+
+		"/services/web/instances/2/port ")
+}
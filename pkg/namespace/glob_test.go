@@ -0,0 +1,61 @@
+package namespace
+
+import (
+	. "gopkg.in/check.v1"
+	"testing"
+)
+
+func TestGlob(t *testing.T) { TestingT(t) }
+
+type TestSuiteGlob struct {
+}
+
+var _ = Suite(&TestSuiteGlob{})
+
+func (suite *TestSuiteGlob) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteGlob) TearDownSuite(c *C) {
+}
+
+func (suite *TestSuiteGlob) TestMatchGlob(c *C) {
+	for _, t := range []struct {
+		pattern string
+		path    string
+		match   bool
+	}{
+		{"/services/*", "/services/web", true},
+		{"/services/*", "/services", false},
+		{"/services/*", "/services/web/instances", false},
+		{"/services/w?b", "/services/web", true},
+		{"/services/[a-m]*", "/services/web", false},
+		{"/services/*/instances/**", "/services/web/instances", true},
+		{"/services/*/instances/**", "/services/web/instances/1/port", true},
+		{"/services/*/instances/**", "/services/web/config", false},
+		{"/**/port", "/port", true},
+		{"/**/port", "/services/web/instances/1/port", true},
+		{"/**/port", "/services/web/ports", false},
+		{"/**", "/", true},
+		{"/", "/", true},
+		{"services/web", "/services/web", true},
+	} {
+		c.Assert(MatchGlob(t.pattern, NewPath(t.path)), Equals, t.match, Commentf("%s %s", t.pattern, t.path))
+	}
+	c.Assert(MatchGlob("/services/[", NewPath("/services/[")), Equals, false)
+}
+
+func (suite *TestSuiteGlob) TestGlobPrefix(c *C) {
+	c.Assert(GlobPrefix("/services/*/instances"), Equals, NewPath("/services"))
+	c.Assert(GlobPrefix("/services/web/**"), Equals, NewPath("/services/web"))
+	c.Assert(GlobPrefix("/services/web"), Equals, NewPath("/services/web"))
+	c.Assert(GlobPrefix("/*"), Equals, NewPath("/"))
+}
+
+func (suite *TestSuiteGlob) TestFilterGlob(c *C) {
+	matched, err := FilterGlob("/a/*", []Path{NewPath("/a/c"), NewPath("/a"), NewPath("/a/b"), NewPath("/a/b/c")})
+	c.Assert(err, IsNil)
+	c.Assert(matched, DeepEquals, []Path{NewPath("/a/b"), NewPath("/a/c")})
+
+	_, err = FilterGlob("/a/[", nil)
+	c.Assert(err, Equals, ErrBadPattern)
+}
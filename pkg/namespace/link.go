@@ -0,0 +1,137 @@
+package namespace
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	net "net/url"
+	"strings"
+)
+
+const (
+	// Marks the value of a node as a link.  The rest of the value is the target.
+	LinkPrefix = "link:"
+
+	DefaultMaxHops = 8
+)
+
+var ErrBadLinkTarget = errors.New("error-bad-link-target")
+
+// Makes the node at src a link to dst.  The target can be a full url, e.g. zk://host:2181/some/path,
+// which may be in a different registry; an absolute path in the same registry; or a path relative to
+// the directory of src, e.g. ../other.
+func Link(reg Registry, src Path, dst string) (Version, error) {
+	if dst == "" {
+		return InvalidVersion, ErrBadLinkTarget
+	}
+	if strings.Contains(dst, "://") {
+		if _, err := net.Parse(dst); err != nil {
+			return InvalidVersion, ErrBadLinkTarget
+		}
+	}
+	return reg.Put(src, []byte(LinkPrefix+dst), false)
+}
+
+// Returns the target if the value is a link.
+func LinkTarget(value []byte) (string, bool) {
+	s := string(value)
+	if !strings.HasPrefix(s, LinkPrefix) {
+		return "", false
+	}
+	return s[len(LinkPrefix):], true
+}
+
+type FollowOptions struct {
+	// Maximum number of links to follow.  Defaults to DefaultMaxHops.
+	MaxHops int
+
+	// Follow only the values with LinkPrefix.  By default, values that are just urls, as written before
+	// links, are followed too.
+	LinksOnly bool
+}
+
+// The end of a chain of links.
+type Resolution struct {
+	Url     net.URL
+	Value   []byte
+	Version Version
+
+	// All the nodes visited, starting with the url resolved and ending with Url.
+	Chain []net.URL
+}
+
+// Follows the links starting at the url until a node that's not a link.  A link back to a node already
+// visited returns a *LinkCycle error and more than MaxHops links a *TooManyHops error.  The resolution
+// so far is returned along with any error so the chain can be inspected.
+func Resolve(ctx context.Context, url net.URL, opts FollowOptions) (*Resolution, error) {
+	max := opts.MaxHops
+	if max <= 0 {
+		max = DefaultMaxHops
+	}
+	registries := map[string]Registry{}
+	defer func() {
+		for _, reg := range registries {
+			reg.Close()
+		}
+	}()
+
+	r := &Resolution{Url: url, Version: InvalidVersion, Chain: []net.URL{}}
+	visited := map[string]bool{}
+	for hops := 0; ; hops++ {
+		key := r.Url.Scheme + "://" + r.Url.Host + "?" + r.Url.RawQuery
+		reg, has := registries[key]
+		if !has {
+			dialed, err := Dial(ctx, r.Url.String())
+			if err != nil {
+				return r, err
+			}
+			registries[key] = dialed
+			reg = dialed
+		}
+		here := reg.Id()
+		here.Path = NewPath(r.Url.Path).String()
+		r.Url = here
+		r.Chain = append(r.Chain, here)
+		if visited[here.String()] {
+			return r, &LinkCycle{Chain: r.Chain}
+		}
+		visited[here.String()] = true
+
+		value, version, err := reg.Get(NewPath(here.Path))
+		if err != nil {
+			return r, err
+		}
+		target, is := LinkTarget(value)
+		if !is && !opts.LinksOnly && strings.Contains(string(value), "://") {
+			target, is = string(value), true
+		}
+		if !is {
+			r.Value, r.Version = value, version
+			return r, nil
+		}
+		if hops == max {
+			return r, &TooManyHops{Max: max, Chain: r.Chain}
+		}
+		next, err := linkUrl(here, target)
+		if err != nil {
+			return r, err
+		}
+		r.Url = next
+	}
+}
+
+func linkUrl(from net.URL, target string) (net.URL, error) {
+	if strings.Contains(target, "://") {
+		u, err := net.Parse(target)
+		if err != nil {
+			return from, ErrBadLinkTarget
+		}
+		return *u, nil
+	}
+	next := from
+	if strings.HasPrefix(target, "/") {
+		next.Path = NewPath(target).String()
+	} else {
+		next.Path = NewPath(NewPath(from.Path).Dir().String(), target).String()
+	}
+	return next, nil
+}
@@ -0,0 +1,63 @@
+package namespace_test
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+)
+
+func (suite *TestSuiteRegistry) TestLink(c *C) {
+	ctx := context.Background()
+	url := memUrl("link")
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/link")
+	resolve := func(path namespace.Path, opts namespace.FollowOptions) (*namespace.Resolution, error) {
+		u, err := net.Parse(url + path.String())
+		c.Assert(err, IsNil)
+		return namespace.Resolve(ctx, *u, opts)
+	}
+
+	// Relative and absolute targets
+	_, err = namespace.Link(reg, p.Sub("a/1"), "../b/2")
+	c.Assert(err, IsNil)
+	_, err = namespace.Link(reg, p.Sub("b/2"), p.Sub("c").String())
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("c"), []byte("mem://link/not/a/link"), false)
+	c.Assert(err, IsNil)
+	_, err = namespace.Link(reg, p.Sub("bad"), "")
+	c.Assert(err, Equals, namespace.ErrBadLinkTarget)
+
+	r, err := resolve(p.Sub("a/1"), namespace.FollowOptions{LinksOnly: true})
+	c.Assert(err, IsNil)
+	c.Assert(string(r.Value), Equals, "mem://link/not/a/link")
+	c.Assert(len(r.Chain), Equals, 3)
+	c.Assert(r.Chain[1].Path, Equals, p.Sub("b/2").String())
+	c.Assert(r.Url.String(), Equals, url+p.Sub("c").String())
+
+	// Plain urls are followed too, unless asked not to
+	_, err = resolve(p.Sub("a/1"), namespace.FollowOptions{})
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	// Hop limit
+	_, err = resolve(p.Sub("a/1"), namespace.FollowOptions{MaxHops: 1})
+	hops, is := err.(*namespace.TooManyHops)
+	c.Assert(is, Equals, true)
+	c.Assert(hops.Max, Equals, 1)
+	c.Assert(len(hops.Chain), Equals, 2)
+
+	// Cycle
+	_, err = namespace.Link(reg, p.Sub("x"), "y")
+	c.Assert(err, IsNil)
+	_, err = namespace.Link(reg, p.Sub("y"), url+p.Sub("x").String())
+	c.Assert(err, IsNil)
+	r, err = resolve(p.Sub("x"), namespace.FollowOptions{})
+	cycle, is := err.(*namespace.LinkCycle)
+	c.Assert(is, Equals, true)
+	c.Log(cycle)
+	c.Assert(len(cycle.Chain), Equals, 3)
+	c.Assert(r.Chain, DeepEquals, cycle.Chain)
+}
@@ -1,11 +1,11 @@
 package namespace
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"github.com/conductant/gohm/pkg/store"
 	"golang.org/x/net/context"
 	net "net/url"
-	"strings"
 	"sync"
 )
 
@@ -17,12 +17,20 @@ var (
 	lock       sync.Mutex
 	protocols  = map[scheme]Implementation{}
 	sanitizers = map[scheme]UrlSanitizer{}
+	decorators = []Decorator{}
+
+	// Store keys of the registries allocated by Dial.  The id of a registry has no password, so the key
+	// can't be told from the registry alone.
+	keysLock     sync.Mutex
+	registryKeys = map[Registry]store.Key{}
 )
 
 // Get an instance of the registry.  The url can specify host(s) such as
 // zk://host1:2181,host2:2181,host3:2181/other/parts/of/path
 // The protocol / scheme portion is used to dispatch to different registry implementations (e.g. zk: for zookeeper
-// etcd: for ectd, etc.)
+// etcd: for ectd, etc.)  Options can be given as query parameters, e.g. ?timeout=5s&chroot=/prod&readonly=true.
+// See Options.  Registries are shared by all the urls with the same protocol and hosts, so the options
+// used to create the session (e.g. timeout) are the ones of the first url dialed.
 func Dial(ctx context.Context, url string) (Registry, error) {
 	u, err := net.Parse(url)
 	if err != nil {
@@ -38,22 +46,39 @@ func Dial(ctx context.Context, url string) (Registry, error) {
 	if !has {
 		return nil, &NotSupportedProtocol{u.Scheme}
 	}
-	reg, err := regStore.Get(keyFromUrl(u), func(d store.Dispose) (store.Key, store.Object, error) {
-		obj, err := impl(ctx, *u, d)
+	opts, err := ParseOptions(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	ctx = ContextPutOptions(ctx, opts)
+	key := keyFromUrl(u)
+	reg, err := regStore.Get(key, func(d store.Dispose) (store.Key, store.Object, error) {
+		dispose := &keyedDispose{Dispose: d}
+		obj, err := impl(ctx, *u, dispose)
 		if err != nil {
 			return nil, nil, err
 		}
-		key := keyFromRegistry(obj)
+		dispose.reg = obj
+		keysLock.Lock()
+		registryKeys[obj] = key
+		keysLock.Unlock()
 		return key, store.Object(obj), nil
 	})
 	if reg != nil {
-		return reg.(Registry), err
+		return newView(decorate(*u, reg.(Registry)), opts, u.RawQuery), err
 	} else {
 		return nil, err
 	}
 }
 
+// The user, if any, and a hash of the whole userinfo are part of the key so that sessions with
+// different credentials are not shared, e.g. dialing with a wrong password does not get the session
+// authenticated with the right one.
 func keyFromUrl(u *net.URL) store.Key {
+	if u.User != nil {
+		sum := sha256.Sum256([]byte(u.User.String()))
+		return store.Key(fmt.Sprintf("%s://%s:%x@%s", u.Scheme, u.User.Username(), sum[:8], u.Host))
+	}
 	return store.Key(fmt.Sprintf("%s://%s", u.Scheme, u.Host))
 }
 
@@ -61,6 +86,12 @@ func keyFromUrl(u *net.URL) store.Key {
 func keyFromRegistry(obj store.Object) store.Key {
 	switch obj := obj.(type) {
 	case Registry:
+		keysLock.Lock()
+		key, has := registryKeys[obj]
+		keysLock.Unlock()
+		if has {
+			return key
+		}
 		u := obj.Id()
 		return keyFromUrl(&u)
 	default:
@@ -68,48 +99,33 @@ func keyFromRegistry(obj store.Object) store.Key {
 	}
 }
 
-// Given the fully specified url that includes protocol and host and path,
-// traverses symlinks where the value of a node is a pointer url to another registry node
-// It's possible that the pointer points to a different registry.
-// The returned url includes protocol and host information
-func FollowUrl(ctx context.Context, url net.URL) (net.URL, []byte, Version, error) {
-	reg, err := Dial(ctx, url.String())
-	if reg != nil {
-		defer reg.Close()
-	}
-	if err != nil {
-		return url, nil, InvalidVersion, err
-	}
-	return follow(ctx, reg, NewPath(url.Path))
+// Forgets the store key of the registry once the store accepts to dispose of it.
+type keyedDispose struct {
+	store.Dispose
+	reg Registry
 }
 
-// Traverses symlinks where the value of a node is a pointer url to another registry node
-// It's possible that the pointer points to a different registry.
-func follow(ctx context.Context, reg Registry, path Path) (net.URL, []byte, Version, error) {
-	here := reg.Id()
-	here.Path = path.String()
-	if len(path.String()) == 0 {
-		return here, nil, InvalidVersion, nil
-	}
-	v, ver, err := reg.Get(path)
-	if err != nil {
-		return here, nil, InvalidVersion, err
-	}
-	s := string(v)
-	if strings.Contains(s, "://") {
-		url, err := net.Parse(s)
-		if err != nil {
-			return here, nil, InvalidVersion, err
-		}
-		next, err := Dial(ctx, s)
-		if next != nil {
-			defer next.Close()
-		}
-		if err != nil {
-			return here, nil, InvalidVersion, err
+func (this *keyedDispose) Accept() <-chan bool {
+	accept := make(chan bool, 1)
+	go func() {
+		ok := <-this.Dispose.Accept()
+		if ok {
+			keysLock.Lock()
+			delete(registryKeys, this.reg)
+			keysLock.Unlock()
 		}
-		return follow(ctx, next, NewPath(url.Path))
-	} else {
-		return here, v, ver, nil
+		accept <- ok
+	}()
+	return accept
+}
+
+// Given the fully specified url that includes protocol and host and path, follows the links created by
+// Link and the values that are just urls, which may point to a different registry, up to
+// DefaultMaxHops.  The returned url includes protocol and host information.  See Resolve for the options.
+func FollowUrl(ctx context.Context, url net.URL) (net.URL, []byte, Version, error) {
+	r, err := Resolve(ctx, url, FollowOptions{})
+	if r == nil {
+		return url, nil, InvalidVersion, err
 	}
+	return r.Url, r.Value, r.Version, err
 }
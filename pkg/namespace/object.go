@@ -0,0 +1,130 @@
+package namespace
+
+import (
+	"bytes"
+	"errors"
+	"github.com/conductant/gohm/pkg/encoding"
+	"reflect"
+)
+
+// Reads the value of the node into v, a pointer, decoding it from the content type, e.g.
+// encoding.ContentTypeJSON.  A node without a value leaves v as is.  Returns the version of the node.
+func GetObject(reg Registry, path Path, t encoding.ContentType, v interface{}) (Version, error) {
+	value, version, err := reg.Get(path)
+	if err != nil {
+		return InvalidVersion, err
+	}
+	if len(bytes.TrimSpace(value)) == 0 {
+		return version, nil
+	}
+	if err := encoding.Unmarshal(t, bytes.NewBuffer(value), v); err != nil {
+		return InvalidVersion, err
+	}
+	return version, nil
+}
+
+// Writes v as the value of the node, encoded in the content type.  Returns the new version.
+func PutObject(reg Registry, path Path, t encoding.ContentType, v interface{}) (Version, error) {
+	var buff bytes.Buffer
+	if err := encoding.Marshal(t, &buff, v); err != nil {
+		return InvalidVersion, err
+	}
+	return reg.Put(path, buff.Bytes(), false)
+}
+
+var (
+	// Returned by an UpdateFunc to leave the node as is.
+	SkipUpdate = errors.New("skip-update")
+)
+
+// Called by UpdateValue with the value of the node, and whether it exists, to return the new value.
+// Returning SkipUpdate writes nothing, and any other error stops the update.
+type UpdateFunc func(value []byte, exists bool) ([]byte, error)
+
+// Reads the node, calls the update function with its value, and writes the value returned with
+// PutVersion at the version it was read at, or creates the node if it did not exist.  When another
+// client changed, created or deleted the node in the meantime, it all starts again, so no update is
+// lost with any backend.
+// Returns the version written, or the version read if the function returned SkipUpdate.
+func UpdateValue(reg Registry, path Path, update UpdateFunc) (Version, error) {
+	for {
+		value, version, err := reg.Get(path)
+		exists := err == nil
+		switch err {
+		case nil:
+		case ErrNotExist:
+			value, version = nil, InvalidVersion
+		default:
+			return InvalidVersion, err
+		}
+		updated, err := update(value, exists)
+		switch err {
+		case nil:
+		case SkipUpdate:
+			return version, nil
+		default:
+			return InvalidVersion, err
+		}
+		written := InvalidVersion
+		if !exists {
+			var results []OpResult
+			results, err = reg.Txn(OpCreate{Path: path, Value: updated})
+			if txnErr, is := err.(*TxnError); is {
+				err = txnErr.Err
+			} else if err == nil {
+				written = results[0].Version
+			}
+		} else {
+			written, err = reg.PutVersion(path, updated, version)
+		}
+		switch err {
+		case nil:
+			return written, nil
+		case ErrBadVersion:
+			continue // Changed by someone else.
+		case ErrNodeExists, ErrNotExist:
+			// Created or deleted by someone else, unless the backend disagrees with what it returned
+			// from Get, in which case trying again would never end.
+			_, now, e := reg.Get(path)
+			switch {
+			case e == nil && (!exists || now != version):
+				continue
+			case e == ErrNotExist && exists:
+				continue
+			case e != nil && e != ErrNotExist:
+				return InvalidVersion, e
+			}
+			return InvalidVersion, err
+		default:
+			return InvalidVersion, err
+		}
+	}
+}
+
+// Reads the value of the node into v, a pointer, calls the update function with v, and writes v back
+// with UpdateValue.  v is reset to its zero value before each read, and a node that does not exist
+// is created from the zero value.  If the update function returns an error, nothing is written and
+// the error is returned.  Returns the version written.
+func UpdateObject(reg Registry, path Path, t encoding.ContentType, v interface{},
+	update func(interface{}) error) (Version, error) {
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() {
+		return InvalidVersion, encoding.ErrIncompatibleType
+	}
+	return UpdateValue(reg, path, func(value []byte, exists bool) ([]byte, error) {
+		ptr.Elem().Set(reflect.Zero(ptr.Elem().Type()))
+		if len(bytes.TrimSpace(value)) > 0 {
+			if err := encoding.Unmarshal(t, bytes.NewBuffer(value), v); err != nil {
+				return nil, err
+			}
+		}
+		if err := update(v); err != nil {
+			return nil, err
+		}
+		var buff bytes.Buffer
+		if err := encoding.Marshal(t, &buff, v); err != nil {
+			return nil, err
+		}
+		return buff.Bytes(), nil
+	})
+}
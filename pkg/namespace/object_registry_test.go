@@ -0,0 +1,143 @@
+package namespace_test
+
+import (
+	"fmt"
+	"github.com/conductant/gohm/pkg/encoding"
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+)
+
+type objectConfig struct {
+	Name     string            `json:"name" yaml:"name"`
+	Replicas int               `json:"replicas" yaml:"replicas"`
+	Labels   map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+func (suite *TestSuiteRegistry) TestObject(c *C) {
+	reg, _ := dialMem(c, "object")
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/object")
+	_, err := namespace.GetObject(reg, p, encoding.ContentTypeJSON, &objectConfig{})
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	for _, t := range []encoding.ContentType{encoding.ContentTypeJSON, encoding.ContentTypeYAML} {
+		written := objectConfig{Name: "web", Replicas: 2, Labels: map[string]string{"tier": "front"}}
+		version, err := namespace.PutObject(reg, p, t, written)
+		c.Assert(err, IsNil)
+		read := objectConfig{}
+		v, err := namespace.GetObject(reg, p, t, &read)
+		c.Assert(err, IsNil)
+		c.Assert(v, Equals, version)
+		c.Assert(read, DeepEquals, written)
+	}
+	value, _, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "name: web\nreplicas: 2\nlabels:\n  tier: front\n")
+
+	// Fields gone from the value are not kept from the previous read.
+	_, err = namespace.UpdateObject(reg, p, encoding.ContentTypeYAML, &objectConfig{}, func(v interface{}) error {
+		config := v.(*objectConfig)
+		config.Labels = nil
+		return nil
+	})
+	c.Assert(err, IsNil)
+	cfg := objectConfig{}
+	version, err := namespace.UpdateObject(reg, p, encoding.ContentTypeYAML, &cfg, func(v interface{}) error {
+		c.Assert(cfg.Labels, IsNil)
+		cfg.Replicas++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Replicas, Equals, 3)
+	_, current, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(current, Equals, version)
+
+	// Nothing is written if the update fails.
+	failure := fmt.Errorf("failed")
+	_, err = namespace.UpdateObject(reg, p, encoding.ContentTypeYAML, &cfg, func(v interface{}) error {
+		cfg.Replicas = 100
+		return failure
+	})
+	c.Assert(err, Equals, failure)
+	_, current, err = reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(current, Equals, version)
+
+	_, err = namespace.UpdateObject(reg, p, encoding.ContentTypeYAML, cfg, func(v interface{}) error { return nil })
+	c.Assert(err, Equals, encoding.ErrIncompatibleType)
+}
+
+func (suite *TestSuiteRegistry) TestUpdateValue(c *C) {
+	reg, _ := dialMem(c, "update-value")
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/update")
+	created, err := namespace.UpdateValue(reg, p, func(value []byte, exists bool) ([]byte, error) {
+		c.Assert(exists, Equals, false)
+		c.Assert(value, IsNil)
+		return []byte("1"), nil
+	})
+	c.Assert(err, IsNil)
+	version, err := namespace.UpdateValue(reg, p, func(value []byte, exists bool) ([]byte, error) {
+		c.Assert(exists, Equals, true)
+		return append(value, '2'), nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(version, Not(Equals), created)
+
+	// Skipping returns the version read and writes nothing.
+	skipped, err := namespace.UpdateValue(reg, p, func(value []byte, exists bool) ([]byte, error) {
+		return []byte("3"), namespace.SkipUpdate
+	})
+	c.Assert(err, IsNil)
+	c.Assert(skipped, Equals, version)
+	value, _, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "12")
+}
+
+func (suite *TestSuiteRegistry) TestUpdateObjectConcurrently(c *C) {
+	// Sessions of their own, which Dial would share.
+	url, err := net.Parse(memUrl("object-concurrent"))
+	c.Assert(err, IsNil)
+	p := namespace.NewPath("/unit-test/registry/object")
+	writers, updates := 5, 20
+	done := make(chan error)
+	for i := 0; i < writers; i++ {
+		go func() {
+			reg, err := mem.NewService(context.Background(), *url, nil)
+			if err != nil {
+				done <- err
+				return
+			}
+			defer reg.Close()
+			for j := 0; j < updates; j++ {
+				cfg := objectConfig{}
+				_, err := namespace.UpdateObject(reg, p, encoding.ContentTypeJSON, &cfg, func(v interface{}) error {
+					cfg.Replicas++
+					return nil
+				})
+				if err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	for i := 0; i < writers; i++ {
+		c.Assert(<-done, IsNil)
+	}
+	reg, err := mem.NewService(context.Background(), *url, nil)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+	cfg := objectConfig{}
+	_, err = namespace.GetObject(reg, p, encoding.ContentTypeJSON, &cfg)
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Replicas, Equals, writers*updates)
+}
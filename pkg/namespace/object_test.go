@@ -0,0 +1,48 @@
+package namespace
+
+import (
+	. "gopkg.in/check.v1"
+	"testing"
+)
+
+func TestObject(t *testing.T) { TestingT(t) }
+
+type TestSuiteObject struct {
+}
+
+var _ = Suite(&TestSuiteObject{})
+
+func (suite *TestSuiteObject) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteObject) TearDownSuite(c *C) {
+}
+
+// A backend that reads a node it cannot write, as a union does for the nodes of its lower layers.
+// Only Exists, Get and PutVersion are implemented.
+type unwritable struct {
+	Registry
+	puts int
+}
+
+func (this *unwritable) Exists(key Path) (bool, error) {
+	return true, nil
+}
+
+func (this *unwritable) Get(key Path) ([]byte, Version, error) {
+	return []byte("1"), 1, nil
+}
+
+func (this *unwritable) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	this.puts++
+	return InvalidVersion, ErrNotExist
+}
+
+func (suite *TestSuiteObject) TestUpdateValueDoesNotRetryForever(c *C) {
+	reg := &unwritable{}
+	_, err := UpdateValue(reg, NewPath("/node"), func(value []byte, exists bool) ([]byte, error) {
+		return append(value, '2'), nil
+	})
+	c.Assert(err, Equals, ErrNotExist)
+	c.Assert(reg.puts, Equals, 1)
+}
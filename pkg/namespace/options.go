@@ -0,0 +1,68 @@
+package namespace
+
+import (
+	"golang.org/x/net/context"
+	net "net/url"
+	"strconv"
+	"time"
+)
+
+// Options given as query parameters of the url passed to Dial, e.g.
+// zk://host:2181/?timeout=5s&chroot=/prod&readonly=true
+type Options struct {
+	// Session timeout, for backends that have one.  Overrides the backend specific context value.
+	Timeout time.Duration
+
+	// All paths are relative to this node.  Applied by Dial, so implementations see the full paths.
+	Chroot Path
+
+	// Writes fail with ErrReadOnly.  Applied by Dial.
+	ReadOnly bool
+
+	// All the query parameters, including the ones above, for backend specific options.
+	Params net.Values
+}
+
+type optionsContextKey int
+
+const (
+	OptionsContextKey optionsContextKey = 1
+)
+
+// Parses the generic options from the query parameters.  Unknown parameters are left for the backends.
+func ParseOptions(query net.Values) (Options, error) {
+	opts := Options{Params: query}
+	if v := query.Get("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return opts, &BadOption{Name: "timeout", Value: v}
+		}
+		opts.Timeout = d
+	}
+	if v := query.Get("chroot"); v != "" {
+		opts.Chroot = NewPath(v)
+		if opts.Chroot.String() == "/" {
+			opts.Chroot = nil
+		}
+	}
+	if v := query.Get("readonly"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, &BadOption{Name: "readonly", Value: v}
+		}
+		opts.ReadOnly = b
+	}
+	return opts, nil
+}
+
+// Options of the url being dialed, for use by implementations.
+func ContextGetOptions(ctx context.Context) Options {
+	if v, ok := (ctx.Value(OptionsContextKey)).(Options); ok {
+		return v
+	}
+	return Options{Params: net.Values{}}
+}
+
+func ContextPutOptions(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, OptionsContextKey, opts)
+}
@@ -0,0 +1,71 @@
+package namespace_test
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/template"
+	"github.com/conductant/gohm/pkg/testutil/nstest"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *TestSuiteRegistry) TestDialOptions(c *C) {
+	ctx := context.Background()
+	url := memUrl("options")
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	_, err = namespace.Dial(ctx, url+"/?timeout=soon")
+	c.Assert(err, DeepEquals, &namespace.BadOption{Name: "timeout", Value: "soon"})
+
+	prod, err := namespace.Dial(ctx, url+"/?timeout=5s&chroot=/prod")
+	c.Assert(err, IsNil)
+	defer prod.Close()
+	id := prod.Id()
+	c.Assert(id.String(), Equals, url+"?timeout=5s&chroot=/prod")
+
+	watch, stop := context.WithCancel(context.Background())
+	members, err := prod.Trigger(watch, namespace.Members{Path: namespace.NewPath("/services")})
+	c.Assert(err, IsNil)
+	defer stop()
+
+	_, err = prod.Put(namespace.NewPath("/services/web"), []byte("web"), false)
+	c.Assert(err, IsNil)
+	value, _, err := reg.Get(namespace.NewPath("/prod/services/web"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("web"))
+
+	list, err := prod.List(namespace.NewPath("/services"))
+	c.Assert(err, IsNil)
+	c.Assert(list, DeepEquals, []namespace.Path{namespace.NewPath("/services/web")})
+	changes := nstest.CollectMembers(members, 1, delay)
+	c.Assert(changes[0].Path, Equals, "/services")
+	c.Assert(changes[0].After, DeepEquals, []string{"/services/web"})
+
+	results, err := prod.Txn(namespace.OpSet{Path: namespace.NewPath("/services/web"), Value: []byte("web2"),
+		Version: namespace.InvalidVersion})
+	c.Assert(err, IsNil)
+	c.Assert(results[0].Path, Equals, namespace.NewPath("/services/web"))
+
+	// Read only, on top of the chroot
+	ro, err := namespace.Dial(ctx, url+"/services?readonly=true&chroot=/prod")
+	c.Assert(err, IsNil)
+	defer ro.Close()
+	value, _, err = ro.Get(namespace.NewPath("/services/web"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("web2"))
+	_, err = ro.Put(namespace.NewPath("/services/web"), []byte("web3"), false)
+	c.Assert(err, Equals, namespace.ErrReadOnly)
+	c.Assert(ro.Delete(namespace.NewPath("/services/web")), Equals, namespace.ErrReadOnly)
+	_, err = ro.Txn(namespace.OpCheck{Path: namespace.NewPath("/services/web"), Version: namespace.InvalidVersion},
+		namespace.OpDelete{Path: namespace.NewPath("/services/web"), Version: namespace.InvalidVersion})
+	c.Assert(err, DeepEquals, &namespace.TxnError{Index: 1, Err: namespace.ErrReadOnly})
+
+	// The template functions use the options too
+	_, err = reg.Put(namespace.NewPath("/tmpl"), []byte(`{{get "`+url+`/services/web?chroot=/prod"}}`+
+		`{{range list "`+url+`/services?chroot=/prod"}} {{.}}{{end}}`), false)
+	c.Assert(err, IsNil)
+	applied, err := template.Execute(ctx, url+"/tmpl")
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "web2 "+url+"/services/web?chroot=/prod")
+}
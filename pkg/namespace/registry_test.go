@@ -0,0 +1,45 @@
+package namespace_test
+
+import (
+	"fmt"
+	_ "github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The features built on top of Registry are tested against the mem backend, in the suite below.
+// The suites of the package run once for each TestingT, so each test dials registries of its own,
+// see memUrl.
+
+var delay = 200 * time.Millisecond
+
+var memHosts int64
+
+func TestRegistry(t *testing.T) { TestingT(t) }
+
+type TestSuiteRegistry struct{}
+
+var _ = Suite(&TestSuiteRegistry{})
+
+func (suite *TestSuiteRegistry) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteRegistry) TearDownSuite(c *C) {
+}
+
+// Returns the url of a mem registry with a tree no other test uses.
+func memUrl(name string) string {
+	return fmt.Sprintf("mem://%s-%d", name, atomic.AddInt64(&memHosts, 1))
+}
+
+// Dials a mem registry with a tree of its own and returns it with its url.
+func dialMem(c *C, name string) (namespace.Registry, string) {
+	url := memUrl(name)
+	reg, err := namespace.Dial(context.Background(), url)
+	c.Assert(err, IsNil)
+	return reg, url
+}
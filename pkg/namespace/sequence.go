@@ -0,0 +1,108 @@
+package namespace
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Sequence numbers of sequential nodes are zero padded to this many digits, as in zk, so that the
+// names sort in sequence order.
+const SequenceDigits = 10
+
+// Returns the path with the sequence number appended to the name of the node.
+func SequentialPath(p Path, seq int64) Path {
+	return NewPath(fmt.Sprintf("%s%0*d", p.String(), SequenceDigits, seq))
+}
+
+// Returns the sequence number at the end of the name of the node, if any.
+func SequenceOf(p Path) (int64, bool) {
+	name := p.Base()
+	if len(name) < SequenceDigits {
+		return 0, false
+	}
+	digits := name[len(name)-SequenceDigits:]
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	seq, err := strconv.ParseInt(digits, 10, 64)
+	return seq, err == nil
+}
+
+// Creates a sequential node named with the path followed by the sequence number, and returns the path
+// of the node created.
+func CreateSequential(reg Registry, p Path, value []byte, ephemeral bool) (Path, error) {
+	results, err := reg.Txn(OpCreate{Path: p, Value: value, Ephemeral: ephemeral, Sequential: true})
+	if txnErr, is := err.(*TxnError); is {
+		return nil, txnErr.Err
+	} else if err != nil {
+		return nil, err
+	}
+	return results[0].Path, nil
+}
+
+// Takes the next sequence number for the children of the parent, for EmulateSequential.  The counter
+// of the parent starts at start, one past the highest sequence number among the children, and a
+// number once taken is never handed out again, even if its node is deleted, as in zk.  Backends keep
+// the counter where it can be updated with CAS.
+type SequenceCounter func(parent Path, start int64) (int64, error)
+
+// For implementations without sequential nodes: numbers the sequential creates with the counter of
+// the parent, and applies the operations with txn.  When the node exists anyway, e.g. because it was
+// created without the counter, the create fails and the operations are numbered and applied again.
+func EmulateSequential(reg Registry, txn func(...Op) ([]OpResult, error), counter SequenceCounter,
+	ops []Op) ([]OpResult, error) {
+	sequential := func(op Op) bool {
+		create, is := op.(OpCreate)
+		return is && create.Sequential
+	}
+	has := false
+	for _, op := range ops {
+		has = has || sequential(op)
+	}
+	if !has {
+		return txn(ops...)
+	}
+	for {
+		start := map[string]int64{}
+		numbered := make([]Op, len(ops))
+		for i, op := range ops {
+			numbered[i] = op
+			if !sequential(op) {
+				continue
+			}
+			create := op.(OpCreate)
+			parent := create.Path.Dir()
+			if _, has := start[parent.String()]; !has {
+				children, err := reg.List(parent)
+				if err != nil && err != ErrNotExist {
+					return AbortTxn(ops, i, err)
+				}
+				start[parent.String()] = nextSequence(children)
+			}
+			seq, err := counter(parent, start[parent.String()])
+			if err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			create.Path, create.Sequential = SequentialPath(create.Path, seq), false
+			numbered[i] = create
+		}
+		results, err := txn(numbered...)
+		if txnErr, is := err.(*TxnError); is && txnErr.Err == ErrNodeExists &&
+			txnErr.Index >= 0 && sequential(ops[txnErr.Index]) {
+			continue
+		}
+		return results, err
+	}
+}
+
+func nextSequence(children []Path) int64 {
+	next := int64(0)
+	for _, child := range children {
+		if seq, has := SequenceOf(child); has && seq >= next {
+			next = seq + 1
+		}
+	}
+	return next
+}
@@ -0,0 +1,51 @@
+package namespace_test
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *TestSuiteRegistry) TestSequential(c *C) {
+	ctx := context.Background()
+	url := memUrl("sequential")
+	reg, err := namespace.Dial(ctx, url)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/registry/sequential")
+	first, err := namespace.CreateSequential(reg, p.Sub("job-"), []byte("1"), false)
+	c.Assert(err, IsNil)
+	c.Assert(first.String(), Equals, p.Sub("job-0000000000").String())
+
+	// Numbers are never reused, even if the nodes are gone.
+	c.Assert(reg.Delete(first), IsNil)
+	results, err := reg.Txn(
+		namespace.OpCreate{Path: p.Sub("job-"), Value: []byte("2"), Sequential: true},
+		namespace.OpCreate{Path: p.Sub("other-"), Value: []byte("3"), Sequential: true},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(results[0].Path.String(), Equals, p.Sub("job-0000000001").String())
+	c.Assert(results[1].Path.String(), Equals, p.Sub("other-0000000002").String())
+	seq, has := namespace.SequenceOf(results[1].Path)
+	c.Assert(has, Equals, true)
+	c.Assert(seq, Equals, int64(2))
+
+	// A rolled back create does not take a number.
+	_, err = reg.Txn(
+		namespace.OpCreate{Path: p.Sub("job-"), Value: []byte("4"), Sequential: true},
+		namespace.OpCheck{Path: p.Sub("missing"), Version: namespace.InvalidVersion},
+	)
+	c.Assert(err, NotNil)
+
+	// The paths of the results are relative to the chroot.
+	chroot, err := namespace.Dial(ctx, url+"?chroot="+p.String())
+	c.Assert(err, IsNil)
+	defer chroot.Close()
+	next, err := namespace.CreateSequential(chroot, namespace.NewPath("/job-"), []byte("5"), false)
+	c.Assert(err, IsNil)
+	c.Assert(next.String(), Equals, "/job-0000000003")
+	value, _, err := reg.Get(p.Sub("job-0000000003"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("5"))
+}
@@ -0,0 +1,138 @@
+package namespace
+
+import (
+	"encoding/base64"
+	"github.com/conductant/gohm/pkg/encoding"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// How RestoreSnapshot handles nodes that already exist in the target registry.
+type ConflictPolicy int
+
+const (
+	// Replaces the value of the existing node.
+	ConflictOverwrite ConflictPolicy = iota
+
+	// Keeps the existing node as is.
+	ConflictSkip
+
+	// Replaces the value only if the existing node is at the version in the snapshot, and fails
+	// with a VersionMismatch otherwise.  Useful for restoring into the registry the snapshot was
+	// taken from, since versions are not comparable across registries.
+	ConflictFailOnVersionMismatch
+)
+
+const (
+	// Encoding of values that are not valid utf-8 text.
+	SnapshotEncodingBase64 = "base64"
+)
+
+// A portable dump of a subtree.  Paths of the nodes are relative to the root, so the snapshot can be
+// restored under a different path or into a registry of a different scheme.
+type Snapshot struct {
+	Source string         `json:"source,omitempty" yaml:"source,omitempty"`
+	Root   string         `json:"root" yaml:"root"`
+	Nodes  []SnapshotNode `json:"nodes" yaml:"nodes"`
+}
+
+type SnapshotNode struct {
+	Path      string  `json:"path" yaml:"path"`
+	Value     string  `json:"value,omitempty" yaml:"value,omitempty"`
+	Encoding  string  `json:"encoding,omitempty" yaml:"encoding,omitempty"`
+	Version   Version `json:"version" yaml:"version"`
+	Ephemeral bool    `json:"ephemeral,omitempty" yaml:"ephemeral,omitempty"`
+}
+
+func (this SnapshotNode) Bytes() ([]byte, error) {
+	if this.Encoding == SnapshotEncodingBase64 {
+		return base64.StdEncoding.DecodeString(this.Value)
+	}
+	return []byte(this.Value), nil
+}
+
+// Takes a snapshot of the node at the path and all its descendants, parents first.  Nodes are flagged
+// as ephemeral if the registry implements EphemeralReporter.
+func TakeSnapshot(reg Registry, root Path) (*Snapshot, error) {
+	id := reg.Id()
+	snapshot := &Snapshot{Source: id.String(), Root: root.String(), Nodes: []SnapshotNode{}}
+	reporter, canReport := reg.(EphemeralReporter)
+	err := Walk(reg, root, func(p Path, value []byte, version Version) error {
+		node := SnapshotNode{
+			Path:    NewPath(strings.TrimPrefix(p.String(), root.String())).String(),
+			Value:   string(value),
+			Version: version,
+		}
+		if !utf8.Valid(value) {
+			node.Value, node.Encoding = base64.StdEncoding.EncodeToString(value), SnapshotEncodingBase64
+		}
+		if canReport {
+			ephemeral, err := reporter.IsEphemeral(p)
+			if err != nil && err != ErrNotExist {
+				return err
+			}
+			node.Ephemeral = ephemeral
+		}
+		snapshot.Nodes = append(snapshot.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Restores the nodes of the snapshot under the root.  Ephemeral nodes are restored as ephemeral nodes
+// of the given registry.  Returns the paths written.
+func RestoreSnapshot(reg Registry, root Path, snapshot *Snapshot, policy ConflictPolicy) ([]Path, error) {
+	written := []Path{}
+	for _, node := range snapshot.Nodes {
+		p := NewPath(root.String(), node.Path)
+		value, err := node.Bytes()
+		if err != nil {
+			return written, err
+		}
+		_, current, err := reg.Get(p)
+		switch {
+		case err == ErrNotExist:
+			_, err = reg.Put(p, value, node.Ephemeral)
+		case err != nil:
+		case policy == ConflictSkip:
+			continue
+		case policy == ConflictFailOnVersionMismatch:
+			if current != node.Version {
+				return written, &VersionMismatch{Path: p, Expected: node.Version, Actual: current}
+			}
+			_, err = reg.PutVersion(p, value, node.Version)
+			if err == ErrBadVersion {
+				err = &VersionMismatch{Path: p, Expected: node.Version, Actual: InvalidVersion}
+			}
+		default:
+			_, err = reg.Put(p, value, false)
+		}
+		if err != nil {
+			return written, err
+		}
+		written = append(written, p)
+	}
+	return written, nil
+}
+
+// Writes a snapshot of the subtree in the content type, e.g. encoding.ContentTypeJSON or ContentTypeYAML.
+func ExportSnapshot(reg Registry, root Path, t encoding.ContentType, w io.Writer) error {
+	snapshot, err := TakeSnapshot(reg, root)
+	if err != nil {
+		return err
+	}
+	return encoding.Marshal(t, w, snapshot)
+}
+
+// Reads a snapshot in the content type and restores it under the root.
+func ImportSnapshot(reg Registry, root Path, t encoding.ContentType, r io.Reader, policy ConflictPolicy) ([]Path, error) {
+	snapshot := new(Snapshot)
+	if err := encoding.Unmarshal(t, r, snapshot); err != nil {
+		return nil, err
+	}
+	return RestoreSnapshot(reg, root, snapshot, policy)
+}
@@ -0,0 +1,63 @@
+package namespace_test
+
+import (
+	"bytes"
+	"github.com/conductant/gohm/pkg/encoding"
+	"github.com/conductant/gohm/pkg/namespace"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *TestSuiteRegistry) TestSnapshot(c *C) {
+	reg, _ := dialMem(c, "snapshot")
+	defer reg.Close()
+
+	root := namespace.NewPath("/unit-test/registry/snapshot")
+	_, err := reg.Put(root.Sub("text"), []byte("hello"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(root.Sub("binary"), []byte{0xff, 0x00, 0xfe}, false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(root.Sub("live"), []byte("live"), true)
+	c.Assert(err, IsNil)
+
+	for _, t := range []encoding.ContentType{encoding.ContentTypeJSON, encoding.ContentTypeYAML} {
+		buff := new(bytes.Buffer)
+		err = namespace.ExportSnapshot(reg, root, t, buff)
+		c.Assert(err, IsNil)
+		c.Log(buff.String())
+
+		other, _ := dialMem(c, "snapshot-"+t.String())
+		written, err := namespace.ImportSnapshot(other, namespace.NewPath("/restored"), t, bytes.NewBuffer(buff.Bytes()),
+			namespace.ConflictOverwrite)
+		c.Assert(err, IsNil)
+		c.Assert(len(written), Equals, 4)
+
+		value, _, err := other.Get(namespace.NewPath("/restored/binary"))
+		c.Assert(err, IsNil)
+		c.Assert(value, DeepEquals, []byte{0xff, 0x00, 0xfe})
+		ephemeral, err := other.(namespace.EphemeralReporter).IsEphemeral(namespace.NewPath("/restored/live"))
+		c.Assert(err, IsNil)
+		c.Assert(ephemeral, Equals, true)
+		other.Close()
+	}
+
+	// Restoring over the source
+	snapshot, err := namespace.TakeSnapshot(reg, root)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(root.Sub("text"), []byte("changed"), false)
+	c.Assert(err, IsNil)
+
+	written, err := namespace.RestoreSnapshot(reg, root, snapshot, namespace.ConflictSkip)
+	c.Assert(err, IsNil)
+	c.Assert(len(written), Equals, 0)
+
+	_, err = namespace.RestoreSnapshot(reg, root, snapshot, namespace.ConflictFailOnVersionMismatch)
+	mismatch, is := err.(*namespace.VersionMismatch)
+	c.Assert(is, Equals, true)
+	c.Assert(mismatch.Path, Equals, root.Sub("text"))
+
+	_, err = namespace.RestoreSnapshot(reg, root, snapshot, namespace.ConflictOverwrite)
+	c.Assert(err, IsNil)
+	value, _, err := reg.Get(root.Sub("text"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("hello"))
+}
@@ -10,6 +10,10 @@ import (
 type Implementation func(ctx context.Context, url net.URL, dispose store.Dispose) (Registry, error)
 type UrlSanitizer func(url net.URL) net.URL
 
+// Wraps the registries returned by Dial, for example to encrypt values.  Returns the registry as is if
+// the url is not of interest.
+type Decorator func(url net.URL, reg Registry) Registry
+
 func Register(protocol string, impl Implementation) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -21,3 +25,18 @@ func RegisterSanitizer(protocol string, impl UrlSanitizer) {
 	defer lock.Unlock()
 	sanitizers[scheme(protocol)] = impl
 }
+
+func RegisterDecorator(decorator Decorator) {
+	lock.Lock()
+	defer lock.Unlock()
+	decorators = append(decorators, decorator)
+}
+
+func decorate(url net.URL, reg Registry) Registry {
+	lock.Lock()
+	defer lock.Unlock()
+	for _, d := range decorators {
+		reg = d(url, reg)
+	}
+	return reg
+}
@@ -0,0 +1,204 @@
+package namespace
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// Returned by a WalkFunc to skip the children of the node.
+	SkipChildren = errors.New("skip-children")
+)
+
+// Called for each node visited by Walk.  Returning SkipChildren skips the children of the node, and
+// any other error stops the walk.
+type WalkFunc func(path Path, value []byte, version Version) error
+
+// Optional interface for registries that can tell whether a node is ephemeral.  Tree operations
+// use this to skip ephemeral nodes.
+type EphemeralReporter interface {
+	IsEphemeral(Path) (bool, error)
+}
+
+// Options for the tree operations.
+type TreeOptions struct {
+	// Number of nodes processed in parallel.  Nodes at the same depth are processed concurrently,
+	// and parents are always written before and deleted after their children.  0 means 1.
+	Concurrency int
+
+	// Only returns the paths that would be changed.
+	DryRun bool
+
+	// Skips ephemeral nodes and their subtrees in CopyTree, if the source registry implements
+	// EphemeralReporter.  Ephemeral nodes belong to the session that created them, so copying
+	// them as persistent nodes is usually not wanted.
+	SkipEphemeral bool
+}
+
+// Visits the node at the path and all its descendants, depth first, parents before children.
+// Nodes that disappear during the walk are skipped.
+func Walk(reg Registry, path Path, fn WalkFunc) error {
+	value, version, err := reg.Get(path)
+	switch err {
+	case nil:
+	case ErrNotExist:
+		return nil
+	default:
+		return err
+	}
+	switch err := fn(path, value, version); err {
+	case nil:
+	case SkipChildren:
+		return nil
+	default:
+		return err
+	}
+	children, err := reg.List(path)
+	switch err {
+	case nil:
+	case ErrNotExist:
+		return nil
+	default:
+		return err
+	}
+	for _, child := range children {
+		if err := Walk(reg, child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type treeNode struct {
+	path  Path
+	value []byte
+}
+
+// Collects the nodes of the tree grouped by depth below the root.
+func collectTree(reg Registry, root Path, skipEphemeral bool) ([][]treeNode, error) {
+	reporter, canReport := reg.(EphemeralReporter)
+	levels := [][]treeNode{}
+	err := Walk(reg, root, func(p Path, value []byte, version Version) error {
+		if skipEphemeral && canReport {
+			if ephemeral, err := reporter.IsEphemeral(p); err != nil {
+				return err
+			} else if ephemeral {
+				return SkipChildren
+			}
+		}
+		depth := 0
+		if rel := strings.Trim(strings.TrimPrefix(p.String(), root.String()), "/"); rel != "" {
+			depth = strings.Count(rel, "/") + 1
+		}
+		for len(levels) <= depth {
+			levels = append(levels, []treeNode{})
+		}
+		levels[depth] = append(levels[depth], treeNode{path: p, value: value})
+		return nil
+	})
+	return levels, err
+}
+
+// Runs the function on the nodes with at most the given number of goroutines.  Returns the first error.
+func parallel(nodes []treeNode, concurrency int, fn func(treeNode) error) error {
+	return parallelIndex(len(nodes), concurrency, func(i int) error {
+		return fn(nodes[i])
+	})
+}
+
+// Runs the function on 0 to count - 1 with at most the given number of goroutines.  Returns the first
+// error.
+func parallelIndex(count, concurrency int, fn func(int) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var first error
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range work {
+				if err := fn(n); err != nil {
+					lock.Lock()
+					if first == nil {
+						first = err
+					}
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return first
+}
+
+// Deletes the node at the path and all its descendants.  Returns the paths deleted, children first.
+// Nodes removed by someone else in the meantime are not an error.  The root itself can't be deleted,
+// so for / only the descendants are.
+func DeleteTree(reg Registry, path Path, options TreeOptions) ([]Path, error) {
+	levels, err := collectTree(reg, path, false)
+	if err != nil {
+		return nil, err
+	}
+	if path.String() == "/" && len(levels) > 0 {
+		levels = levels[1:]
+	}
+	deleted := []Path{}
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, n := range levels[i] {
+			deleted = append(deleted, n.path)
+		}
+		if options.DryRun {
+			continue
+		}
+		err := parallel(levels[i], options.Concurrency, func(n treeNode) error {
+			if err := reg.Delete(n.path); err != nil && err != ErrNotExist {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+// Copies the node at srcPath and all its descendants to dstPath, which can be in a registry of a
+// different scheme.  Existing nodes in the destination are overwritten and the copies are never
+// ephemeral.  Returns the paths written in the destination, parents first.
+func CopyTree(src Registry, srcPath Path, dst Registry, dstPath Path, options TreeOptions) ([]Path, error) {
+	levels, err := collectTree(src, srcPath, options.SkipEphemeral)
+	if err != nil {
+		return nil, err
+	}
+	prefix := srcPath.String()
+	target := func(p Path) Path {
+		return NewPath(dstPath.String(), strings.TrimPrefix(p.String(), prefix))
+	}
+	copied := []Path{}
+	for _, level := range levels {
+		for _, n := range level {
+			copied = append(copied, target(n.path))
+		}
+		if options.DryRun {
+			continue
+		}
+		err := parallel(level, options.Concurrency, func(n treeNode) error {
+			_, err := dst.Put(target(n.path), n.value, false)
+			return err
+		})
+		if err != nil {
+			return copied, err
+		}
+	}
+	return copied, nil
+}
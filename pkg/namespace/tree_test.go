@@ -0,0 +1,85 @@
+package namespace_test
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	. "gopkg.in/check.v1"
+)
+
+func (suite *TestSuiteRegistry) TestTree(c *C) {
+	reg, _ := dialMem(c, "tree")
+	defer reg.Close()
+
+	root := namespace.NewPath("/unit-test/registry/tree")
+	for _, p := range []string{"a/1", "a/2", "b/1/x"} {
+		_, err := reg.Put(root.Sub(p), []byte(p), false)
+		c.Assert(err, IsNil)
+	}
+	_, err := reg.Put(root.Sub("live"), []byte("live"), true)
+	c.Assert(err, IsNil)
+
+	visited := []string{}
+	err = namespace.Walk(reg, root, func(p namespace.Path, v []byte, version namespace.Version) error {
+		visited = append(visited, p.String())
+		if p.Base() == "b" {
+			return namespace.SkipChildren
+		}
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(visited, DeepEquals, []string{
+		root.String(), root.Sub("a").String(), root.Sub("a/1").String(), root.Sub("a/2").String(),
+		root.Sub("b").String(), root.Sub("live").String(),
+	})
+
+	// Copy to another registry, without the ephemeral node.
+	other, _ := dialMem(c, "tree-copy")
+	defer other.Close()
+
+	dst := namespace.NewPath("/copy")
+	options := namespace.TreeOptions{Concurrency: 4, SkipEphemeral: true, DryRun: true}
+	copied, err := namespace.CopyTree(reg, root, other, dst, options)
+	c.Assert(err, IsNil)
+	c.Assert(len(copied), Equals, 7)
+	c.Assert(copied[0], Equals, dst)
+	exists, err := other.Exists(dst)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	options.DryRun = false
+	_, err = namespace.CopyTree(reg, root, other, dst, options)
+	c.Assert(err, IsNil)
+	value, _, err := other.Get(dst.Sub("b/1/x"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("b/1/x"))
+	exists, err = other.Exists(dst.Sub("live"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	// Children are deleted before the parents.
+	deleted, err := namespace.DeleteTree(reg, root, namespace.TreeOptions{Concurrency: 4})
+	c.Assert(err, IsNil)
+	c.Assert(len(deleted), Equals, 8)
+	c.Assert(deleted[len(deleted)-1], Equals, root)
+	exists, err = reg.Exists(root)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteRegistry) TestDeleteTreeAtRoot(c *C) {
+	reg, _ := dialMem(c, "tree-delete-root")
+	defer reg.Close()
+
+	for _, p := range []string{"/a/1", "/a/2", "/b"} {
+		_, err := reg.Put(namespace.NewPath(p), []byte(p), false)
+		c.Assert(err, IsNil)
+	}
+	deleted, err := namespace.DeleteTree(reg, namespace.NewPath("/"), namespace.TreeOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(len(deleted), Equals, 4)
+	for _, p := range deleted {
+		c.Assert(p, Not(Equals), namespace.NewPath("/"))
+	}
+	children, err := reg.List(namespace.NewPath("/"))
+	c.Assert(err, IsNil)
+	c.Assert(len(children), Equals, 0)
+}
@@ -1,5 +1,9 @@
 package namespace
 
+import (
+	"sort"
+)
+
 // Special marker interface implemented only by Create, Change, Delete, and Members
 type kind int
 
@@ -76,3 +80,98 @@ func (this *Members) SetOutsideRange(b bool) *Members {
 	this.OutsideRange = b
 	return this
 }
+
+// Carried by the EventMembers events of Members triggers when the criteria match.  Before is the
+// membership when the trigger last fired, or when it was set up if it has not fired yet.
+type MembersChange struct {
+	Path        string   `json:"path"`
+	Before      []string `json:"before"`
+	After       []string `json:"after"`
+	BeforeCount int      `json:"before_count"`
+	AfterCount  int      `json:"after_count"`
+}
+
+func (this *MembersChange) Event() Event {
+	return Event{Kind: EventMembers, Path: this.Path, Version: InvalidVersion, Members: this}
+}
+
+// Returns true if the count is in the range, or outside the range if OutsideRange is set.
+func (this Members) InRange(count int) bool {
+	in := (this.Min == nil || count >= *this.Min) && (this.Max == nil || count <= *this.Max)
+	return in != this.OutsideRange
+}
+
+func (this Members) hasRange() bool {
+	return this.Min != nil || this.Max != nil
+}
+
+// Tracks the membership seen by a Members trigger and decides when the trigger fires.  For use by
+// the backend implementations, which report the members every time they may have changed:
+//  - Without any criteria, every change of the members fires.
+//  - With only Min / Max, the trigger fires when the count enters the range, or leaves it if
+//    OutsideRange is set.
+//  - With Delta, the trigger fires when the count differs by at least Delta from the count when the
+//    trigger last fired.  If Min / Max are also set, the count must also be in range.
+type MembersMatcher struct {
+	trigger Members
+	fired   []string
+	current []string
+}
+
+func NewMembersMatcher(trigger Members, members []string) *MembersMatcher {
+	members = sortedCopy(members)
+	return &MembersMatcher{trigger: trigger, fired: members, current: members}
+}
+
+func sortedCopy(s []string) []string {
+	c := make([]string, len(s))
+	copy(c, s)
+	sort.Strings(c)
+	return c
+}
+
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i, _ := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the change to deliver, or nil if the trigger does not fire.
+func (this *MembersMatcher) Update(members []string) *MembersChange {
+	members = sortedCopy(members)
+	if sameMembers(members, this.current) {
+		return nil
+	}
+	prev := this.current
+	this.current = members
+
+	fire := true
+	switch {
+	case this.trigger.Delta != nil:
+		delta := len(members) - len(this.fired)
+		if delta < 0 {
+			delta = -delta
+		}
+		fire = delta >= *this.trigger.Delta && (!this.trigger.hasRange() || this.trigger.InRange(len(members)))
+	case this.trigger.hasRange():
+		fire = this.trigger.InRange(len(members)) && !this.trigger.InRange(len(prev))
+	}
+	if !fire {
+		return nil
+	}
+	change := &MembersChange{
+		Path:        this.trigger.Path.String(),
+		Before:      this.fired,
+		After:       members,
+		BeforeCount: len(this.fired),
+		AfterCount:  len(members),
+	}
+	this.fired = members
+	return change
+}
@@ -1,6 +1,7 @@
 package namespace
 
 import (
+	"fmt"
 	. "gopkg.in/check.v1"
 	"testing"
 )
@@ -61,3 +62,35 @@ func (suite *TestSuiteTrigger) TestTriggerApi(c *C) {
 	//test(TestChange{Path: NewPath("/this/is/create")})
 
 }
+
+func (suite *TestSuiteTrigger) TestMembersMatcher(c *C) {
+	members := func(n int) []string {
+		m := []string{}
+		for i := 0; i < n; i++ {
+			m = append(m, fmt.Sprintf("/p/%d", i))
+		}
+		return m
+	}
+	fires := func(t Members, counts ...int) []int {
+		matcher := NewMembersMatcher(t, members(counts[0]))
+		fired := []int{}
+		for _, n := range counts[1:] {
+			if change := matcher.Update(members(n)); change != nil {
+				fired = append(fired, change.AfterCount)
+			}
+		}
+		return fired
+	}
+	p := NewPath("/p")
+	c.Assert(fires(Members{Path: p}, 0, 1, 1, 2, 1), DeepEquals, []int{1, 2, 1})
+	c.Assert(fires(*(&Members{Path: p}).SetMin(2).SetMax(3), 0, 1, 2, 3, 4, 3, 1, 2), DeepEquals, []int{2, 3, 2})
+	c.Assert(fires(*(&Members{Path: p}).SetMin(2).SetMax(3).SetOutsideRange(true), 2, 3, 4, 5, 2, 1), DeepEquals, []int{4, 1})
+	c.Assert(fires(*(&Members{Path: p}).SetDelta(2), 5, 6, 7, 8, 9, 6), DeepEquals, []int{7, 9, 6})
+	c.Assert(fires(*(&Members{Path: p}).SetDelta(2).SetMax(8), 5, 7, 9, 8), DeepEquals, []int{7})
+
+	matcher := NewMembersMatcher(Members{Path: p}, []string{"/p/b", "/p/a"})
+	change := matcher.Update([]string{"/p/c", "/p/a"})
+	c.Assert(change.Before, DeepEquals, []string{"/p/a", "/p/b"})
+	c.Assert(change.After, DeepEquals, []string{"/p/a", "/p/c"})
+	c.Assert(change.BeforeCount, Equals, 2)
+}
@@ -0,0 +1,117 @@
+package namespace
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// Set on the results of the operations that were not applied because another operation failed.
+	ErrRolledBack = errors.New("error-rolled-back")
+)
+
+type opKind int
+
+const (
+	opCheck opKind = iota
+	opCreate
+	opSet
+	opDelete
+)
+
+// An operation in a transaction. Like Trigger, this interface can't be implemented outside
+// this package; the operations are OpCheck, OpCreate, OpSet and OpDelete.
+type Op interface {
+	opKind() opKind
+}
+
+func (this OpCheck) opKind() opKind {
+	return opCheck
+}
+
+func (this OpCreate) opKind() opKind {
+	return opCreate
+}
+
+func (this OpSet) opKind() opKind {
+	return opSet
+}
+
+func (this OpDelete) opKind() opKind {
+	return opDelete
+}
+
+// Checks that the node exists and is at the given version.
+type OpCheck struct {
+	Path    `json:"path"`
+	Version Version `json:"version"`
+}
+
+// Creates the node. Fails if the node exists.  If Sequential, a sequence number is appended to the
+// name of the node, which is higher than the numbers of the nodes created before it under the same
+// parent.  The Path of the result is then the node created.  See SequentialPath.
+type OpCreate struct {
+	Path       `json:"path"`
+	Value      []byte `json:"value"`
+	Ephemeral  bool   `json:"ephemeral,omitempty"`
+	Sequential bool   `json:"sequential,omitempty"`
+}
+
+// Sets the value of an existing node, with CAS.  Use InvalidVersion to match any version.
+type OpSet struct {
+	Path    `json:"path"`
+	Value   []byte  `json:"value"`
+	Version Version `json:"version"`
+}
+
+// Deletes a node without children, with CAS.  Use InvalidVersion to match any version.
+type OpDelete struct {
+	Path    `json:"path"`
+	Version Version `json:"version"`
+}
+
+// Result of an operation in a transaction.  Version is the version of the node after the
+// operation, or InvalidVersion for deletes.
+type OpResult struct {
+	Path    Path
+	Version Version
+	Err     error
+}
+
+// Returned by Txn when an operation fails.  None of the operations are applied.
+type TxnError struct {
+	Index int // Index of the failed operation, or -1 if not known.
+	Err   error
+}
+
+func (this *TxnError) Error() string {
+	return fmt.Sprintf("txn-failed: op=%d, err=%v", this.Index, this.Err)
+}
+
+// Returns the path of the node of the operation.
+func PathOf(op Op) Path {
+	switch op := op.(type) {
+	case OpCheck:
+		return op.Path
+	case OpCreate:
+		return op.Path
+	case OpSet:
+		return op.Path
+	case OpDelete:
+		return op.Path
+	}
+	return EmptyPath
+}
+
+// For implementations: returns the results and error of a transaction where the op at the index failed.
+// If the failed op is not known, use -1 for the index and all the results will have the error.
+func AbortTxn(ops []Op, index int, err error) ([]OpResult, error) {
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		results[i] = OpResult{Path: PathOf(op), Version: InvalidVersion, Err: ErrRolledBack}
+		if i == index || index < 0 {
+			results[i].Err = err
+		}
+	}
+	return results, &TxnError{Index: index, Err: err}
+}
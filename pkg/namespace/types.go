@@ -1,11 +1,15 @@
 package namespace
 
 import (
+	"golang.org/x/net/context"
 	"io"
 	"net/url"
+	"time"
 )
 
-type Version int32
+// Version of a node, for CAS.  64 bits so that backends with cluster-wide counters, e.g. the
+// ModifyIndex of consul, map to it without loss.
+type Version int64
 
 const (
 	InvalidVersion Version = -1
@@ -21,5 +25,20 @@ type Registry interface {
 	Delete(Path) error
 	DeleteVersion(Path, Version) error // Delete with CAS
 	List(Path) ([]Path, error)
-	Trigger(Trigger) (<-chan interface{}, chan<- int, error) // events channel, channel to stop, error
+	Stat(Path) (*Stat, error)
+	Txn(...Op) ([]OpResult, error) // Applies all or none of the operations.
+
+	// Returns the events of the trigger until the context is done.  See Event for the guarantees.
+	Trigger(context.Context, Trigger) (<-chan Event, error)
+}
+
+// Metadata of a node.  The fields a backend does not track are left zero, e.g. consul has no times.
+type Stat struct {
+	Version   Version   `json:"version" yaml:"version"`
+	Created   time.Time `json:"created" yaml:"created"`
+	Modified  time.Time `json:"modified" yaml:"modified"`
+	Size      int       `json:"size" yaml:"size"` // length of the value
+	Children  int       `json:"children" yaml:"children"`
+	Ephemeral bool      `json:"ephemeral" yaml:"ephemeral"`
+	Owner     string    `json:"owner,omitempty" yaml:"owner,omitempty"` // session that owns the node, if ephemeral
 }
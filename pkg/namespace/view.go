@@ -0,0 +1,235 @@
+package namespace
+
+import (
+	"golang.org/x/net/context"
+	net "net/url"
+	"strings"
+)
+
+// A registry seen through the chroot and readonly options of the url it was dialed with.  Closing
+// the view closes the shared registry underneath.  Of the optional interfaces, only Globber and
+// EphemeralReporter are passed through.  Backend specific ones, e.g. zk.ACLRegistry, are dropped, since
+// the view could not apply the chroot and readonly options to them.
+type view struct {
+	Registry
+	root     Path
+	readOnly bool
+	query    string
+}
+
+func newView(reg Registry, opts Options, query string) Registry {
+	if opts.Chroot == nil && !opts.ReadOnly {
+		return reg
+	}
+	return &view{Registry: reg, root: opts.Chroot, readOnly: opts.ReadOnly, query: query}
+}
+
+// Returns the path in the underlying registry.
+func (this *view) in(p Path) Path {
+	if this.root == nil {
+		return p
+	}
+	return this.root.Sub(NewPath(p.String()).String())
+}
+
+// Returns the path as seen through the view.  Paths outside the root are returned as they are.
+func (this *view) out(p string) string {
+	if this.root == nil {
+		return p
+	}
+	root := this.root.String()
+	switch {
+	case p == root:
+		return "/"
+	case strings.HasPrefix(p, root+"/"):
+		return p[len(root):]
+	}
+	return p
+}
+
+func (this *view) write() error {
+	if this.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// The id includes the options so urls built from it go through the same view.
+func (this *view) Id() net.URL {
+	id := this.Registry.Id()
+	id.RawQuery = this.query
+	return id
+}
+
+func (this *view) Exists(key Path) (bool, error) {
+	return this.Registry.Exists(this.in(key))
+}
+
+func (this *view) Get(key Path) ([]byte, Version, error) {
+	return this.Registry.Get(this.in(key))
+}
+
+func (this *view) IsEphemeral(key Path) (bool, error) {
+	if reporter, is := this.Registry.(EphemeralReporter); is {
+		return reporter.IsEphemeral(this.in(key))
+	}
+	return false, nil
+}
+
+func (this *view) Stat(key Path) (*Stat, error) {
+	return this.Registry.Stat(this.in(key))
+}
+
+func (this *view) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	if err := this.write(); err != nil {
+		return InvalidVersion, err
+	}
+	return this.Registry.Put(this.in(key), value, ephemeral)
+}
+
+func (this *view) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	if err := this.write(); err != nil {
+		return InvalidVersion, err
+	}
+	return this.Registry.PutVersion(this.in(key), value, version)
+}
+
+func (this *view) Delete(key Path) error {
+	if err := this.write(); err != nil {
+		return err
+	}
+	return this.Registry.Delete(this.in(key))
+}
+
+func (this *view) DeleteVersion(key Path, version Version) error {
+	if err := this.write(); err != nil {
+		return err
+	}
+	return this.Registry.DeleteVersion(this.in(key), version)
+}
+
+func (this *view) List(key Path) ([]Path, error) {
+	list, err := this.Registry.List(this.in(key))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Path, len(list))
+	for i, p := range list {
+		out[i] = NewPath(this.out(p.String()))
+	}
+	return out, nil
+}
+
+// Passes the glob down to the registry underneath, unless the chroot itself has wildcards.
+func (this *view) Glob(pattern string) ([]Path, error) {
+	globber, is := this.Registry.(Globber)
+	if !is || (this.root != nil && hasMeta(this.root.String())) {
+		return globList(this, pattern)
+	}
+	list, err := globber.Glob(this.in(NewPath(pattern)).String())
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Path, len(list))
+	for i, p := range list {
+		out[i] = NewPath(this.out(p.String()))
+	}
+	return out, nil
+}
+
+// Only checks are allowed when read only.
+func (this *view) Txn(ops ...Op) ([]OpResult, error) {
+	translated := make([]Op, len(ops))
+	for i, op := range ops {
+		switch op := op.(type) {
+		case OpCheck:
+			op.Path = this.in(op.Path)
+			translated[i] = op
+		case OpCreate:
+			if err := this.write(); err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			op.Path = this.in(op.Path)
+			translated[i] = op
+		case OpSet:
+			if err := this.write(); err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			op.Path = this.in(op.Path)
+			translated[i] = op
+		case OpDelete:
+			if err := this.write(); err != nil {
+				return AbortTxn(ops, i, err)
+			}
+			op.Path = this.in(op.Path)
+			translated[i] = op
+		default:
+			translated[i] = op
+		}
+	}
+	results, err := this.Registry.Txn(translated...)
+	for i := range results {
+		results[i].Path = NewPath(this.out(results[i].Path.String()))
+	}
+	return results, err
+}
+
+// Triggers are set on the underlying paths.  The paths in the events are translated back.
+func (this *view) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	if m, is := t.(*Members); is {
+		t = *m
+	}
+	switch t := t.(type) {
+	case Create:
+		t.Path = this.in(t.Path)
+		return this.trigger(ctx, t)
+	case Change:
+		t.Path = this.in(t.Path)
+		return this.trigger(ctx, t)
+	case Delete:
+		t.Path = this.in(t.Path)
+		return this.trigger(ctx, t)
+	case Members:
+		t.Path = this.in(t.Path)
+		return this.trigger(ctx, t)
+	}
+	return this.Registry.Trigger(ctx, t)
+}
+
+func (this *view) trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	events, err := this.Registry.Trigger(ctx, t)
+	if err != nil || this.root == nil {
+		return events, err
+	}
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for event := range events {
+			event.Path = this.out(event.Path)
+			if event.Members != nil {
+				change := *event.Members
+				change.Path = this.out(change.Path)
+				change.Before = this.outAll(change.Before)
+				change.After = this.outAll(change.After)
+				event.Members = &change
+			}
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				// Drain so the underlying trigger is never blocked.
+				for _ = range events {
+				}
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (this *view) outAll(paths []string) []string {
+	out := make([]string, len(paths))
+	for i, p := range paths {
+		out[i] = this.out(p)
+	}
+	return out
+}
@@ -0,0 +1,31 @@
+package namespace
+
+import (
+	. "gopkg.in/check.v1"
+	"testing"
+)
+
+func TestView(t *testing.T) { TestingT(t) }
+
+type TestSuiteView struct {
+}
+
+var _ = Suite(&TestSuiteView{})
+
+func (suite *TestSuiteView) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteView) TearDownSuite(c *C) {
+}
+
+func (suite *TestSuiteView) TestChrootPaths(c *C) {
+	v := &view{root: NewPath("/a")}
+	c.Assert(v.in(NewPath("/")), Equals, NewPath("/a"))
+	c.Assert(v.in(NewPath("/b/c")), Equals, NewPath("/a/b/c"))
+
+	c.Assert(v.out("/a"), Equals, "/")
+	c.Assert(v.out("/a/b/c"), Equals, "/b/c")
+	// Only whole segments are stripped.
+	c.Assert(v.out("/ab"), Equals, "/ab")
+	c.Assert(v.out("/ab/c"), Equals, "/ab/c")
+}
@@ -0,0 +1,467 @@
+package ns
+
+import (
+	"flag"
+	"fmt"
+	"github.com/conductant/gohm/pkg/encoding"
+	"github.com/conductant/gohm/pkg/mirror"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	net "net/url"
+	"strings"
+	"time"
+)
+
+func init() {
+	subcommands["get"] = subcommand{"<url>  Prints the value of the node.", (*Module).get}
+	subcommands["put"] = subcommand{"[-ephemeral] <url> [value]  Writes the value, or stdin.", (*Module).put}
+	subcommands["ls"] = subcommand{"[-R] <url>  Lists the children, or all descendants with -R.", (*Module).ls}
+	subcommands["rm"] = subcommand{"[-r] <url>  Deletes the node, and its descendants with -r.", (*Module).rm}
+	subcommands["watch"] = subcommand{"[-n count] <url>  Prints the changes of the node and its members.", (*Module).watch}
+	subcommands["stat"] = subcommand{"<url>  Prints the version, size, children and times of the node.", (*Module).stat}
+	subcommands["export"] = subcommand{"<url>  Writes a snapshot of the subtree, in json or yaml.", (*Module).export}
+	subcommands["import"] = subcommand{"[-policy overwrite|skip|version] [-type json|yaml] <url>  Restores a snapshot from stdin.",
+		(*Module).restore}
+	subcommands["diff"] = subcommand{"[-exclude patterns] <source url> <target url>  Compares the subtrees.",
+		(*Module).diff}
+	subcommands["mirror"] = subcommand{"[-once] [-n count] [-policy overwrite|skip|version] [-exclude patterns] " +
+		"[-skip-ephemeral] <source url> <target url>  Keeps the target subtree the same as the source.",
+		(*Module).mirror}
+	subcommands["follow"] = subcommand{"[-max-hops n] [-links-only] <url>  Follows the links from the node.",
+		(*Module).follow}
+}
+
+type node struct {
+	Path    string            `json:"path" yaml:"path"`
+	Value   string            `json:"value,omitempty" yaml:"value,omitempty"`
+	Version namespace.Version `json:"version" yaml:"version"`
+}
+
+func (this *Module) get(fs *flag.FlagSet, args []string, w io.Writer) error {
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	value, version, err := reg.Get(p)
+	if err != nil {
+		return err
+	}
+	return this.output(w, node{Path: p.String(), Value: string(value), Version: version}, func(w io.Writer) {
+		writeText(w, value)
+	})
+}
+
+func (this *Module) put(fs *flag.FlagSet, args []string, w io.Writer) error {
+	ephemeral := fs.Bool("ephemeral", false, "Creates an ephemeral node, which goes away when the command exits")
+	reg, p, rest, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	var value []byte
+	if len(rest) > 0 {
+		value = []byte(rest[0])
+	} else if value, err = ioutil.ReadAll(this.in); err != nil {
+		return err
+	}
+	version, err := reg.Put(p, value, *ephemeral)
+	if err != nil {
+		return err
+	}
+	return this.output(w, node{Path: p.String(), Version: version}, func(w io.Writer) {
+		fmt.Fprintln(w, p, version)
+	})
+}
+
+func (this *Module) ls(fs *flag.FlagSet, args []string, w io.Writer) error {
+	recursive := fs.Bool("R", false, "Lists all the descendants")
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	paths := []string{}
+	if *recursive {
+		found := false
+		err = namespace.Walk(reg, p, func(child namespace.Path, _ []byte, _ namespace.Version) error {
+			if child.String() == p.String() {
+				found = true
+			} else {
+				paths = append(paths, child.String())
+			}
+			return nil
+		})
+		if err == nil && !found {
+			err = namespace.ErrNotExist
+		}
+	} else {
+		var children []namespace.Path
+		children, err = reg.List(p)
+		for _, child := range children {
+			paths = append(paths, child.String())
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return this.output(w, paths, func(w io.Writer) {
+		for _, child := range paths {
+			fmt.Fprintln(w, child)
+		}
+	})
+}
+
+func (this *Module) rm(fs *flag.FlagSet, args []string, w io.Writer) error {
+	recursive := fs.Bool("r", false, "Deletes all the descendants too")
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	deleted := []string{}
+	if *recursive {
+		paths, err := namespace.DeleteTree(reg, p, namespace.TreeOptions{})
+		if err != nil {
+			return err
+		}
+		if len(paths) == 0 {
+			return namespace.ErrNotExist
+		}
+		for _, d := range paths {
+			deleted = append(deleted, d.String())
+		}
+	} else {
+		if err := reg.Delete(p); err != nil {
+			return err
+		}
+		deleted = append(deleted, p.String())
+	}
+	return this.output(w, deleted, func(w io.Writer) {
+		for _, d := range deleted {
+			fmt.Fprintln(w, d)
+		}
+	})
+}
+
+func (this *Module) watch(fs *flag.FlagSet, args []string, w io.Writer) error {
+	count := fs.Int("n", 0, "Exits after this many events; 0 to watch until interrupted")
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+
+	ctx, stop := context.WithCancel(this.ctx)
+	defer stop()
+	events := make(chan namespace.Event)
+	for _, t := range []namespace.Trigger{
+		namespace.Create{Path: p}, namespace.Change{Path: p}, namespace.Delete{Path: p}, namespace.Members{Path: p},
+	} {
+		e, err := reg.Trigger(ctx, t)
+		if err != nil {
+			return err
+		}
+		go func() {
+			for event := range e {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	for seen := 0; *count == 0 || seen < *count; seen++ {
+		select {
+		case e := <-events:
+			if err := this.output(w, e.AsMap(), func(w io.Writer) {
+				fmt.Fprintln(w, e.Kind, e.Path, e.Version)
+			}); err != nil {
+				return err
+			}
+			if e.Kind == namespace.EventError {
+				return e.Err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+type stat struct {
+	Path           string `json:"path" yaml:"path"`
+	namespace.Stat `yaml:",inline"`
+}
+
+func (this *Module) stat(fs *flag.FlagSet, args []string, w io.Writer) error {
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	st, err := reg.Stat(p)
+	if err != nil {
+		return err
+	}
+	s := stat{Path: p.String(), Stat: *st}
+	return this.output(w, s, func(w io.Writer) {
+		fmt.Fprintf(w, "path=%s version=%d size=%d children=%d ephemeral=%v",
+			s.Path, s.Version, s.Size, s.Children, s.Ephemeral)
+		if s.Owner != "" {
+			fmt.Fprintf(w, " owner=%s", s.Owner)
+		}
+		if !s.Created.IsZero() {
+			fmt.Fprintf(w, " created=%s", s.Created.Format(time.RFC3339))
+		}
+		if !s.Modified.IsZero() {
+			fmt.Fprintf(w, " modified=%s", s.Modified.Format(time.RFC3339))
+		}
+		fmt.Fprintln(w)
+	})
+}
+
+var snapshotTypes = map[string]encoding.ContentType{
+	FormatJSON: encoding.ContentTypeJSON,
+	FormatYAML: encoding.ContentTypeYAML,
+}
+
+// Snapshots are json in text.
+func (this *Module) snapshotType() encoding.ContentType {
+	if this.Format == FormatYAML {
+		return encoding.ContentTypeYAML
+	}
+	return encoding.ContentTypeJSON
+}
+
+func (this *Module) export(fs *flag.FlagSet, args []string, w io.Writer) error {
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	if err := namespace.ExportSnapshot(reg, p, this.snapshotType(), w); err != nil {
+		return err
+	}
+	if this.Format != FormatYAML {
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+var policies = map[string]namespace.ConflictPolicy{
+	"overwrite": namespace.ConflictOverwrite,
+	"skip":      namespace.ConflictSkip,
+	"version":   namespace.ConflictFailOnVersionMismatch,
+}
+
+func (this *Module) restore(fs *flag.FlagSet, args []string, w io.Writer) error {
+	policyName := fs.String("policy", "overwrite", "What to do with existing nodes: overwrite, skip or version")
+	typeName := fs.String("type", FormatJSON, "Format of the snapshot: json or yaml")
+	reg, p, _, err := this.dial(fs, args)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+	policy, has := policies[*policyName]
+	contentType, known := snapshotTypes[*typeName]
+	if !has || !known {
+		return ErrUsage
+	}
+	written, err := namespace.ImportSnapshot(reg, p, contentType, this.in, policy)
+	if err != nil {
+		return err
+	}
+	paths := []string{}
+	for _, p := range written {
+		paths = append(paths, p.String())
+	}
+	return this.output(w, paths, func(w io.Writer) {
+		for _, p := range paths {
+			fmt.Fprintln(w, p)
+		}
+	})
+}
+
+type resolution struct {
+	Url     string            `json:"url" yaml:"url"`
+	Value   string            `json:"value" yaml:"value"`
+	Version namespace.Version `json:"version" yaml:"version"`
+	Chain   []string          `json:"chain" yaml:"chain"`
+}
+
+func (this *Module) follow(fs *flag.FlagSet, args []string, w io.Writer) error {
+	maxHops := fs.Int("max-hops", namespace.DefaultMaxHops, "Maximum number of links to follow")
+	linksOnly := fs.Bool("links-only", false, "Does not follow values that are just urls")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return ErrUsage
+	}
+	u, err := net.Parse(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	r, err := namespace.Resolve(this.ctx, *u, namespace.FollowOptions{MaxHops: *maxHops, LinksOnly: *linksOnly})
+	if err != nil {
+		return err
+	}
+	result := resolution{Url: r.Url.String(), Value: string(r.Value), Version: r.Version, Chain: []string{}}
+	for _, hop := range r.Chain {
+		result.Chain = append(result.Chain, hop.String())
+	}
+	return this.output(w, result, func(w io.Writer) {
+		for i, hop := range result.Chain {
+			if i > 0 {
+				fmt.Fprint(w, " -> ")
+			}
+			fmt.Fprint(w, hop)
+		}
+		fmt.Fprintln(w)
+		writeText(w, r.Value)
+	})
+}
+
+type difference struct {
+	Kind     string `json:"kind" yaml:"kind"`
+	Path     string `json:"path" yaml:"path"`
+	Conflict bool   `json:"conflict,omitempty" yaml:"conflict,omitempty"`
+	Applied  bool   `json:"applied,omitempty" yaml:"applied,omitempty"`
+	Error    string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func toDifference(d mirror.Difference) difference {
+	out := difference{Kind: d.Kind.String(), Path: d.Path.String(), Conflict: d.Conflict, Applied: d.Applied}
+	if d.Err != nil {
+		out.Error = d.Err.Error()
+	}
+	return out
+}
+
+// Text of a difference, in the style of diff: + for added, ~ for changed and - for deleted.
+func writeDifference(w io.Writer, d mirror.Difference) {
+	mark := map[mirror.DiffKind]string{mirror.Added: "+", mirror.Changed: "~", mirror.Deleted: "-"}[d.Kind]
+	switch {
+	case d.Err != nil:
+		fmt.Fprintln(w, "!", d.Path, d.Err)
+	case d.Conflict && !d.Applied:
+		fmt.Fprintln(w, mark, d.Path, "(conflict, skipped)")
+	case d.Conflict:
+		fmt.Fprintln(w, mark, d.Path, "(conflict)")
+	default:
+		fmt.Fprintln(w, mark, d.Path)
+	}
+}
+
+// Parses the flags of the sub-command and dials the source and target urls, which must be the
+// remaining args.
+func (this *Module) dialPair(fs *flag.FlagSet, args []string) (src, dst namespace.Registry,
+	srcPath, dstPath namespace.Path, err error) {
+	src, srcPath, rest, err := this.dial(fs, args)
+	if err != nil {
+		return
+	}
+	if len(rest) != 1 {
+		src.Close()
+		return nil, nil, nil, nil, ErrUsage
+	}
+	u, err := net.Parse(rest[0])
+	if err != nil {
+		src.Close()
+		return nil, nil, nil, nil, err
+	}
+	if dst, err = namespace.Dial(this.ctx, rest[0]); err != nil {
+		src.Close()
+		return nil, nil, nil, nil, err
+	}
+	return src, dst, srcPath, namespace.NewPath(u.Path), nil
+}
+
+// The filter of the comma separated patterns, or nil for none.
+func excluding(patterns string) mirror.Filter {
+	if patterns == "" {
+		return nil
+	}
+	return mirror.Exclude(strings.Split(patterns, ",")...)
+}
+
+func (this *Module) diff(fs *flag.FlagSet, args []string, w io.Writer) error {
+	exclude := fs.String("exclude", "", "Comma separated patterns of the relative paths to leave out, e.g. /locks/*")
+	src, dst, srcPath, dstPath, err := this.dialPair(fs, args)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+	diffs, err := mirror.Diff(src, srcPath, dst, dstPath, excluding(*exclude))
+	if err != nil {
+		return err
+	}
+	list := []difference{}
+	for _, d := range diffs {
+		list = append(list, toDifference(d))
+	}
+	return this.output(w, list, func(w io.Writer) {
+		for _, d := range diffs {
+			writeDifference(w, d)
+		}
+	})
+}
+
+func (this *Module) mirror(fs *flag.FlagSet, args []string, w io.Writer) error {
+	once := fs.Bool("once", false, "Syncs once and exits")
+	count := fs.Int("n", 0, "Exits after this many differences; 0 to mirror until interrupted")
+	policyName := fs.String("policy", "overwrite", "What to do with target nodes changed by others: overwrite, skip or version")
+	exclude := fs.String("exclude", "", "Comma separated patterns of the relative paths to leave out, e.g. /locks/*")
+	skipEphemeral := fs.Bool("skip-ephemeral", false, "Leaves out the ephemeral nodes of the source")
+	src, dst, srcPath, dstPath, err := this.dialPair(fs, args)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	defer dst.Close()
+	policy, has := policies[*policyName]
+	if !has {
+		return ErrUsage
+	}
+	m := mirror.New(src, srcPath, dst, dstPath, mirror.MirrorOptions{
+		Filter:        excluding(*exclude),
+		Policy:        policy,
+		SkipEphemeral: *skipEphemeral,
+	})
+	write := func(d mirror.Difference) error {
+		return this.output(w, toDifference(d), func(w io.Writer) { writeDifference(w, d) })
+	}
+	if *once {
+		diffs, err := m.Sync()
+		for _, d := range diffs {
+			if err := write(d); err != nil {
+				return err
+			}
+		}
+		return err
+	}
+
+	ctx, stop := context.WithCancel(this.ctx)
+	defer stop()
+	diffs, err := m.Start(ctx)
+	if err != nil {
+		return err
+	}
+	for seen := 0; *count == 0 || seen < *count; seen++ {
+		d, open := <-diffs
+		if !open {
+			return ctx.Err()
+		}
+		if err := write(d); err != nil {
+			return err
+		}
+		if d.Err != nil {
+			return d.Err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package ns
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/conductant/gohm/pkg/command"
+	"github.com/conductant/gohm/pkg/encoding"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	"io"
+	net "net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var (
+	ErrUsage          = errors.New("error-usage")
+	ErrUnknownCommand = errors.New("error-unknown-command")
+	ErrBadFormat      = errors.New("error-bad-format")
+)
+
+func init() {
+	command.Register("ns", func() (command.Module, command.ErrorHandling) {
+		return New(), command.ExitOnError
+	})
+}
+
+// The ns module browses and edits the nodes of any registry that can be dialed, e.g.
+//
+//	ns get zk://localhost:2181/services/web
+//	ns -o json ls -R consul://localhost:8500/services
+//
+// The programs using it must import the backends to support, e.g. import _ "github.com/conductant/gohm/pkg/zk".
+type Module struct {
+	Format string `flag:"o,Output format: text, json or yaml"`
+
+	ctx context.Context
+	in  io.Reader // read by put and import
+}
+
+func New() *Module {
+	return &Module{Format: FormatText, ctx: context.Background(), in: os.Stdin}
+}
+
+// A sub-command.  The args are the ones after the name of the sub-command.
+type subcommand struct {
+	usage string
+	run   func(this *Module, fs *flag.FlagSet, args []string, w io.Writer) error
+}
+
+var subcommands = map[string]subcommand{}
+
+func (this *Module) Help(w io.Writer) {
+	fmt.Fprintln(w, "Browses and edits registries.  Usage: ns [-o text|json|yaml] <command> [flags] <url> ...")
+	names := []string{}
+	for name, _ := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, subcommands[name].usage)
+	}
+}
+
+func (this *Module) Close() error {
+	return nil
+}
+
+func (this *Module) Run(args []string, w io.Writer) error {
+	switch this.Format {
+	case FormatText, FormatJSON, FormatYAML:
+	default:
+		return ErrBadFormat
+	}
+	if len(args) == 0 {
+		this.Help(w)
+		return ErrUsage
+	}
+	sub, has := subcommands[args[0]]
+	if !has {
+		return ErrUnknownCommand
+	}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(w)
+	return sub.run(this, fs, args[1:], w)
+}
+
+// Parses the flags of the sub-command and returns the registry and path of the url, which must be
+// the first of the remaining args.
+func (this *Module) dial(fs *flag.FlagSet, args []string) (namespace.Registry, namespace.Path, []string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, nil, err
+	}
+	if fs.NArg() == 0 {
+		return nil, nil, nil, ErrUsage
+	}
+	url := fs.Arg(0)
+	u, err := net.Parse(url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	reg, err := namespace.Dial(this.ctx, url)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return reg, namespace.NewPath(u.Path), fs.Args()[1:], nil
+}
+
+// Writes the value in the output format.  For text, the text function writes it instead.
+func (this *Module) output(w io.Writer, value interface{}, text func(io.Writer)) error {
+	switch this.Format {
+	case FormatJSON:
+		if err := encoding.Marshal(encoding.ContentTypeJSON, w, value); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	case FormatYAML:
+		return encoding.Marshal(encoding.ContentTypeYAML, w, value)
+	default:
+		text(w)
+	}
+	return nil
+}
+
+// Values are written as is in text, followed by a new line if they do not end with one.
+func writeText(w io.Writer, value []byte) {
+	w.Write(value)
+	if !strings.HasSuffix(string(value), "\n") {
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,231 @@
+package ns
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNs(t *testing.T) { TestingT(t) }
+
+type TestSuiteNs struct{}
+
+var _ = Suite(&TestSuiteNs{})
+
+func (suite *TestSuiteNs) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteNs) TearDownSuite(c *C) {
+}
+
+// Runs the sub-command and returns what it wrote.
+func run(c *C, format, in string, args ...string) (string, error) {
+	m := New()
+	m.Format = format
+	m.in = strings.NewReader(in)
+	var buff bytes.Buffer
+	err := m.Run(args, &buff)
+	return buff.String(), err
+}
+
+func registry(c *C, host string) namespace.Registry {
+	reg, err := mem.NewService(context.Background(), net.URL{Scheme: "mem", Host: host}, nil)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+func (suite *TestSuiteNs) TestUsage(c *C) {
+	_, err := run(c, FormatText, "")
+	c.Assert(err, Equals, ErrUsage)
+	_, err = run(c, FormatText, "", "bogus", "mem://usage/a")
+	c.Assert(err, Equals, ErrUnknownCommand)
+	_, err = run(c, "xml", "", "get", "mem://usage/a")
+	c.Assert(err, Equals, ErrBadFormat)
+	_, err = run(c, FormatText, "", "get")
+	c.Assert(err, Equals, ErrUsage)
+}
+
+func (suite *TestSuiteNs) TestGetPut(c *C) {
+	out, err := run(c, FormatText, "", "put", "mem://getput/a/b", "hello")
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(out, "/a/b "), Equals, true)
+
+	out, err = run(c, FormatText, "from stdin", "put", "mem://getput/a/c")
+	c.Assert(err, IsNil)
+
+	out, err = run(c, FormatText, "", "get", "mem://getput/a/b")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "hello\n")
+
+	out, err = run(c, FormatJSON, "", "get", "mem://getput/a/c")
+	c.Assert(err, IsNil)
+	got := node{}
+	c.Assert(json.Unmarshal([]byte(out), &got), IsNil)
+	c.Assert(got.Path, Equals, "/a/c")
+	c.Assert(got.Value, Equals, "from stdin")
+
+	out, err = run(c, FormatYAML, "", "get", "mem://getput/a/b")
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(out, "value: hello"), Equals, true)
+
+	_, err = run(c, FormatText, "", "get", "mem://getput/missing")
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
+
+func (suite *TestSuiteNs) TestLsRm(c *C) {
+	reg := registry(c, "lsrm")
+	defer reg.Close()
+	for _, p := range []string{"/a/b", "/a/b/c", "/a/d"} {
+		_, err := reg.Put(namespace.NewPath(p), []byte(p), false)
+		c.Assert(err, IsNil)
+	}
+
+	out, err := run(c, FormatText, "", "ls", "mem://lsrm/a")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "/a/b\n/a/d\n")
+
+	out, err = run(c, FormatText, "", "ls", "-R", "mem://lsrm/a")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "/a/b\n/a/b/c\n/a/d\n")
+
+	out, err = run(c, FormatJSON, "", "ls", "-R", "mem://lsrm/a")
+	c.Assert(err, IsNil)
+	paths := []string{}
+	c.Assert(json.Unmarshal([]byte(out), &paths), IsNil)
+	c.Assert(paths, DeepEquals, []string{"/a/b", "/a/b/c", "/a/d"})
+
+	out, err = run(c, FormatText, "", "rm", "-r", "mem://lsrm/a")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "/a/b/c\n/a/b\n/a/d\n/a\n")
+
+	exists, err := reg.Exists(namespace.NewPath("/a"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteNs) TestStat(c *C) {
+	reg := registry(c, "stat")
+	defer reg.Close()
+	_, err := reg.Put(namespace.NewPath("/a"), []byte("12345"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(namespace.NewPath("/a/b"), []byte{}, true)
+	c.Assert(err, IsNil)
+
+	out, err := run(c, FormatJSON, "", "stat", "mem://stat/a")
+	c.Assert(err, IsNil)
+	s := stat{}
+	c.Assert(json.Unmarshal([]byte(out), &s), IsNil)
+	c.Assert(s.Size, Equals, 5)
+	c.Assert(s.Children, Equals, 1)
+	c.Assert(s.Ephemeral, Equals, false)
+	c.Assert(s.Modified.IsZero(), Equals, false)
+}
+
+func (suite *TestSuiteNs) TestDiffMirror(c *C) {
+	src, dst := registry(c, "mirror-src"), registry(c, "mirror-dst")
+	defer src.Close()
+	defer dst.Close()
+	_, err := src.Put(namespace.NewPath("/prod/a"), []byte("a"), false)
+	c.Assert(err, IsNil)
+	_, err = src.Put(namespace.NewPath("/prod/locks/x"), []byte("x"), false)
+	c.Assert(err, IsNil)
+	_, err = dst.Put(namespace.NewPath("/staging/b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+
+	out, err := run(c, FormatText, "", "diff", "-exclude", "/locks", "mem://mirror-src/prod", "mem://mirror-dst/staging")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "+ /a\n- /b\n")
+
+	out, err = run(c, FormatJSON, "", "mirror", "-once", "-exclude", "/locks",
+		"mem://mirror-src/prod", "mem://mirror-dst/staging")
+	c.Assert(err, IsNil)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	c.Assert(len(lines), Equals, 2)
+	d := difference{}
+	c.Assert(json.Unmarshal([]byte(lines[1]), &d), IsNil)
+	c.Assert(d, DeepEquals, difference{Kind: "deleted", Path: "/b", Conflict: true, Applied: true})
+
+	value, _, err := dst.Get(namespace.NewPath("/staging/a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "a")
+	out, err = run(c, FormatText, "", "diff", "-exclude", "/locks", "mem://mirror-src/prod", "mem://mirror-dst/staging")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "")
+
+	_, err = run(c, FormatText, "", "diff", "mem://mirror-src/prod")
+	c.Assert(err, Equals, ErrUsage)
+}
+
+func (suite *TestSuiteNs) TestExportImport(c *C) {
+	reg := registry(c, "export")
+	defer reg.Close()
+	_, err := reg.Put(namespace.NewPath("/a/b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(namespace.NewPath("/a/c"), []byte("c"), false)
+	c.Assert(err, IsNil)
+
+	for _, format := range []string{FormatJSON, FormatYAML} {
+		snapshot, err := run(c, format, "", "export", "mem://export/a")
+		c.Assert(err, IsNil)
+
+		out, err := run(c, FormatText, snapshot, "import", "-type", format, "mem://import-"+format+"/x")
+		c.Assert(err, IsNil)
+		c.Assert(strings.Contains(out, "/x/b\n"), Equals, true)
+
+		value, err := run(c, FormatText, "", "get", "mem://import-"+format+"/x/c")
+		c.Assert(err, IsNil)
+		c.Assert(value, Equals, "c\n")
+	}
+
+	_, err = run(c, FormatText, "", "import", "-policy", "bogus", "mem://import/x")
+	c.Assert(err, Equals, ErrUsage)
+}
+
+func (suite *TestSuiteNs) TestFollow(c *C) {
+	reg := registry(c, "follow")
+	defer reg.Close()
+	_, err := reg.Put(namespace.NewPath("/target"), []byte("here"), false)
+	c.Assert(err, IsNil)
+	_, err = namespace.Link(reg, namespace.NewPath("/link"), "/target")
+	c.Assert(err, IsNil)
+
+	out, err := run(c, FormatJSON, "", "follow", "mem://follow/link")
+	c.Assert(err, IsNil)
+	r := resolution{}
+	c.Assert(json.Unmarshal([]byte(out), &r), IsNil)
+	c.Assert(r.Value, Equals, "here")
+	c.Assert(len(r.Chain), Equals, 2)
+}
+
+func (suite *TestSuiteNs) TestWatch(c *C) {
+	reg := registry(c, "watch")
+	defer reg.Close()
+	p := namespace.NewPath("/w")
+	_, err := reg.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+
+	done := make(chan string, 1)
+	go func() {
+		out, err := run(c, FormatText, "", "watch", "-n", "1", "mem://watch/w")
+		c.Check(err, IsNil)
+		done <- out
+	}()
+	// Changes until the watch, set up in the background, sees one.
+	for {
+		_, err := reg.Put(p, []byte("2"), false)
+		c.Assert(err, IsNil)
+		select {
+		case out := <-done:
+			c.Assert(strings.HasPrefix(out, "change /w "), Equals, true)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package nsgw
+
+import (
+	"errors"
+	"fmt"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"net/http"
+)
+
+var (
+	ErrUnknownSession = errors.New("error-unknown-session")
+)
+
+type UnknownOp struct {
+	Kind string
+}
+
+func (this *UnknownOp) Error() string {
+	return fmt.Sprintf("unknown-op: %s", this.Kind)
+}
+
+// Returned by the client for responses of the gateway that do not map to a registry error.
+type UnexpectedStatus struct {
+	Status int
+	Body   string
+}
+
+func (this *UnexpectedStatus) Error() string {
+	return fmt.Sprintf("nsgw-unexpected-status: %d %s", this.Status, this.Body)
+}
+
+// The registry errors that go through the gateway as is, and their http status.
+var statuses = map[error]int{
+	ErrNotExist:                http.StatusNotFound,
+	ErrNodeExists:              http.StatusConflict,
+	ErrBadVersion:              http.StatusPreconditionFailed,
+	ErrNotEmpty:                http.StatusConflict,
+	ErrNoChildrenForEphemerals: http.StatusConflict,
+	ErrRolledBack:              http.StatusConflict,
+	ErrReadOnly:                http.StatusForbidden,
+	ErrClosed:                  http.StatusServiceUnavailable,
+	ErrBadPattern:              http.StatusBadRequest,
+	ErrUnknownSession:          http.StatusGone,
+	ErrBadTrigger:              http.StatusBadRequest,
+}
+
+func statusOf(err error) int {
+	if status, has := statuses[err]; has {
+		return status
+	}
+	if _, is := err.(*UnknownOp); is {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// Returns the registry error with the message, if it's one of them.
+func knownError(message string) (error, bool) {
+	for err, _ := range statuses {
+		if err.Error() == message {
+			return err, true
+		}
+	}
+	return nil, false
+}
+
+// Returns the registry error with the message, or a new error if it's not one of them.
+func toError(message string) error {
+	if err, known := knownError(message); known {
+		return err
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,395 @@
+package nsgw
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/server"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	net "net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSessionTtl = 30 * time.Second
+)
+
+// A gateway fronts a registry over http, for processes that cannot reach the backend directly.  The
+// nodes are under /ns/, e.g. GET /ns/services/web returns the value of /services/web, with its
+// version in the X-Namespace-Version header.  See the nsgw registry for the client.
+//
+// Ephemeral nodes belong to sessions that the clients keep alive.  When a session expires or is
+// closed, the gateway deletes its ephemeral nodes.
+type Gateway struct {
+	// Scopes of the auth token required to read / watch and to write.  Default is AuthScopeNone.
+	ReadScope  server.AuthScope
+	WriteScope server.AuthScope
+
+	// Sessions not renewed within this time expire.  Set before serving.
+	SessionTtl time.Duration
+
+	reg      Registry
+	ctx      context.Context
+	lock     sync.Mutex
+	sessions map[string]*clientSession
+	reaping  bool
+	streams  map[string]*stream
+	streamed int // count of the triggers set, to name their sse channels
+}
+
+// A trigger on the registry, shared by the clients watching it.  It is stopped when the last of them
+// goes away.
+type stream struct {
+	channel  string // key of the sse channel, unique to the trigger
+	source   chan interface{}
+	cancel   context.CancelFunc
+	watchers int
+}
+
+// A route of the gateway, to add to a service with Route(r.Endpoint).To(r.Handler).
+type Route struct {
+	Endpoint server.Endpoint
+	Handler  server.Handler
+}
+
+// The watches of the registry are stopped when the context is done.
+func NewGateway(ctx context.Context, reg Registry) *Gateway {
+	return &Gateway{
+		ReadScope:  server.AuthScopeNone,
+		WriteScope: server.AuthScopeNone,
+		SessionTtl: DefaultSessionTtl,
+		reg:        reg,
+		ctx:        ctx,
+		sessions:   map[string]*clientSession{},
+		streams:    map[string]*stream{},
+	}
+}
+
+func (this *Gateway) Routes() []Route {
+	api := func(route string, method server.HttpMethod, scope server.AuthScope, doc string) server.Endpoint {
+		return server.Endpoint{UrlRoute: route, HttpMethod: method, AuthScope: scope, Doc: doc}
+	}
+	return []Route{
+		{api("/ns/{path:.*}", server.GET, this.ReadScope,
+			"Value of the node, or its children with list=true"), this.get},
+		{api("/ns/{path:.*}", server.HEAD, this.ReadScope, "Checks the node exists"), this.head},
+		{api("/ns/{path:.*}", server.PUT, this.WriteScope,
+			"Sets the value of the node, at the version given in the header if any"), this.put},
+		{api("/ns/{path:.*}", server.DELETE, this.WriteScope,
+			"Deletes the node, at the version given in the header if any"), this.delete},
+		{api("/stat/{path:.*}", server.GET, this.ReadScope, "Metadata of the node"), this.stat},
+		{api("/glob", server.GET, this.ReadScope, "Paths of the nodes matching the pattern parameter"), this.glob},
+		{api("/txn", server.POST, this.WriteScope, "Applies all or none of the operations"), this.txn},
+		{api("/watch/{path:.*}", server.GET, this.ReadScope,
+			"Stream of the events of the trigger: kind=create|change|delete|members, min, max, delta, outside"),
+			this.watch},
+		{api("/sessions", server.POST, this.WriteScope, "Starts a session for ephemeral nodes"), this.createSession},
+		{api("/sessions/{id}", server.PUT, this.WriteScope, "Renews the session"), this.renewSession},
+		{api("/sessions/{id}", server.DELETE, this.WriteScope,
+			"Closes the session and deletes its ephemeral nodes"), this.closeSession},
+	}
+}
+
+// Builds a server with the routes of the gateway only.
+func (this *Gateway) Build(auth server.AuthManager) server.Server {
+	service := server.NewService().WithAuth(auth)
+	for _, r := range this.Routes() {
+		service.Route(r.Endpoint).To(r.Handler)
+	}
+	return service.Build()
+}
+
+func pathOf(req *http.Request) Path {
+	return NewPath(server.GetUrlParameter(req, "path"))
+}
+
+// The version in the header, or InvalidVersion if there is none.
+func versionOf(req *http.Request) (Version, bool, error) {
+	v := req.Header.Get(VersionHeader)
+	if v == "" {
+		return InvalidVersion, false, nil
+	}
+	i, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return InvalidVersion, false, err
+	}
+	return Version(i), true, nil
+}
+
+func fail(resp http.ResponseWriter, err error, index int) {
+	resp.Header().Set("Content-Type", "application/json")
+	resp.WriteHeader(statusOf(err))
+	json.NewEncoder(resp).Encode(failure{Error: err.Error(), Index: index})
+}
+
+func reply(resp http.ResponseWriter, value interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(value)
+}
+
+func replyVersion(resp http.ResponseWriter, p Path, version Version) {
+	resp.Header().Set(VersionHeader, strconv.FormatInt(int64(version), 10))
+	reply(resp, node{Path: p.String(), Version: version})
+}
+
+func (this *Gateway) get(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	p := pathOf(req)
+	if list, _ := strconv.ParseBool(req.URL.Query().Get("list")); list {
+		children, err := this.reg.List(p)
+		if err != nil {
+			fail(resp, err, -1)
+			return
+		}
+		paths := []string{}
+		for _, c := range children {
+			paths = append(paths, c.String())
+		}
+		reply(resp, paths)
+		return
+	}
+	value, version, err := this.reg.Get(p)
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/octet-stream")
+	resp.Header().Set(VersionHeader, strconv.FormatInt(int64(version), 10))
+	resp.Write(value)
+}
+
+func (this *Gateway) head(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	exists, err := this.reg.Exists(pathOf(req))
+	switch {
+	case err != nil:
+		resp.WriteHeader(statusOf(err))
+	case !exists:
+		resp.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (this *Gateway) stat(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	stat, err := this.reg.Stat(pathOf(req))
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	reply(resp, stat)
+}
+
+func (this *Gateway) glob(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	matched, err := Glob(this.reg, req.URL.Query().Get("pattern"))
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	paths := []string{}
+	for _, m := range matched {
+		paths = append(paths, m.String())
+	}
+	reply(resp, paths)
+}
+
+func (this *Gateway) put(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	p := pathOf(req)
+	version, cas, err := versionOf(req)
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	ephemeral, _ := strconv.ParseBool(req.URL.Query().Get("ephemeral"))
+	var s *clientSession
+	if ephemeral {
+		if s, err = this.session(req.Header.Get(SessionHeader)); err != nil {
+			fail(resp, err, -1)
+			return
+		}
+	}
+	value, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	if cas {
+		version, err = this.reg.PutVersion(p, value, version)
+	} else {
+		version, err = this.reg.Put(p, value, ephemeral)
+	}
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	if s != nil {
+		s.add(p)
+	}
+	replyVersion(resp, p, version)
+}
+
+func (this *Gateway) delete(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	version, _, err := versionOf(req)
+	if err == nil {
+		err = this.reg.DeleteVersion(pathOf(req), version)
+	}
+	if err != nil {
+		fail(resp, err, -1)
+	}
+}
+
+func (this *Gateway) txn(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	posted := []op{}
+	if err := json.NewDecoder(req.Body).Decode(&posted); err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	ops := []Op{}
+	var s *clientSession
+	for i, o := range posted {
+		op, err := o.toOp()
+		if err != nil {
+			fail(resp, err, i)
+			return
+		}
+		if o.Ephemeral && s == nil {
+			if s, err = this.session(req.Header.Get(SessionHeader)); err != nil {
+				fail(resp, err, i)
+				return
+			}
+		}
+		ops = append(ops, op)
+	}
+	results, err := this.reg.Txn(ops...)
+	if txnErr, is := err.(*TxnError); is {
+		fail(resp, txnErr.Err, txnErr.Index)
+		return
+	} else if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	replied := []opResult{}
+	for i, r := range results {
+		replied = append(replied, opResult{Path: r.Path.String(), Version: r.Version})
+		if posted[i].Ephemeral {
+			s.add(r.Path)
+		}
+	}
+	reply(resp, replied)
+}
+
+// Parses the trigger of a watch request.  Returns the canonical query, for sharing the stream.
+func triggerOf(req *http.Request) (Trigger, string, error) {
+	p := pathOf(req)
+	query := req.URL.Query()
+	canonical := net.Values{"kind": []string{query.Get("kind")}}
+	switch query.Get("kind") {
+	case "create":
+		return Create{Path: p}, canonical.Encode(), nil
+	case "change":
+		return Change{Path: p}, canonical.Encode(), nil
+	case "delete":
+		return Delete{Path: p}, canonical.Encode(), nil
+	case "members":
+		members := &Members{Path: p}
+		for _, param := range []struct {
+			name string
+			set  func(int) *Members
+		}{
+			{"min", members.SetMin}, {"max", members.SetMax}, {"delta", members.SetDelta},
+		} {
+			if v := query.Get(param.name); v != "" {
+				i, err := strconv.Atoi(v)
+				if err != nil {
+					return nil, "", ErrBadTrigger
+				}
+				param.set(i)
+				canonical.Set(param.name, v)
+			}
+		}
+		if outside, _ := strconv.ParseBool(query.Get("outside")); outside {
+			members.SetOutsideRange(true)
+			canonical.Set("outside", "true")
+		}
+		return *members, canonical.Encode(), nil
+	}
+	return nil, "", ErrBadTrigger
+}
+
+// Streams the events of the trigger as server sent events.  Clients watching the same trigger share
+// the trigger on the registry.
+func (this *Gateway) watch(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	t, query, err := triggerOf(req)
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	key := "nsgw:" + pathOf(req).String() + "?" + query
+	s, err := this.stream(key, t)
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	defer this.release(key, s)
+	streamer := server.ContextGetStreamer(ctx)
+	if err := streamer.MergeHttpStream(resp, req, "application/json", StreamEventType, s.channel, s.source); err != nil {
+		glog.Warningln("Cannot stream", key, "err=", err)
+	}
+}
+
+// Returns the stream of the trigger, setting the trigger if it's not set yet.  The caller watches the
+// stream until release.  The source is closed, and the stream forgotten, when the trigger stops.
+func (this *Gateway) stream(key string, t Trigger) (*stream, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if s, has := this.streams[key]; has {
+		s.watchers++
+		return s, nil
+	}
+	ctx, cancel := context.WithCancel(this.ctx)
+	events, err := this.reg.Trigger(ctx, t)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// A trigger being stopped may still have its channel, so each trigger gets its own.
+	this.streamed++
+	s := &stream{
+		channel:  fmt.Sprintf("%s#%d", key, this.streamed),
+		source:   make(chan interface{}),
+		cancel:   cancel,
+		watchers: 1,
+	}
+	this.streams[key] = s
+	go func() {
+		for e := range events {
+			select {
+			case s.source <- toEvent(e):
+			case <-ctx.Done():
+			}
+		}
+		this.lock.Lock()
+		if this.streams[key] == s {
+			delete(this.streams, key)
+		}
+		this.lock.Unlock()
+		cancel()
+		close(s.source)
+	}()
+	return s, nil
+}
+
+// Stops the trigger when its last watcher goes.  The sse channel stops when the source is closed.
+func (this *Gateway) release(key string, s *stream) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	s.watchers--
+	if s.watchers > 0 {
+		return
+	}
+	if this.streams[key] == s {
+		delete(this.streams, key)
+	}
+	s.cancel()
+}
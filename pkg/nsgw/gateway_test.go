@@ -0,0 +1,373 @@
+package nsgw
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/conductant/gohm/pkg/auth"
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/server"
+	"github.com/conductant/gohm/pkg/testutil"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"net/http"
+	"net/http/httptest"
+	net "net/url"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) { TestingT(t) }
+
+type TestSuiteGateway struct {
+	backend namespace.Registry
+	gateway *Gateway
+	server  *httptest.Server
+	stop    context.CancelFunc
+	url     string // of the gateway, with a token for reads and writes
+}
+
+var _ = Suite(&TestSuiteGateway{})
+
+func token(c *C, scopes ...string) string {
+	t := auth.NewToken(1 * time.Hour)
+	for _, s := range scopes {
+		t.Add(s, true)
+	}
+	signed, err := t.SignedString(testutil.PrivateKeyFunc)
+	c.Assert(err, IsNil)
+	return signed
+}
+
+func (suite *TestSuiteGateway) SetUpSuite(c *C) {
+	var err error
+	suite.backend, err = mem.NewService(context.Background(), net.URL{Scheme: "mem", Host: "nsgw"}, nil)
+	c.Assert(err, IsNil)
+
+	var ctx context.Context
+	ctx, suite.stop = context.WithCancel(context.Background())
+	gw := NewGateway(ctx, suite.backend)
+	gw.ReadScope = "ns-read"
+	gw.WriteScope = "ns-write"
+	gw.SessionTtl = 300 * time.Millisecond
+	suite.gateway = gw
+	suite.server = httptest.NewServer(gw.Build(server.Auth{VerifyKeyFunc: testutil.PublicKeyFunc}.Init()))
+	u, err := net.Parse(suite.server.URL)
+	c.Assert(err, IsNil)
+	suite.url = "nsgw://" + u.Host + "/?token=" + token(c, "ns-read", "ns-write")
+}
+
+func (suite *TestSuiteGateway) TearDownSuite(c *C) {
+	suite.stop()
+	suite.server.Close()
+	suite.backend.Close()
+}
+
+func (suite *TestSuiteGateway) dial(c *C) namespace.Registry {
+	reg, err := namespace.Dial(context.Background(), suite.url)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+func (suite *TestSuiteGateway) TestGetPutDelete(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/crud/a/b")
+	_, _, err := reg.Get(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	v1, err := reg.Put(p, []byte("one"), false)
+	c.Assert(err, IsNil)
+	value, version, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "one")
+	c.Assert(version, Equals, v1)
+
+	// Same as going to the backend directly.
+	value, version, err = suite.backend.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "one")
+	c.Assert(version, Equals, v1)
+
+	v2, err := reg.PutVersion(p, []byte("two"), v1)
+	c.Assert(err, IsNil)
+	_, err = reg.PutVersion(p, []byte("three"), v1)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
+
+	exists, err := reg.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+	children, err := reg.List(namespace.NewPath("/crud/a"))
+	c.Assert(err, IsNil)
+	c.Assert(children, DeepEquals, []namespace.Path{p})
+
+	c.Assert(reg.Delete(p.Dir()), Equals, namespace.ErrNotEmpty)
+	c.Assert(reg.DeleteVersion(p, v1), Equals, namespace.ErrBadVersion)
+	c.Assert(reg.DeleteVersion(p, v2), IsNil)
+	exists, err = reg.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteGateway) TestEscapedNames(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/escaped")
+	names := []string{"a?b=1", "c#d", "e%20f", "g h"}
+	for _, name := range names {
+		_, err := reg.Put(p.Sub(name), []byte(name), false)
+		c.Assert(err, IsNil)
+	}
+	for _, name := range names {
+		value, _, err := suite.backend.Get(p.Sub(name))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, name)
+		value, _, err = reg.Get(p.Sub(name))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, name)
+	}
+	children, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(len(children), Equals, len(names))
+	for _, name := range names {
+		c.Assert(reg.Delete(p.Sub(name)), IsNil)
+	}
+}
+
+func (suite *TestSuiteGateway) TestStat(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/stat/a")
+	_, err := reg.Stat(p)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	_, err = reg.Put(p, []byte("12345"), false)
+	c.Assert(err, IsNil)
+	_, err = reg.Put(p.Sub("b"), []byte{}, true)
+	c.Assert(err, IsNil)
+	stat, err := reg.Stat(p)
+	c.Assert(err, IsNil)
+	expected, err := suite.backend.Stat(p)
+	c.Assert(err, IsNil)
+	c.Assert(stat.Size, Equals, 5)
+	c.Assert(stat.Children, Equals, 1)
+	c.Assert(stat.Version, Equals, expected.Version)
+	c.Assert(stat.Modified.Equal(expected.Modified), Equals, true)
+
+	ephemeral, err := reg.Stat(p.Sub("b"))
+	c.Assert(err, IsNil)
+	c.Assert(ephemeral.Ephemeral, Equals, true)
+}
+
+func (suite *TestSuiteGateway) TestGlob(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	for _, p := range []string{"/glob/web/instances/1", "/glob/web/instances/2", "/glob/db/instances/1"} {
+		_, err := reg.Put(namespace.NewPath(p), []byte{}, false)
+		c.Assert(err, IsNil)
+	}
+	matched, err := namespace.Glob(reg, "/glob/*/instances/1")
+	c.Assert(err, IsNil)
+	c.Assert(matched, DeepEquals, []namespace.Path{
+		namespace.NewPath("/glob/db/instances/1"), namespace.NewPath("/glob/web/instances/1"),
+	})
+	_, err = namespace.Glob(reg, "/glob/[")
+	c.Assert(err, Equals, namespace.ErrBadPattern)
+}
+
+func (suite *TestSuiteGateway) TestTxn(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	parent := namespace.NewPath("/txn")
+	results, err := reg.Txn(
+		namespace.OpCreate{Path: parent, Value: []byte{}},
+		namespace.OpCreate{Path: parent.Sub("seq-"), Value: []byte("x"), Sequential: true},
+	)
+	c.Assert(err, IsNil)
+	c.Assert(len(results), Equals, 2)
+	seq, is := namespace.SequenceOf(results[1].Path)
+	c.Assert(is, Equals, true)
+	c.Assert(seq >= 0, Equals, true)
+
+	results, err = reg.Txn(
+		namespace.OpCreate{Path: parent.Sub("new"), Value: []byte{}},
+		namespace.OpCreate{Path: parent, Value: []byte{}},
+	)
+	c.Assert(err, Not(IsNil))
+	txnErr, is := err.(*namespace.TxnError)
+	c.Assert(is, Equals, true)
+	c.Assert(txnErr.Index, Equals, 1)
+	c.Assert(txnErr.Err, Equals, namespace.ErrNodeExists)
+	c.Assert(results[0].Err, Equals, namespace.ErrRolledBack)
+
+	exists, err := reg.Exists(parent.Sub("new"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteGateway) TestEphemeral(c *C) {
+	reg := suite.dial(c)
+	p := namespace.NewPath("/ephemeral/a")
+	_, err := reg.Put(p, []byte("a"), true)
+	c.Assert(err, IsNil)
+
+	// Renewed by the client, so it outlives the ttl.
+	time.Sleep(600 * time.Millisecond)
+	exists, err := suite.backend.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	c.Assert(reg.Close(), IsNil)
+	exists, err = suite.backend.Exists(p)
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+}
+
+func (suite *TestSuiteGateway) TestSessionExpires(c *C) {
+	u, _ := net.Parse(suite.server.URL)
+	do := func(method, path string, body []byte, header http.Header) *http.Response {
+		req, err := http.NewRequest(method, "http://"+u.Host+path, bytes.NewBuffer(body))
+		c.Assert(err, IsNil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Authorization", "Bearer "+token(c, "ns-write"))
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, IsNil)
+		return resp
+	}
+	resp := do("POST", "/sessions", nil, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	s := session{}
+	c.Assert(json.NewDecoder(resp.Body).Decode(&s), IsNil)
+	resp.Body.Close()
+
+	resp = do("PUT", "/ns/expires/a?ephemeral=true", []byte("a"), http.Header{SessionHeader: []string{s.Id}})
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	resp.Body.Close()
+
+	// Not renewed.
+	time.Sleep(800 * time.Millisecond)
+	exists, err := suite.backend.Exists(namespace.NewPath("/expires/a"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	resp = do("PUT", "/sessions/"+s.Id, nil, nil)
+	c.Assert(resp.StatusCode, Equals, http.StatusGone)
+	resp.Body.Close()
+}
+
+func (suite *TestSuiteGateway) TestAuth(c *C) {
+	u, _ := net.Parse(suite.server.URL)
+	readOnly, err := namespace.Dial(context.Background(), "nsgw://"+u.Host+"/?token="+token(c, "ns-read"))
+	c.Assert(err, IsNil)
+	defer readOnly.Close()
+
+	_, err = suite.backend.Put(namespace.NewPath("/auth/a"), []byte("a"), false)
+	c.Assert(err, IsNil)
+	value, _, err := readOnly.Get(namespace.NewPath("/auth/a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "a")
+
+	_, err = readOnly.Put(namespace.NewPath("/auth/a"), []byte("b"), false)
+	status, is := err.(*UnexpectedStatus)
+	c.Assert(is, Equals, true)
+	c.Assert(status.Status, Equals, http.StatusUnauthorized)
+}
+
+func (suite *TestSuiteGateway) TestTrigger(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+
+	p := namespace.NewPath("/trigger/a")
+	_, err := reg.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+
+	ctx, stop := context.WithCancel(context.Background())
+	changes, err := reg.Trigger(ctx, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+	members, err := reg.Trigger(ctx, (&namespace.Members{Path: p.Dir()}).SetDelta(1))
+	c.Assert(err, IsNil)
+
+	version, err := suite.backend.Put(p, []byte("2"), false)
+	c.Assert(err, IsNil)
+	e := <-changes
+	c.Assert(e.Kind, Equals, namespace.EventChange)
+	c.Assert(e.Path, Equals, p.String())
+	c.Assert(e.Version, Equals, version)
+	c.Assert(string(e.Value), Equals, "2")
+
+	_, err = suite.backend.Put(p.Dir().Sub("b"), []byte{}, false)
+	c.Assert(err, IsNil)
+	e = <-members
+	c.Assert(e.Kind, Equals, namespace.EventMembers)
+	c.Assert(e.Members.AfterCount, Equals, 2)
+
+	stop()
+	for _ = range changes {
+	}
+	for _ = range members {
+	}
+}
+
+func (suite *TestSuiteGateway) streams() int {
+	suite.gateway.lock.Lock()
+	defer suite.gateway.lock.Unlock()
+	return len(suite.gateway.streams)
+}
+
+func (suite *TestSuiteGateway) TestTriggerStopsWithWatchers(c *C) {
+	reg := suite.dial(c)
+	defer reg.Close()
+	other := suite.dial(c)
+	defer other.Close()
+
+	p := namespace.NewPath("/trigger/watchers")
+	first, stopFirst := context.WithCancel(context.Background())
+	changes, err := reg.Trigger(first, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+	second, stopSecond := context.WithCancel(context.Background())
+	shared, err := other.Trigger(second, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+	c.Assert(suite.streams(), Equals, 1)
+
+	// The trigger stays while a client watches it.
+	stopFirst()
+	for _ = range changes {
+	}
+	_, err = suite.backend.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+	_, err = suite.backend.Put(p, []byte("2"), false)
+	c.Assert(err, IsNil)
+	e := <-shared
+	c.Assert(e.Kind, Equals, namespace.EventChange)
+	c.Assert(suite.streams(), Equals, 1)
+
+	// And is stopped after the last one goes.
+	stopSecond()
+	for _ = range shared {
+	}
+	for i := 0; i < 20 && suite.streams() > 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	c.Assert(suite.streams(), Equals, 0)
+
+	// Watching again sets a new trigger.
+	again, stop := context.WithCancel(context.Background())
+	defer stop()
+	changes, err = reg.Trigger(again, namespace.Change{Path: p})
+	c.Assert(err, IsNil)
+	_, err = suite.backend.Put(p, []byte("3"), false)
+	c.Assert(err, IsNil)
+	select {
+	case e := <-changes:
+		c.Assert(string(e.Value), Equals, "3")
+	case <-time.After(time.Second):
+		c.Fatal("Should get change")
+	}
+}
@@ -0,0 +1,503 @@
+package nsgw
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	. "github.com/conductant/gohm/pkg/namespace"
+	. "github.com/conductant/gohm/pkg/store"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	net "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("nsgw", NewService)
+	Register("nsgws", NewService)
+}
+
+// Registry implementation that goes through a gateway, e.g. nsgw://gateway:8080/services, or nsgws://
+// for https.  The token option, e.g. nsgw://gateway:8080/?token=..., is the signed auth token sent to
+// the gateway.  Ephemeral nodes belong to a session on the gateway that is renewed until the registry
+// is closed.
+type registry struct {
+	url    net.URL
+	base   net.URL
+	token  string
+	client *http.Client
+	close  Dispose
+
+	lock    sync.Mutex
+	closed  bool
+	session string
+	done    chan struct{}
+}
+
+func NewService(ctx context.Context, url net.URL, close Dispose) (Registry, error) {
+	base := net.URL{Scheme: "http", Host: url.Host}
+	if url.Scheme == "nsgws" {
+		base.Scheme = "https"
+	}
+	return &registry{
+		url:    net.URL{Scheme: url.Scheme, Host: url.Host},
+		base:   base,
+		token:  ContextGetOptions(ctx).Params.Get("token"),
+		client: &http.Client{},
+		close:  close,
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func (this *registry) check() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func (this *registry) Close() error {
+	ok := true
+	if this.close != nil {
+		this.close.Propose() <- this
+		ok = <-this.close.Accept()
+	}
+	if !ok {
+		return nil
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return nil
+	}
+	this.closed = true
+	close(this.done)
+	if this.session != "" {
+		// Closing the session deletes the ephemeral nodes.
+		resp, err := this.do("DELETE", "/sessions/"+this.session, nil, nil, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return failed(resp)
+		}
+	}
+	return nil
+}
+
+func (this *registry) Id() net.URL {
+	return this.url
+}
+
+// Escapes each segment of the path, so that node names with ?, # or % are not taken as part of the
+// query.
+func escapePath(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = net.PathEscape(part)
+	}
+	return strings.Join(parts, "/")
+}
+
+func (this *registry) do(method, endpoint string, params net.Values, header http.Header,
+	body io.Reader) (*http.Response, error) {
+	u := this.base
+	u.Path = endpoint
+	u.RawPath = escapePath(endpoint)
+	if len(params) > 0 {
+		u.RawQuery = params.Encode()
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Accept", "application/json")
+	if this.token != "" {
+		req.Header.Set("Authorization", "Bearer "+this.token)
+	}
+	return this.client.Do(req)
+}
+
+// Returns the error of a response that failed.
+func failed(resp *http.Response) error {
+	_, err := failedOp(resp)
+	return err
+}
+
+// Returns the index of the failed operation, or -1, and the error.
+func failedOp(resp *http.Response) (int, error) {
+	buff, _ := ioutil.ReadAll(resp.Body)
+	f := failure{Index: -1}
+	if err := json.Unmarshal(buff, &f); err == nil {
+		if known, is := knownError(f.Error); is {
+			return f.Index, known
+		}
+	}
+	return -1, &UnexpectedStatus{Status: resp.StatusCode, Body: string(buff)}
+}
+
+func versionHeader(resp *http.Response) Version {
+	v, err := strconv.ParseInt(resp.Header.Get(VersionHeader), 10, 64)
+	if err != nil {
+		return InvalidVersion
+	}
+	return Version(v)
+}
+
+func casHeader(version Version) http.Header {
+	if version == InvalidVersion {
+		return nil
+	}
+	return http.Header{VersionHeader: []string{strconv.FormatInt(int64(version), 10)}}
+}
+
+// Returns the session for ephemeral nodes, creating it if necessary.
+func (this *registry) getSession() (string, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.session != "" {
+		return this.session, nil
+	}
+	resp, err := this.do("POST", "/sessions", nil, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", failed(resp)
+	}
+	created := session{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	ttl, err := time.ParseDuration(created.Ttl)
+	if err != nil {
+		return "", err
+	}
+	this.session = created.Id
+	go func() {
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				resp, err := this.do("PUT", "/sessions/"+created.Id, nil, nil, nil)
+				if err == nil {
+					if resp.StatusCode != http.StatusOK {
+						err = failed(resp)
+					}
+					resp.Body.Close()
+				}
+				if err != nil {
+					glog.Warningln("Cannot renew session", created.Id, "err=", err)
+				}
+			case <-this.done:
+				return
+			}
+		}
+	}()
+	return created.Id, nil
+}
+
+func (this *registry) Exists(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	resp, err := this.do("HEAD", "/ns"+key.String(), nil, nil, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, &UnexpectedStatus{Status: resp.StatusCode}
+}
+
+func (this *registry) Get(key Path) ([]byte, Version, error) {
+	if err := this.check(); err != nil {
+		return nil, InvalidVersion, err
+	}
+	resp, err := this.do("GET", "/ns"+key.String(), nil, nil, nil)
+	if err != nil {
+		return nil, InvalidVersion, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, InvalidVersion, failed(resp)
+	}
+	value, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, InvalidVersion, err
+	}
+	return value, versionHeader(resp), nil
+}
+
+func (this *registry) List(key Path) ([]Path, error) {
+	return this.paths("/ns"+key.String(), net.Values{"list": []string{"true"}})
+}
+
+// Matches the pattern on the gateway, in one request.
+func (this *registry) Glob(pattern string) ([]Path, error) {
+	return this.paths("/glob", net.Values{"pattern": []string{pattern}})
+}
+
+// Gets a list of paths.
+func (this *registry) paths(endpoint string, params net.Values) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	resp, err := this.do("GET", endpoint, params, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, failed(resp)
+	}
+	list := []string{}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+	paths := []Path{}
+	for _, c := range list {
+		paths = append(paths, NewPath(c))
+	}
+	return paths, nil
+}
+
+func (this *registry) Stat(key Path) (*Stat, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	resp, err := this.do("GET", "/stat"+key.String(), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, failed(resp)
+	}
+	stat := &Stat{}
+	if err := json.NewDecoder(resp.Body).Decode(stat); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+func (this *registry) put(key Path, value []byte, params net.Values, header http.Header) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	resp, err := this.do("PUT", "/ns"+key.String(), params, header, bytes.NewBuffer(value))
+	if err != nil {
+		return InvalidVersion, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return InvalidVersion, failed(resp)
+	}
+	return versionHeader(resp), nil
+}
+
+func (this *registry) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	if !ephemeral {
+		return this.put(key, value, nil, nil)
+	}
+	session, err := this.getSession()
+	if err != nil {
+		return InvalidVersion, err
+	}
+	return this.put(key, value, net.Values{"ephemeral": []string{"true"}},
+		http.Header{SessionHeader: []string{session}})
+}
+
+func (this *registry) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	header := casHeader(version)
+	if header == nil {
+		// Matches any version, but the node must exist.
+		header = http.Header{VersionHeader: []string{strconv.FormatInt(int64(InvalidVersion), 10)}}
+	}
+	return this.put(key, value, nil, header)
+}
+
+func (this *registry) Delete(key Path) error {
+	return this.DeleteVersion(key, InvalidVersion)
+}
+
+func (this *registry) DeleteVersion(key Path, version Version) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	resp, err := this.do("DELETE", "/ns"+key.String(), nil, casHeader(version), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return failed(resp)
+	}
+	return nil
+}
+
+func (this *registry) Txn(ops ...Op) ([]OpResult, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	posted := []op{}
+	var header http.Header
+	for _, o := range ops {
+		w := toOp(o)
+		if w.Ephemeral && header == nil {
+			session, err := this.getSession()
+			if err != nil {
+				return nil, err
+			}
+			header = http.Header{SessionHeader: []string{session}}
+		}
+		posted = append(posted, w)
+	}
+	buff, err := json.Marshal(posted)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := this.do("POST", "/txn", nil, header, bytes.NewBuffer(buff))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		index, err := failedOp(resp)
+		return AbortTxn(ops, index, err)
+	}
+	replied := []opResult{}
+	if err := json.NewDecoder(resp.Body).Decode(&replied); err != nil {
+		return nil, err
+	}
+	results := make([]OpResult, len(replied))
+	for i, r := range replied {
+		results[i] = OpResult{Path: NewPath(r.Path), Version: r.Version}
+	}
+	return results, nil
+}
+
+func triggerQuery(t Trigger) (Path, net.Values, error) {
+	if m, is := t.(*Members); is {
+		t = *m
+	}
+	switch t := t.(type) {
+	case Create:
+		return t.Path, net.Values{"kind": []string{"create"}}, nil
+	case Change:
+		return t.Path, net.Values{"kind": []string{"change"}}, nil
+	case Delete:
+		return t.Path, net.Values{"kind": []string{"delete"}}, nil
+	case Members:
+		params := net.Values{"kind": []string{"members"}}
+		for name, v := range map[string]*int{"min": t.Min, "max": t.Max, "delta": t.Delta} {
+			if v != nil {
+				params.Set(name, strconv.Itoa(*v))
+			}
+		}
+		if t.OutsideRange {
+			params.Set("outside", "true")
+		}
+		return t.Path, params, nil
+	}
+	return nil, nil, ErrBadTrigger
+}
+
+// Watches the stream of server sent events of the gateway.  The trigger is set once this returns.
+func (this *registry) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	p, params, err := triggerQuery(t)
+	if err != nil {
+		return nil, err
+	}
+	u := this.base
+	u.Path = "/watch" + p.String()
+	u.RawQuery = params.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if this.token != "" {
+		req.Header.Set("Authorization", "Bearer "+this.token)
+	}
+	cancel := make(chan struct{})
+	req.Cancel = cancel
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-this.done:
+		case <-stopped:
+		}
+		close(cancel)
+	}()
+	resp, err := this.client.Do(req)
+	if err != nil {
+		close(stopped)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		close(stopped)
+		return nil, failed(resp)
+	}
+
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer close(stopped)
+		defer resp.Body.Close()
+		deliver := func(e Event) bool {
+			select {
+			case events <- e:
+				return true
+			case <-cancel:
+				return false
+			}
+		}
+		reader := bufio.NewReader(resp.Body)
+		for {
+			line, err := reader.ReadString('\n')
+			if strings.HasPrefix(line, "data: ") {
+				e := event{}
+				if err := json.Unmarshal([]byte(line[len("data: "):]), &e); err != nil {
+					glog.Warningln("Bad event from gateway:", line, "err=", err)
+				} else if !deliver(e.toEvent()) {
+					return
+				}
+			}
+			if err == io.EOF {
+				return // The trigger stopped on the gateway.
+			} else if err != nil {
+				select {
+				case <-cancel:
+				default:
+					deliver(Event{Kind: EventError, Path: p.String(), Version: InvalidVersion, Err: err})
+				}
+				return
+			}
+		}
+	}()
+	return events, nil
+}
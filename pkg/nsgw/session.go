@@ -0,0 +1,148 @@
+package nsgw
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/server"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Session of a client of the gateway, which owns the ephemeral nodes created by the client.
+type clientSession struct {
+	id      string
+	lock    sync.Mutex
+	expires time.Time
+	paths   []Path
+}
+
+func (this *clientSession) add(p Path) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.paths = append(this.paths, p)
+}
+
+func (this *clientSession) renew(ttl time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.expires = time.Now().Add(ttl)
+}
+
+func (this *clientSession) expired(now time.Time) bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return now.After(this.expires)
+}
+
+func newSessionId() (string, error) {
+	buff := make([]byte, 16)
+	if _, err := rand.Read(buff); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buff), nil
+}
+
+func (this *Gateway) session(id string) (*clientSession, error) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if s, has := this.sessions[id]; has {
+		return s, nil
+	}
+	return nil, ErrUnknownSession
+}
+
+func (this *Gateway) createSession(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	id, err := newSessionId()
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	s := &clientSession{id: id}
+	s.renew(this.SessionTtl)
+
+	this.lock.Lock()
+	if !this.reaping {
+		this.reaping = true
+		go this.reap()
+	}
+	this.sessions[id] = s
+	this.lock.Unlock()
+
+	reply(resp, session{Id: id, Ttl: this.SessionTtl.String()})
+}
+
+func (this *Gateway) renewSession(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	s, err := this.session(server.GetUrlParameter(req, "id"))
+	if err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	s.renew(this.SessionTtl)
+}
+
+func (this *Gateway) closeSession(ctx context.Context, resp http.ResponseWriter, req *http.Request) {
+	id := server.GetUrlParameter(req, "id")
+	if _, err := this.session(id); err != nil {
+		fail(resp, err, -1)
+		return
+	}
+	this.expire(id)
+}
+
+// Forgets the session and deletes its ephemeral nodes.
+func (this *Gateway) expire(id string) {
+	this.lock.Lock()
+	s, has := this.sessions[id]
+	delete(this.sessions, id)
+	this.lock.Unlock()
+	if !has {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, p := range s.paths {
+		if err := this.reg.Delete(p); err != nil && err != ErrNotExist {
+			glog.Warningln("Cannot delete ephemeral node", p, "of session", id, "err=", err)
+		}
+	}
+	s.paths = nil
+}
+
+// Expires the sessions not renewed in time, until there are no sessions left or the context is done.
+func (this *Gateway) reap() {
+	ticker := time.NewTicker(this.SessionTtl / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			this.lock.Lock()
+			expired := []string{}
+			for id, s := range this.sessions {
+				if s.expired(now) {
+					expired = append(expired, id)
+				}
+			}
+			this.lock.Unlock()
+			for _, id := range expired {
+				glog.Infoln("Session", id, "expired.")
+				this.expire(id)
+			}
+			this.lock.Lock()
+			done := len(this.sessions) == 0
+			this.reaping = !done
+			this.lock.Unlock()
+			if done {
+				return
+			}
+		case <-this.ctx.Done():
+			this.lock.Lock()
+			this.reaping = false
+			this.lock.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package nsgw
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+)
+
+const (
+	// Version of the node, in responses, and the expected version for CAS, in requests.
+	VersionHeader = "X-Namespace-Version"
+
+	// Session of the client that owns the ephemeral nodes created by the request.
+	SessionHeader = "X-Namespace-Session"
+
+	// Event type of the messages of the watch stream.
+	StreamEventType = "namespace"
+)
+
+// An operation of a transaction, as posted to the gateway.
+type op struct {
+	Kind       string  `json:"kind"` // check, create, set or delete
+	Path       string  `json:"path"`
+	Value      []byte  `json:"value,omitempty"`
+	Version    Version `json:"version"`
+	Ephemeral  bool    `json:"ephemeral,omitempty"`
+	Sequential bool    `json:"sequential,omitempty"`
+}
+
+type opResult struct {
+	Path    string  `json:"path"`
+	Version Version `json:"version"`
+}
+
+// Error response of the gateway.  Index is the failed operation of a transaction, or -1.
+type failure struct {
+	Error string `json:"error"`
+	Index int    `json:"index"`
+}
+
+type node struct {
+	Path    string  `json:"path"`
+	Version Version `json:"version"`
+}
+
+type session struct {
+	Id  string `json:"id"`
+	Ttl string `json:"ttl"`
+}
+
+// An event of the watch stream.  Unlike Event.AsMap, the value is kept as bytes.
+type event struct {
+	Kind    string         `json:"kind"`
+	Path    string         `json:"path"`
+	Version Version        `json:"version"`
+	Value   []byte         `json:"value,omitempty"`
+	Members *MembersChange `json:"members,omitempty"`
+	State   string         `json:"state,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+func toOp(o Op) op {
+	switch o := o.(type) {
+	case OpCheck:
+		return op{Kind: "check", Path: o.Path.String(), Version: o.Version}
+	case OpCreate:
+		return op{Kind: "create", Path: o.Path.String(), Value: o.Value, Version: InvalidVersion,
+			Ephemeral: o.Ephemeral, Sequential: o.Sequential}
+	case OpSet:
+		return op{Kind: "set", Path: o.Path.String(), Value: o.Value, Version: o.Version}
+	case OpDelete:
+		return op{Kind: "delete", Path: o.Path.String(), Version: o.Version}
+	}
+	return op{}
+}
+
+func (this op) toOp() (Op, error) {
+	p := NewPath(this.Path)
+	switch this.Kind {
+	case "check":
+		return OpCheck{Path: p, Version: this.Version}, nil
+	case "create":
+		value := this.Value
+		if value == nil {
+			value = []byte{}
+		}
+		return OpCreate{Path: p, Value: value, Ephemeral: this.Ephemeral, Sequential: this.Sequential}, nil
+	case "set":
+		return OpSet{Path: p, Value: this.Value, Version: this.Version}, nil
+	case "delete":
+		return OpDelete{Path: p, Version: this.Version}, nil
+	}
+	return nil, &UnknownOp{Kind: this.Kind}
+}
+
+var eventKinds = map[string]EventKind{}
+
+func init() {
+	for _, k := range []EventKind{EventCreate, EventChange, EventDelete, EventMembers, EventSession, EventError} {
+		eventKinds[k.String()] = k
+	}
+}
+
+func toEvent(e Event) event {
+	w := event{Kind: e.Kind.String(), Path: e.Path, Version: e.Version, Value: e.Value, Members: e.Members,
+		State: e.State}
+	if e.Err != nil {
+		w.Error = e.Err.Error()
+	}
+	return w
+}
+
+func (this event) toEvent() Event {
+	e := Event{Kind: eventKinds[this.Kind], Path: this.Path, Version: this.Version, Value: this.Value,
+		Members: this.Members, State: this.State}
+	if this.Error != "" {
+		e.Err = toError(this.Error)
+	}
+	return e
+}
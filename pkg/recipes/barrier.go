@@ -0,0 +1,146 @@
+package recipes
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+)
+
+// A barrier holds everyone waiting on it while its node exists, e.g. until a deployment step is done.
+type Barrier struct {
+	reg  Registry
+	path Path
+}
+
+func NewBarrier(reg Registry, path Path) *Barrier {
+	return &Barrier{reg: reg, path: path}
+}
+
+// Sets the barrier.  Setting a barrier that is already set is not an error.
+func (this *Barrier) Set() error {
+	_, err := this.reg.Txn(OpCreate{Path: this.path, Value: []byte{}})
+	if txnCause(err) == ErrNodeExists {
+		return nil
+	}
+	return err
+}
+
+// Removes the barrier and lets everyone waiting go.
+func (this *Barrier) Remove() error {
+	if err := this.reg.Delete(this.path); err != ErrNotExist {
+		return err
+	}
+	return nil
+}
+
+// Blocks until the barrier is removed or the context is done.  Returns right away if it's not set.
+func (this *Barrier) Wait(ctx context.Context) error {
+	return waitDelete(ctx, this.reg, this.path)
+}
+
+// A double barrier lets a group of participants start together once all of them have entered, and
+// finish together once all of them have left.  Participants are ephemeral nodes named with their ids
+// under path/participants.  The first to see the group complete creates path/ready, which lets everyone
+// in, and the last to leave deletes it, so the barrier can be used again.
+type DoubleBarrier struct {
+	reg          Registry
+	size         int
+	participants Path
+	ready        Path
+	node         Path
+}
+
+func NewDoubleBarrier(reg Registry, path Path, size int, id string) *DoubleBarrier {
+	participants := path.Sub("participants")
+	return &DoubleBarrier{
+		reg:          reg,
+		size:         size,
+		participants: participants,
+		ready:        path.Sub("ready"),
+		node:         participants.Sub(id),
+	}
+}
+
+// Joins the group and blocks until the group is complete or the context is done.
+func (this *DoubleBarrier) Enter(ctx context.Context) error {
+	// The trigger is set before joining so that no change is missed.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	ready, err := this.reg.Trigger(ctx, Create{Path: this.ready})
+	if err != nil {
+		return err
+	}
+	if _, err := this.reg.Txn(OpCreate{Path: this.node, Value: []byte{}, Ephemeral: true}); err != nil {
+		return txnCause(err)
+	}
+	joined, err := this.reg.List(this.participants)
+	if err != nil {
+		this.reg.Delete(this.node)
+		return err
+	}
+	if len(joined) >= this.size {
+		_, err := this.reg.Txn(OpCreate{Path: this.ready, Value: []byte{}})
+		if err = txnCause(err); err != nil && err != ErrNodeExists {
+			this.reg.Delete(this.node)
+			return err
+		}
+		return nil
+	}
+	if exists, err := this.reg.Exists(this.ready); err != nil || exists {
+		return err
+	}
+	// The channel is closed if the context is done or the registry is closed.
+	e, open := <-ready
+	switch {
+	case open && e.Kind == EventError:
+		this.reg.Delete(this.node)
+		return e.Err
+	case open:
+		return nil
+	}
+	this.reg.Delete(this.node)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrSessionLost
+}
+
+// Leaves the group and blocks until everyone has left or the context is done.
+func (this *DoubleBarrier) Leave(ctx context.Context) error {
+	if err := this.reg.Delete(this.node); err != nil && err != ErrNotExist {
+		return err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		left, err := this.left(ctx)
+		if err != nil || left {
+			return err
+		}
+	}
+}
+
+// Returns true if everyone has left.  Otherwise waits for the next change in the group.
+func (this *DoubleBarrier) left(ctx context.Context) (bool, error) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	members, err := this.reg.Trigger(ctx, Members{Path: this.participants})
+	if err != nil {
+		return false, err
+	}
+	remaining, err := this.reg.List(this.participants)
+	if err != nil && err != ErrNotExist {
+		return false, err
+	}
+	if len(remaining) == 0 {
+		if err := this.reg.Delete(this.ready); err != nil && err != ErrNotExist {
+			return false, err
+		}
+		return true, nil
+	}
+	select {
+	case <-members:
+	case <-ctx.Done():
+	}
+	return false, nil
+}
@@ -0,0 +1,118 @@
+package recipes
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// Returns the node the contender has to wait for, or nil if the contender goes ahead.  The entries
+// are in sequence order and include the contender itself.
+type blockerFunc func(mine entry, all []entry) *entry
+
+// A participant in the queue of sequential nodes under a path.  Each contender waits only for the
+// deletion of the node that blocks it, instead of all contenders waking up on every change.
+type contender struct {
+	reg  Registry
+	path Path
+
+	lock     sync.Mutex
+	node     *entry
+	lost     chan struct{}
+	released chan struct{}
+}
+
+func (this *contender) acquire(ctx context.Context, prefix string, value []byte, blocker blockerFunc) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.node != nil {
+		return ErrHeld
+	}
+	mine, err := createSequential(this.reg, this.path, prefix, value)
+	if err != nil {
+		return err
+	}
+	for {
+		all, err := entries(this.reg, this.path)
+		if err != nil {
+			this.reg.Delete(mine.path)
+			return err
+		}
+		found := false
+		for _, e := range all {
+			found = found || e.path == mine.path
+		}
+		if !found {
+			return ErrSessionLost
+		}
+		b := blocker(mine, all)
+		if b == nil {
+			break
+		}
+		glog.V(100).Infoln("Waiting for", b.path, "node=", mine.path)
+		if err := waitDelete(ctx, this.reg, b.path); err != nil {
+			this.reg.Delete(mine.path)
+			return err
+		}
+	}
+	this.node = &mine
+	this.lost = make(chan struct{})
+	this.released = make(chan struct{})
+	this.watchLost(mine.path, this.lost, this.released)
+	return nil
+}
+
+// Closes the lost channel if the node is deleted by anyone other than release, for example when the
+// session of an ephemeral node expires.
+func (this *contender) watchLost(p Path, lost, released chan struct{}) {
+	ctx, stop := context.WithCancel(context.Background())
+	events, err := this.reg.Trigger(ctx, Delete{Path: p})
+	if err != nil {
+		stop()
+		glog.Warningln("Cannot watch", p, "err=", err)
+		return
+	}
+	go func() {
+		defer stop()
+		// The node may be gone before the trigger was set.
+		if exists, err := this.reg.Exists(p); err != nil || exists {
+			select {
+			case <-events:
+			case <-released:
+				return
+			}
+		}
+		select {
+		case <-released:
+		default:
+			glog.Warningln("Lost", p)
+			close(lost)
+		}
+	}()
+}
+
+func (this *contender) release() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.node == nil {
+		return ErrNotHeld
+	}
+	close(this.released)
+	err := this.reg.Delete(this.node.path)
+	this.node = nil
+	if err == ErrNotExist {
+		return ErrSessionLost
+	}
+	return err
+}
+
+// Returns a channel that is closed if the node is lost while held.  Nil if not held.
+func (this *contender) lostChan() <-chan struct{} {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.node == nil {
+		return nil
+	}
+	return this.lost
+}
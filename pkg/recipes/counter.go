@@ -0,0 +1,92 @@
+package recipes
+
+import (
+	"bytes"
+	. "github.com/conductant/gohm/pkg/namespace"
+	"strconv"
+)
+
+// A counter kept as the value of a node, in decimal.  The value is written with UpdateValue, so no
+// update is lost with any backend.  A node that does not exist counts as zero.
+type Counter struct {
+	reg  Registry
+	path Path
+}
+
+func NewCounter(reg Registry, path Path) *Counter {
+	return &Counter{reg: reg, path: path}
+}
+
+func (this *Counter) Get() (int64, error) {
+	return this.read()
+}
+
+// Adds the delta and returns the new count.
+func (this *Counter) Add(delta int64) (int64, error) {
+	count, _, err := this.update(func(count int64) (int64, bool) {
+		return count + delta, true
+	})
+	return count, err
+}
+
+func (this *Counter) Increment() (int64, error) {
+	return this.Add(1)
+}
+
+func (this *Counter) Decrement() (int64, error) {
+	return this.Add(-1)
+}
+
+// Sets the count if it's the expected one.  Returns false if it is not.
+func (this *Counter) CompareAndSet(expected, count int64) (bool, error) {
+	_, set, err := this.update(func(current int64) (int64, bool) {
+		return count, current == expected
+	})
+	return set, err
+}
+
+// Returns the count, or zero if the node does not exist.
+func (this *Counter) read() (int64, error) {
+	value, _, err := this.reg.Get(this.path)
+	if err == ErrNotExist {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return parseCount(value)
+}
+
+func parseCount(value []byte) (int64, error) {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	count, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, ErrNotCounter
+	}
+	return count, nil
+}
+
+// Writes the count returned by the function with UpdateValue, unless it returns false.  Returns the
+// count written, or the current count if none is written.
+func (this *Counter) update(f func(int64) (int64, bool)) (int64, bool, error) {
+	count, set := int64(0), false
+	_, err := UpdateValue(this.reg, this.path, func(value []byte, exists bool) ([]byte, error) {
+		current, err := parseCount(value)
+		if err != nil {
+			return nil, err
+		}
+		next, ok := f(current)
+		if !ok {
+			count, set = current, false
+			return nil, SkipUpdate
+		}
+		count, set = next, true
+		return []byte(strconv.FormatInt(next, 10)), nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return count, set, nil
+}
@@ -0,0 +1,58 @@
+package recipes
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+)
+
+const (
+	candidatePrefix = "candidate-"
+)
+
+// Leader election.  Candidates queue up as ephemeral sequential nodes under the path, with their ids as
+// values, and the lowest one is the leader.  When the leader resigns or its session is lost, only the
+// next candidate in line is woken up.
+type Election struct {
+	contender
+	id []byte
+}
+
+func NewElection(reg Registry, path Path, id []byte) *Election {
+	return &Election{contender: contender{reg: reg, path: path}, id: id}
+}
+
+// Blocks until this candidate is the leader or the context is done.
+func (this *Election) Campaign(ctx context.Context) error {
+	return this.acquire(ctx, candidatePrefix, this.id, predecessor)
+}
+
+// Steps down so the next candidate becomes the leader.
+func (this *Election) Resign() error {
+	return this.release()
+}
+
+// Returns a channel that is closed if the leadership is lost, e.g. when the session expired.
+func (this *Election) Lost() <-chan struct{} {
+	return this.lostChan()
+}
+
+// Returns the id of the current leader.
+func (this *Election) Leader() ([]byte, error) {
+	all, err := entries(this.reg, this.path)
+	if err == ErrNotExist {
+		return nil, ErrNoLeader
+	} else if err != nil {
+		return nil, err
+	}
+	for _, e := range all {
+		if e.prefix != candidatePrefix {
+			continue
+		}
+		id, _, err := this.reg.Get(e.path)
+		if err == ErrNotExist {
+			continue // Just resigned
+		}
+		return id, err
+	}
+	return nil, ErrNoLeader
+}
@@ -0,0 +1,23 @@
+package recipes
+
+import (
+	"errors"
+	. "github.com/conductant/gohm/pkg/namespace"
+)
+
+var (
+	ErrNotHeld     = errors.New("error-not-held")
+	ErrHeld        = errors.New("error-already-held")
+	ErrSessionLost = errors.New("error-session-lost")
+	ErrNoLeader    = errors.New("error-no-leader")
+	ErrEmpty       = errors.New("error-queue-empty")
+	ErrNotCounter  = errors.New("error-not-a-counter")
+)
+
+// Returns the error of the failed operation of a transaction.
+func txnCause(err error) error {
+	if txnErr, is := err.(*TxnError); is {
+		return txnErr.Err
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package recipes
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+)
+
+const (
+	lockPrefix  = "lock-"
+	readPrefix  = "read-"
+	writePrefix = "write-"
+)
+
+// Distributed mutex.  Contenders queue up as ephemeral sequential nodes under the path and the lowest
+// one holds the lock.  A Mutex is not reentrant and should be used by one goroutine at a time.
+type Mutex struct {
+	contender
+}
+
+func NewMutex(reg Registry, path Path) *Mutex {
+	return &Mutex{contender: contender{reg: reg, path: path}}
+}
+
+// Blocks until the lock is acquired or the context is done.
+func (this *Mutex) Lock(ctx context.Context) error {
+	return this.acquire(ctx, lockPrefix, nil, predecessor)
+}
+
+func (this *Mutex) Unlock() error {
+	return this.release()
+}
+
+// Returns a channel that is closed if the lock is lost while held, e.g. when the session expired.
+func (this *Mutex) Lost() <-chan struct{} {
+	return this.lostChan()
+}
+
+// Waits for the node just before this one.
+func predecessor(mine entry, all []entry) *entry {
+	for i, e := range all {
+		if e.path == mine.path {
+			if i == 0 {
+				return nil
+			}
+			return &all[i-1]
+		}
+	}
+	return nil
+}
+
+// Waits for the last writer before this one.
+func lastWriter(mine entry, all []entry) *entry {
+	var blocker *entry
+	for i, e := range all {
+		if e.path == mine.path {
+			break
+		}
+		if e.prefix == writePrefix {
+			blocker = &all[i]
+		}
+	}
+	return blocker
+}
+
+// Distributed read / write lock.  Readers share the lock unless a writer is ahead of them in the queue,
+// and writers hold it exclusively.
+type RWMutex struct {
+	reader contender
+	writer contender
+}
+
+func NewRWMutex(reg Registry, path Path) *RWMutex {
+	return &RWMutex{
+		reader: contender{reg: reg, path: path},
+		writer: contender{reg: reg, path: path},
+	}
+}
+
+// Blocks until the read lock is acquired or the context is done.
+func (this *RWMutex) RLock(ctx context.Context) error {
+	return this.reader.acquire(ctx, readPrefix, nil, lastWriter)
+}
+
+func (this *RWMutex) RUnlock() error {
+	return this.reader.release()
+}
+
+// Blocks until the write lock is acquired or the context is done.
+func (this *RWMutex) Lock(ctx context.Context) error {
+	return this.writer.acquire(ctx, writePrefix, nil, predecessor)
+}
+
+func (this *RWMutex) Unlock() error {
+	return this.writer.release()
+}
+
+// Returns a channel that is closed if the read lock is lost while held.
+func (this *RWMutex) RLost() <-chan struct{} {
+	return this.reader.lostChan()
+}
+
+// Returns a channel that is closed if the write lock is lost while held.
+func (this *RWMutex) Lost() <-chan struct{} {
+	return this.writer.lostChan()
+}
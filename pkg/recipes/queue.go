@@ -0,0 +1,176 @@
+package recipes
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+)
+
+const itemPrefix = "item-"
+
+// Distributed FIFO work queue.  Items are persistent sequential nodes under path/items.  A consumer
+// takes an item by creating an ephemeral claim node with the same name under path/claims, and deletes
+// both when done.  If the consumer goes away first, the claim goes away with its session and the item
+// is taken again by another consumer, so every item is processed at least once.
+type Queue struct {
+	reg    Registry
+	items  Path
+	claims Path
+}
+
+// An item of the queue.  A taken item must be marked done once processed, or released to put it back.
+type Item struct {
+	Path  Path
+	Value []byte
+
+	reg   Registry
+	claim Path
+}
+
+func NewQueue(reg Registry, path Path) *Queue {
+	return &Queue{reg: reg, items: path.Sub("items"), claims: path.Sub("claims")}
+}
+
+// Adds the value at the tail of the queue and returns the path of the item.
+func (this *Queue) Offer(value []byte) (Path, error) {
+	return CreateSequential(this.reg, this.items.Sub(itemPrefix), value, false)
+}
+
+// Returns the first item that is not taken, without taking it.  Returns ErrEmpty if there is none.
+func (this *Queue) Peek() (*Item, error) {
+	for {
+		available, err := this.available()
+		if err != nil {
+			return nil, err
+		}
+		if len(available) == 0 {
+			return nil, ErrEmpty
+		}
+		value, _, err := this.reg.Get(available[0].path)
+		if err == ErrNotExist {
+			continue // Done in the meantime.
+		} else if err != nil {
+			return nil, err
+		}
+		return &Item{Path: available[0].path, Value: value, reg: this.reg}, nil
+	}
+}
+
+// Takes the first item that is not taken, waiting until there is one or the context is done.
+func (this *Queue) Take(ctx context.Context) (*Item, error) {
+	// Some backends can't watch the members of a node that does not exist yet.
+	for _, p := range []Path{this.items, this.claims} {
+		if _, err := this.reg.Txn(OpCreate{Path: p, Value: []byte{}}); err != nil && txnCause(err) != ErrNodeExists {
+			return nil, err
+		}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item, err := this.take(ctx)
+		if err != ErrEmpty {
+			return item, err
+		}
+	}
+}
+
+// Tries to take an item.  If there is none, waits for a new item or claim released, and returns ErrEmpty.
+func (this *Queue) take(ctx context.Context) (*Item, error) {
+	// The triggers are set before looking so that no change is missed.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	items, err := this.reg.Trigger(ctx, Members{Path: this.items})
+	if err != nil {
+		return nil, err
+	}
+	claims, err := this.reg.Trigger(ctx, Members{Path: this.claims})
+	if err != nil {
+		return nil, err
+	}
+	available, err := this.available()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range available {
+		claim := this.claims.Sub(e.path.Base())
+		// The check makes sure the item is not done and gone by now.
+		_, err := this.reg.Txn(
+			OpCheck{Path: e.path, Version: InvalidVersion},
+			OpCreate{Path: claim, Value: []byte{}, Ephemeral: true},
+		)
+		if cause := txnCause(err); cause == ErrNodeExists || cause == ErrNotExist {
+			continue // Taken by someone else.
+		} else if err != nil {
+			return nil, err
+		}
+		value, _, err := this.reg.Get(e.path)
+		if err != nil {
+			this.reg.Delete(claim)
+			return nil, err
+		}
+		return &Item{Path: e.path, Value: value, reg: this.reg, claim: claim}, nil
+	}
+	select {
+	case <-items:
+	case <-claims:
+	case <-ctx.Done():
+	}
+	return nil, ErrEmpty
+}
+
+// Returns the items that are not taken, in order.
+func (this *Queue) available() ([]entry, error) {
+	all, err := entries(this.reg, this.items)
+	if err == ErrNotExist {
+		return []entry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	claims, err := this.reg.List(this.claims)
+	if err != nil && err != ErrNotExist {
+		return nil, err
+	}
+	taken := map[string]bool{}
+	for _, c := range claims {
+		taken[c.Base()] = true
+	}
+	available := []entry{}
+	for _, e := range all {
+		if e.prefix == itemPrefix && !taken[e.path.Base()] {
+			available = append(available, e)
+		}
+	}
+	return available, nil
+}
+
+// Removes the item from the queue.
+func (this *Item) Done() error {
+	if this.claim == nil {
+		return ErrNotHeld
+	}
+	_, err := this.reg.Txn(
+		OpDelete{Path: this.claim, Version: InvalidVersion},
+		OpDelete{Path: this.Path, Version: InvalidVersion},
+	)
+	if txnCause(err) == ErrNotExist {
+		return ErrSessionLost
+	} else if err != nil {
+		return err
+	}
+	this.claim = nil
+	return nil
+}
+
+// Puts the item back, to be taken again.
+func (this *Item) Release() error {
+	if this.claim == nil {
+		return ErrNotHeld
+	}
+	if err := this.reg.Delete(this.claim); err == ErrNotExist {
+		return ErrSessionLost
+	} else if err != nil {
+		return err
+	}
+	this.claim = nil
+	return nil
+}
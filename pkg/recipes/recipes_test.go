@@ -0,0 +1,461 @@
+package recipes
+
+import (
+	"github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	net "net/url"
+	"testing"
+	"time"
+)
+
+var delay = 200 * time.Millisecond
+
+func TestRecipes(t *testing.T) { TestingT(t) }
+
+type TestSuiteRecipes struct{}
+
+var _ = Suite(&TestSuiteRecipes{})
+
+func (suite *TestSuiteRecipes) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteRecipes) TearDownSuite(c *C) {
+}
+
+// Each call returns a separate session on the same in-memory tree.
+func session(c *C) namespace.Registry {
+	reg, err := mem.NewService(context.Background(), net.URL{Scheme: "mem", Host: "recipes"}, nil)
+	c.Assert(err, IsNil)
+	return reg
+}
+
+// Runs the function in a goroutine and returns a channel that receives its error.
+func async(f func() error) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- f() }()
+	return done
+}
+
+func (suite *TestSuiteRecipes) TestMutex(c *C) {
+	s1, s2 := session(c), session(c)
+	defer s1.Close()
+	defer s2.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/mutex")
+	m1, m2 := NewMutex(s1, p), NewMutex(s2, p)
+
+	c.Assert(m1.Lock(context.Background()), IsNil)
+	c.Assert(m1.Lock(context.Background()), Equals, ErrHeld)
+
+	locked := async(func() error { return m2.Lock(context.Background()) })
+	select {
+	case <-locked:
+		c.Fatal("Should block")
+	case <-time.After(delay):
+	}
+
+	c.Assert(m1.Unlock(), IsNil)
+	c.Assert(m1.Unlock(), Equals, ErrNotHeld)
+	select {
+	case err := <-locked:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("Should have the lock")
+	}
+
+	// Giving up waiting removes the node from the queue.
+	ctx, cancel := context.WithTimeout(context.Background(), delay)
+	defer cancel()
+	c.Assert(m1.Lock(ctx), Equals, context.DeadlineExceeded)
+	list, err := entries(s1, p)
+	c.Assert(err, IsNil)
+	c.Assert(len(list), Equals, 1)
+
+	c.Assert(m2.Unlock(), IsNil)
+}
+
+func (suite *TestSuiteRecipes) TestMutexLost(c *C) {
+	s1, s2 := session(c), session(c)
+	defer s2.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/lost")
+	m1, m2 := NewMutex(s1, p), NewMutex(s2, p)
+	c.Assert(m1.Lock(context.Background()), IsNil)
+	lost := m1.Lost()
+
+	locked := async(func() error { return m2.Lock(context.Background()) })
+
+	// Closing the session removes the ephemeral node.
+	c.Assert(s1.Close(), IsNil)
+	select {
+	case <-lost:
+	case <-time.After(delay):
+		c.Fatal("Should be lost")
+	}
+	select {
+	case err := <-locked:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("Should have the lock")
+	}
+
+	// Unlocking normally does not signal a loss.
+	lost = m2.Lost()
+	c.Assert(m2.Unlock(), IsNil)
+	select {
+	case <-lost:
+		c.Fatal("Should not be lost")
+	case <-time.After(delay):
+	}
+}
+
+func (suite *TestSuiteRecipes) TestRWMutex(c *C) {
+	s1, s2, s3 := session(c), session(c), session(c)
+	defer s1.Close()
+	defer s2.Close()
+	defer s3.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/rwmutex")
+	r1, r2, w := NewRWMutex(s1, p), NewRWMutex(s2, p), NewRWMutex(s3, p)
+
+	// Readers share the lock
+	c.Assert(r1.RLock(context.Background()), IsNil)
+	c.Assert(r2.RLock(context.Background()), IsNil)
+
+	locked := async(func() error { return w.Lock(context.Background()) })
+	c.Assert(r1.RUnlock(), IsNil)
+	select {
+	case <-locked:
+		c.Fatal("Should wait for all readers")
+	case <-time.After(delay):
+	}
+	c.Assert(r2.RUnlock(), IsNil)
+	select {
+	case err := <-locked:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("Should have the lock")
+	}
+
+	// Readers wait for the writer ahead of them.
+	read := async(func() error { return r1.RLock(context.Background()) })
+	select {
+	case <-read:
+		c.Fatal("Should wait for the writer")
+	case <-time.After(delay):
+	}
+	c.Assert(w.Unlock(), IsNil)
+	select {
+	case err := <-read:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("Should have the read lock")
+	}
+	c.Assert(r1.RUnlock(), IsNil)
+}
+
+func (suite *TestSuiteRecipes) TestElection(c *C) {
+	s1, s2, s3 := session(c), session(c), session(c)
+	defer s1.Close()
+	defer s2.Close()
+	defer s3.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/election")
+	e1 := NewElection(s1, p, []byte("host-1"))
+	e2 := NewElection(s2, p, []byte("host-2"))
+	e3 := NewElection(s3, p, []byte("host-3"))
+
+	_, err := e1.Leader()
+	c.Assert(err, Equals, ErrNoLeader)
+
+	c.Assert(e1.Campaign(context.Background()), IsNil)
+	second := async(func() error { return e2.Campaign(context.Background()) })
+	time.Sleep(delay)
+	third := async(func() error { return e3.Campaign(context.Background()) })
+	time.Sleep(delay)
+
+	leader, err := e3.Leader()
+	c.Assert(err, IsNil)
+	c.Assert(leader, DeepEquals, []byte("host-1"))
+
+	// Only the next in line takes over.
+	c.Assert(e1.Resign(), IsNil)
+	select {
+	case err := <-second:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("host-2 should lead")
+	}
+	select {
+	case <-third:
+		c.Fatal("host-3 should wait")
+	case <-time.After(delay):
+	}
+	leader, err = e1.Leader()
+	c.Assert(err, IsNil)
+	c.Assert(leader, DeepEquals, []byte("host-2"))
+
+	c.Assert(s2.Close(), IsNil)
+	select {
+	case err := <-third:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("host-3 should lead")
+	}
+	c.Assert(e3.Resign(), IsNil)
+}
+
+func (suite *TestSuiteRecipes) TestQueue(c *C) {
+	s1, s2 := session(c), session(c)
+	defer s1.Close()
+	defer s2.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/queue")
+	producer, consumer := NewQueue(s1, p), NewQueue(s2, p)
+
+	_, err := consumer.Peek()
+	c.Assert(err, Equals, ErrEmpty)
+
+	// Take blocks until there is an item.
+	taken := make(chan *Item, 1)
+	go func() {
+		item, err := consumer.Take(context.Background())
+		c.Assert(err, IsNil)
+		taken <- item
+	}()
+	select {
+	case <-taken:
+		c.Fatal("Should block")
+	case <-time.After(delay):
+	}
+	for _, v := range []string{"a", "b", "c"} {
+		_, err := producer.Offer([]byte(v))
+		c.Assert(err, IsNil)
+	}
+	var a *Item
+	select {
+	case a = <-taken:
+		c.Assert(string(a.Value), Equals, "a")
+	case <-time.After(delay):
+		c.Fatal("Should take a")
+	}
+
+	// Taken items are skipped.
+	head, err := producer.Peek()
+	c.Assert(err, IsNil)
+	c.Assert(string(head.Value), Equals, "b")
+	c.Assert(head.Done(), Equals, ErrNotHeld)
+
+	b, err := producer.Take(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(string(b.Value), Equals, "b")
+	c.Assert(b.Done(), IsNil)
+
+	// Released items are taken again, in order.
+	c.Assert(a.Release(), IsNil)
+	item, err := producer.Take(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(string(item.Value), Equals, "a")
+	c.Assert(item.Done(), IsNil)
+	item, err = producer.Take(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(string(item.Value), Equals, "c")
+	c.Assert(item.Done(), IsNil)
+
+	_, err = producer.Peek()
+	c.Assert(err, Equals, ErrEmpty)
+	ctx, cancel := context.WithTimeout(context.Background(), delay)
+	defer cancel()
+	_, err = producer.Take(ctx)
+	c.Assert(err, Equals, context.DeadlineExceeded)
+}
+
+func (suite *TestSuiteRecipes) TestQueueAtLeastOnce(c *C) {
+	s1, s2 := session(c), session(c)
+	defer s2.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/queue-lost")
+	q1, q2 := NewQueue(s1, p), NewQueue(s2, p)
+	_, err := q1.Offer([]byte("job"))
+	c.Assert(err, IsNil)
+	item, err := q1.Take(context.Background())
+	c.Assert(err, IsNil)
+
+	taken := async(func() error {
+		item, err := q2.Take(context.Background())
+		if err == nil && string(item.Value) == "job" {
+			return item.Done()
+		}
+		return err
+	})
+	select {
+	case <-taken:
+		c.Fatal("Should wait for the claim")
+	case <-time.After(delay):
+	}
+
+	// The consumer goes away without finishing the job.
+	c.Assert(s1.Close(), IsNil)
+	select {
+	case err := <-taken:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("Should take the job again")
+	}
+	c.Assert(item.Done(), NotNil)
+	_, err = q2.Peek()
+	c.Assert(err, Equals, ErrEmpty)
+}
+
+func (suite *TestSuiteRecipes) TestCounter(c *C) {
+	p := namespace.NewPath("/unit-test/recipes/counter")
+	s1, s2 := session(c), session(c)
+	defer s1.Close()
+	defer s2.Close()
+
+	count, err := NewCounter(s1, p).Get()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(0))
+
+	// Concurrent updates from two sessions are not lost.
+	done := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		counter := NewCounter([]namespace.Registry{s1, s2}[i%2], p)
+		go func() {
+			for j := 0; j < 5; j++ {
+				if _, err := counter.Increment(); err != nil {
+					done <- err
+					return
+				}
+			}
+			done <- nil
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		c.Assert(<-done, IsNil)
+	}
+	counter := NewCounter(s2, p)
+	count, err = counter.Get()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(100))
+
+	count, err = counter.Add(-10)
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(90))
+	set, err := counter.CompareAndSet(100, 0)
+	c.Assert(err, IsNil)
+	c.Assert(set, Equals, false)
+	set, err = counter.CompareAndSet(90, 0)
+	c.Assert(err, IsNil)
+	c.Assert(set, Equals, true)
+
+	_, err = s1.Put(p, []byte("not a number"), false)
+	c.Assert(err, IsNil)
+	_, err = counter.Increment()
+	c.Assert(err, Equals, ErrNotCounter)
+}
+
+func (suite *TestSuiteRecipes) TestBarrier(c *C) {
+	s1, s2 := session(c), session(c)
+	defer s1.Close()
+	defer s2.Close()
+
+	p := namespace.NewPath("/unit-test/recipes/barrier")
+	b1, b2 := NewBarrier(s1, p), NewBarrier(s2, p)
+	c.Assert(b2.Wait(context.Background()), IsNil)
+
+	c.Assert(b1.Set(), IsNil)
+	c.Assert(b1.Set(), IsNil)
+	passed := async(func() error { return b2.Wait(context.Background()) })
+	select {
+	case <-passed:
+		c.Fatal("Should wait")
+	case <-time.After(delay):
+	}
+	c.Assert(b1.Remove(), IsNil)
+	select {
+	case err := <-passed:
+		c.Assert(err, IsNil)
+	case <-time.After(delay):
+		c.Fatal("Should pass")
+	}
+	c.Assert(b1.Remove(), IsNil)
+}
+
+func (suite *TestSuiteRecipes) TestDoubleBarrierSameId(c *C) {
+	p := namespace.NewPath("/unit-test/recipes/double-barrier-same-id")
+	s := session(c)
+	defer s.Close()
+	b := NewDoubleBarrier(s, p, 1, "host-1")
+	c.Assert(b.Enter(context.Background()), IsNil)
+
+	// The error of the failed join is returned as is.
+	c.Assert(NewDoubleBarrier(s, p, 1, "host-1").Enter(context.Background()), Equals, namespace.ErrNodeExists)
+	c.Assert(b.Leave(context.Background()), IsNil)
+}
+
+func (suite *TestSuiteRecipes) TestDoubleBarrier(c *C) {
+	p := namespace.NewPath("/unit-test/recipes/double-barrier")
+	barriers := []*DoubleBarrier{}
+	for _, id := range []string{"host-1", "host-2", "host-3"} {
+		s := session(c)
+		defer s.Close()
+		barriers = append(barriers, NewDoubleBarrier(s, p, 3, id))
+	}
+
+	for round := 0; round < 2; round++ {
+		// No one enters until all three have.
+		entered := []<-chan error{}
+		for _, b := range barriers[:2] {
+			b := b
+			entered = append(entered, async(func() error { return b.Enter(context.Background()) }))
+		}
+		for _, e := range entered {
+			select {
+			case <-e:
+				c.Fatal("Should wait for everyone")
+			case <-time.After(delay):
+			}
+		}
+		c.Assert(barriers[2].Enter(context.Background()), IsNil)
+		for _, e := range entered {
+			select {
+			case err := <-e:
+				c.Assert(err, IsNil)
+			case <-time.After(delay):
+				c.Fatal("Should enter")
+			}
+		}
+
+		// No one leaves until all three have.
+		left := []<-chan error{}
+		for _, b := range barriers[:2] {
+			b := b
+			left = append(left, async(func() error { return b.Leave(context.Background()) }))
+		}
+		for _, l := range left {
+			select {
+			case <-l:
+				c.Fatal("Should wait for everyone")
+			case <-time.After(delay):
+			}
+		}
+		c.Assert(barriers[2].Leave(context.Background()), IsNil)
+		for _, l := range left {
+			select {
+			case err := <-l:
+				c.Assert(err, IsNil)
+			case <-time.After(delay):
+				c.Fatal("Should leave")
+			}
+		}
+	}
+
+	// Giving up waiting leaves the group.
+	ctx, cancel := context.WithTimeout(context.Background(), delay)
+	defer cancel()
+	c.Assert(barriers[0].Enter(ctx), Equals, context.DeadlineExceeded)
+	c.Assert(barriers[1].Enter(ctx), Equals, context.DeadlineExceeded)
+}
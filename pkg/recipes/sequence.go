@@ -0,0 +1,70 @@
+package recipes
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"golang.org/x/net/context"
+	"sort"
+)
+
+// A sequential node under the parent path.
+type entry struct {
+	path   Path
+	prefix string
+	seq    int64
+}
+
+type bySequence []entry
+
+func (this bySequence) Len() int           { return len(this) }
+func (this bySequence) Less(i, j int) bool { return this[i].seq < this[j].seq }
+func (this bySequence) Swap(i, j int)      { this[i], this[j] = this[j], this[i] }
+
+// Creates an ephemeral sequential node named with the prefix under the parent.
+func createSequential(reg Registry, parent Path, prefix string, value []byte) (entry, error) {
+	p, err := CreateSequential(reg, parent.Sub(prefix), value, true)
+	if err != nil {
+		return entry{}, err
+	}
+	seq, _ := SequenceOf(p)
+	return entry{path: p, prefix: prefix, seq: seq}, nil
+}
+
+// Lists the sequential nodes under the parent, in sequence order.  Other nodes are ignored.
+func entries(reg Registry, parent Path) ([]entry, error) {
+	children, err := reg.List(parent)
+	if err != nil {
+		return nil, err
+	}
+	list := []entry{}
+	for _, child := range children {
+		seq, has := SequenceOf(child)
+		if !has {
+			continue
+		}
+		name := child.Base()
+		list = append(list, entry{path: child, prefix: name[:len(name)-SequenceDigits], seq: seq})
+	}
+	sort.Sort(bySequence(list))
+	return list, nil
+}
+
+// Blocks until the node is deleted or the context is done.
+func waitDelete(ctx context.Context, reg Registry, p Path) error {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	events, err := reg.Trigger(ctx, Delete{Path: p})
+	if err != nil {
+		return err
+	}
+	// The node may be gone before the trigger was set.
+	if exists, err := reg.Exists(p); err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+	// The events channel is closed too when the context is done.
+	if e, open := <-events; open && e.Kind == EventError {
+		return e.Err
+	}
+	return ctx.Err()
+}
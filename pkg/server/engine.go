@@ -61,8 +61,14 @@ func httpHandler(engine *engine, binding *methodBinding, am AuthManager) func(ht
 }
 
 func (this *engine) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
-	defer this.lock.Unlock()
+	this.start()
+	// Not locked while serving, as streams are long running and take the lock to look up their channel.
+	this.router.ServeHTTP(resp, request)
+}
+
+func (this *engine) start() {
 	this.lock.Lock()
+	defer this.lock.Unlock()
 	if !this.running {
 		// Also start listening on the event channel for any webhook calls
 		go func() {
@@ -81,7 +87,6 @@ func (this *engine) ServeHTTP(resp http.ResponseWriter, request *http.Request) {
 		}()
 		this.running = true
 	}
-	this.router.ServeHTTP(resp, request)
 }
 
 func (this *engine) Handle(path string, handler http.Handler) {
@@ -9,9 +9,21 @@ import (
 	"sync"
 )
 
+// Chooses the host:port to forward each request to, e.g. from the instances found by service discovery.
+type Upstream interface {
+	Select(req *http.Request) (string, error)
+}
+
+// Optional interface for upstreams that know all the hosts they select from, e.g. the instances of a
+// service.  The proxy then forgets the hosts that are gone.
+type UpstreamHosts interface {
+	Hosts() []string
+}
+
 // Simple, single-host reverse proxy.  This assumes that the backend information can be somehow determined and
 // that there are some simple url stripping that takes place.  See the test case for example usage to build
-// a trivial reverse proxy that can optionally support token authentication.
+// a trivial reverse proxy that can optionally support token authentication.  With an Upstream, the host
+// is chosen per request instead.
 type ReverseProxy struct {
 	http.Handler
 
@@ -22,8 +34,11 @@ type ReverseProxy struct {
 	port   string
 	prefix string
 
+	upstream Upstream
+
 	lock       sync.Mutex
 	delegate   http.Handler
+	delegates  map[string]http.Handler // by host:port, when using an upstream
 	errHandler func(http.ResponseWriter, *http.Request, string, int) error
 }
 
@@ -62,6 +77,12 @@ func (this *ReverseProxy) SetForwardHostPort(hp string) *ReverseProxy {
 	return this
 }
 
+// Forwards to the host:port selected by the upstream for each request, instead of a fixed host and port.
+func (this *ReverseProxy) SetForwardUpstream(u Upstream) *ReverseProxy {
+	this.upstream = u
+	return this
+}
+
 func (this *ReverseProxy) SetForwardPrefix(p string) *ReverseProxy {
 	this.prefix = p
 	return this
@@ -73,6 +94,11 @@ func (this *ReverseProxy) Strip(s string) *ReverseProxy {
 }
 
 func (this *ReverseProxy) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if this.upstream != nil {
+		this.serveUpstream(resp, req)
+		return
+	}
+
 	this.lock.Lock()
 	defer this.lock.Unlock()
 
@@ -88,8 +114,57 @@ func (this *ReverseProxy) ServeHTTP(resp http.ResponseWriter, req *http.Request)
 	this.delegate.ServeHTTP(resp, req)
 }
 
+func (this *ReverseProxy) serveUpstream(resp http.ResponseWriter, req *http.Request) {
+	hostPort, err := this.upstream.Select(req)
+	if err != nil {
+		this.errHandler(resp, req, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	this.lock.Lock()
+	if this.delegates == nil {
+		this.delegates = map[string]http.Handler{}
+	}
+	delegate, has := this.delegates[hostPort]
+	if !has {
+		urlString := this.urlFor(hostPort)
+		u, err := url.Parse(urlString)
+		if err != nil {
+			this.lock.Unlock()
+			this.errHandler(resp, req, urlString, http.StatusInternalServerError)
+			return
+		}
+		delegate = http.StripPrefix(this.strip, reverseProxyHandler(u))
+		this.prune()
+		this.delegates[hostPort] = delegate
+	}
+	this.lock.Unlock()
+	delegate.ServeHTTP(resp, req)
+}
+
+// Drops the delegates of the hosts the upstream no longer selects from.  Called when a host is added,
+// so the delegates are at most the current hosts and the one being added.  Must hold lock.
+func (this *ReverseProxy) prune() {
+	hosts, is := this.upstream.(UpstreamHosts)
+	if !is {
+		return
+	}
+	current := map[string]bool{}
+	for _, h := range hosts.Hosts() {
+		current[h] = true
+	}
+	for h, _ := range this.delegates {
+		if !current[h] {
+			delete(this.delegates, h)
+		}
+	}
+}
+
 func (this *ReverseProxy) reverseProxyUrl() string {
-	p1 := fmt.Sprintf("%s://%s%s", this.scheme, this.host, this.port)
+	return this.urlFor(this.host + this.port)
+}
+
+func (this *ReverseProxy) urlFor(hostPort string) string {
+	p1 := fmt.Sprintf("%s://%s", this.scheme, hostPort)
 	if len(this.prefix) > 0 {
 		if strings.HasPrefix(this.prefix, "/") {
 			return p1 + this.prefix
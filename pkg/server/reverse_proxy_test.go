@@ -5,6 +5,8 @@ import (
 	"golang.org/x/net/context"
 	. "gopkg.in/check.v1"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -107,3 +109,46 @@ func (suite *TestSuiteReverseProxy) TestReverseProxy(c *C) {
 		})
 	c.Assert(suite.proxyInvokes, Equals, suite.backendInvokes+1)
 }
+
+// Selects the first of the hosts.
+type testUpstream struct {
+	hosts []string
+}
+
+func (this *testUpstream) Select(req *http.Request) (string, error) {
+	return this.hosts[0], nil
+}
+
+func (this *testUpstream) Hosts() []string {
+	return this.hosts
+}
+
+func (suite *TestSuiteReverseProxy) TestUpstreamHostsPruned(c *C) {
+	backend := func() (*httptest.Server, string) {
+		s := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+		return s, strings.TrimPrefix(s.URL, "http://")
+	}
+	s1, h1 := backend()
+	defer s1.Close()
+	s2, h2 := backend()
+	defer s2.Close()
+
+	upstream := &testUpstream{hosts: []string{h1}}
+	proxy := NewReverseProxy().SetForwardUpstream(upstream)
+	serve := func() {
+		req, err := http.NewRequest("GET", "http://proxy/", nil)
+		c.Assert(err, IsNil)
+		resp := httptest.NewRecorder()
+		proxy.ServeHTTP(resp, req)
+		c.Assert(resp.Code, Equals, http.StatusOK)
+	}
+	serve()
+	c.Assert(len(proxy.delegates), Equals, 1)
+
+	// h1 leaves
+	upstream.hosts = []string{h2}
+	serve()
+	c.Assert(len(proxy.delegates), Equals, 1)
+	_, has := proxy.delegates[h2]
+	c.Assert(has, Equals, true)
+}
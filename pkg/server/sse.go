@@ -69,12 +69,21 @@ func (this *engine) MergeHttpStream(resp http.ResponseWriter, req *http.Request,
 	sc, new := this.StreamChannel(contentType, eventType, key)
 	if new {
 		go func() {
-			// connect the source
+			// connect the source, until either of them stops
 			for {
-				if m, open := <-source; open {
-					sc.messages <- m
-				} else {
-					glog.Infoln("Source", source, "closed.")
+				select {
+				case m, open := <-source:
+					if !open {
+						glog.Infoln("Source", source, "closed.")
+						sc.Stop()
+						return
+					}
+					select {
+					case sc.messages <- m:
+					case <-sc.stop:
+						return
+					}
+				case <-sc.stop:
 					return
 				}
 			}
@@ -85,8 +94,14 @@ func (this *engine) MergeHttpStream(resp http.ResponseWriter, req *http.Request,
 }
 
 func (this *engine) Stop() {
+	this.lock.Lock()
+	channels := []*sseChannel{}
 	for _, s := range this.sseChannels {
-		s.stop <- 1
+		channels = append(channels, s)
+	}
+	this.lock.Unlock()
+	for _, s := range channels {
+		s.Stop()
 	}
 }
 
@@ -127,8 +142,9 @@ type sseChannel struct {
 	engine *engine
 	lock   sync.Mutex
 
-	// Send to this to stop
-	stop chan int
+	// Send to this to stop.  Closed once stopped.
+	stop    chan int
+	stopped bool
 
 	clients map[event_client]int
 
@@ -160,31 +176,36 @@ func (this *sseChannel) Init() *sseChannel {
 func (this *sseChannel) Stop() {
 	glog.V(100).Infoln("Stopping channel", this.Key)
 
-	if this.stop == nil {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	if this.stopped {
 		glog.V(100).Infoln("Stopped.")
 		return
 	}
 
-	this.lock.Lock()
-	defer this.lock.Unlock()
-
 	glog.V(100).Infoln("Closing stop", this.Key)
 	close(this.stop)
-	this.stop = nil
+	this.stopped = true
+
+	// The loop closes the clients.  The messages are not closed since sources may still be sending.
+	this.engine.deleteSseChannel(this.Key)
+}
 
-	glog.V(100).Infoln("Closing messages", this.Key)
-	close(this.messages)
-	// stop all clients
+func (this *sseChannel) closeClients() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
 	for c, _ := range this.clients {
 		glog.V(100).Infoln("Closing event client", c)
 		close(c)
 	}
-	this.engine.deleteSseChannel(this.Key)
+	this.clients = make(map[event_client]int)
 }
 
 func (this *sseChannel) Start() *sseChannel {
 	go func() {
 		defer glog.Infoln("Channel", this.Key, "Stopped.")
+		defer this.closeClients()
 		for {
 			select {
 
@@ -194,7 +215,6 @@ func (this *sseChannel) Start() *sseChannel {
 					// all sources are gone.
 					glog.V(100).Infoln("All sources gone. Closing channel:", this.Key)
 					this.Stop()
-					this.engine.deleteSseChannel(this.Key)
 					return
 				}
 			case s := <-this.newClients:
@@ -205,35 +225,40 @@ func (this *sseChannel) Start() *sseChannel {
 
 			case s := <-this.defunctClients:
 				this.lock.Lock()
-				delete(this.clients, s)
+				if _, has := this.clients[s]; has {
+					delete(this.clients, s)
+					close(s)
+				}
 				this.lock.Unlock()
-				close(s)
 				glog.V(100).Infoln("Removed client:", s)
 
 			case _, open := <-this.stop:
 				if open {
 					glog.V(100).Infoln("Received stop.", this.Key)
 					this.Stop()
-				} else {
-					glog.V(100).Infoln("Stopping channel loop.", this.Key)
-					return
 				}
-			default:
-				msg, open := <-this.messages
-				if !open || msg == nil {
-					for s, _ := range this.clients {
-						this.defunctClients <- s
-					}
-					this.stop <- 1
+				glog.V(100).Infoln("Stopping channel loop.", this.Key)
+				return
 
+			case msg := <-this.messages:
+				if msg == nil {
+					this.Stop()
 					glog.V(100).Infoln("Channel loop stopped", this.Key)
 					return // stop this
-				} else {
-					// There is a new message to send.  For each
-					// attached client, push the new message
-					// into the client's message channel.
-					for s, _ := range this.clients {
-						s <- msg
+				}
+				// There is a new message to send.  For each
+				// attached client, push the new message
+				// into the client's message channel.
+				this.lock.Lock()
+				clients := make([]event_client, 0, len(this.clients))
+				for s, _ := range this.clients {
+					clients = append(clients, s)
+				}
+				this.lock.Unlock()
+				for _, s := range clients {
+					select {
+					case s <- msg:
+					case <-this.stop:
 					}
 				}
 			}
@@ -258,22 +283,37 @@ func (this *sseChannel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Add this client to the map of those that should
 	// receive updates
-	this.newClients <- messageChan
+	select {
+	case this.newClients <- messageChan:
+	case <-this.stop:
+		http.Error(w, "Stream stopped", http.StatusServiceUnavailable)
+		return
+	}
 
 	// Listen to the closing of the http connection via the CloseNotifier
 	notify := w.(http.CloseNotifier).CloseNotify()
 	go func() {
-		<-notify
+		select {
+		case <-notify:
+		case <-this.stop:
+			return
+		}
 		// Remove this client from the map of attached clients
 		// when `EventHandler` exits.
-		this.defunctClients <- messageChan
+		select {
+		case this.defunctClients <- messageChan:
+		case <-this.stop:
+		}
 		glog.V(100).Infoln("HTTP connection just closed.")
 	}()
 
-	// Set the headers related to event streaming.
+	// Set the headers related to event streaming.  They are sent right away so the client knows
+	// it's receiving the messages from now on.
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
 
 	for {
 
@@ -303,5 +343,5 @@ func (this *sseChannel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Done.
-	glog.V(100).Infoln("Finished HTTP request at ", r.URL.Path, "num_channels=", len(this.engine.sseChannels))
+	glog.V(100).Infoln("Finished HTTP request at ", r.URL.Path)
 }
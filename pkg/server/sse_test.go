@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bufio"
+	"github.com/gorilla/mux"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSse(t *testing.T) { TestingT(t) }
+
+type TestSuiteSse struct {
+}
+
+var _ = Suite(&TestSuiteSse{})
+
+func (suite *TestSuiteSse) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteSse) TearDownSuite(c *C) {
+}
+
+func testEngine() *engine {
+	return &engine{
+		renderError:   DefaultErrorRenderer,
+		routes:        make(map[string]*methodBinding),
+		functionNames: make(map[string]*methodBinding),
+		router:        mux.NewRouter(),
+		event_chan:    make(chan *ServerEvent),
+		done_chan:     make(chan bool),
+		sseChannels:   make(map[string]*sseChannel),
+	}
+}
+
+// Serves the source as a plain text stream at /stream.
+func handleStream(e *engine, key string, source <-chan interface{}) {
+	e.Handle("/stream", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		e.MergeHttpStream(resp, req, "text/plain", "test", key, source)
+	}))
+}
+
+func (suite *TestSuiteSse) channels(e *engine) int {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return len(e.sseChannels)
+}
+
+// Reads the rest of the stream, failing if it does not end in time.
+func ends(c *C, resp *http.Response) {
+	done := make(chan error)
+	go func() {
+		_, err := ioutil.ReadAll(resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		c.Assert(err, IsNil)
+	case <-time.After(2 * time.Second):
+		c.Fatal("Stream did not end")
+	}
+}
+
+func (suite *TestSuiteSse) TestStreamEndsWithSource(c *C) {
+	e := testEngine()
+	source := make(chan interface{})
+	handleStream(e, "source", source)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	// The headers come before any message.
+	resp, err := http.Get(srv.URL + "/stream")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), Equals, "text/event-stream")
+	c.Assert(suite.channels(e), Equals, 1)
+
+	source <- "hello"
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	c.Assert(err, IsNil)
+	c.Assert(line, Equals, "hello\n")
+
+	close(source)
+	ends(c, resp)
+	c.Assert(suite.channels(e), Equals, 0)
+}
+
+func (suite *TestSuiteSse) TestServeWhileStreaming(c *C) {
+	e := testEngine()
+	source := make(chan interface{})
+	defer close(source)
+	handleStream(e, "serve", source)
+	e.Handle("/simple", http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		resp.Write([]byte("ok"))
+	}))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	stream, err := http.Get(srv.URL + "/stream")
+	c.Assert(err, IsNil)
+	defer stream.Body.Close()
+
+	// Other requests are served while the stream is open.
+	done := make(chan string)
+	go func() {
+		resp, err := http.Get(srv.URL + "/simple")
+		if err != nil {
+			done <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		done <- string(body)
+	}()
+	select {
+	case body := <-done:
+		c.Assert(body, Equals, "ok")
+	case <-time.After(2 * time.Second):
+		c.Fatal("Request blocked by the stream")
+	}
+}
+
+func (suite *TestSuiteSse) TestStop(c *C) {
+	e := testEngine()
+	source := make(chan interface{})
+	handleStream(e, "stop", source)
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/stream")
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	e.Stop()
+	e.Stop()
+	ends(c, resp)
+	c.Assert(suite.channels(e), Equals, 0)
+
+	// The source is let go, and can be closed at any time.
+	select {
+	case source <- "dropped":
+		c.Fatal("Stopped channel still reading the source")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(source)
+}
@@ -42,13 +42,17 @@ type reference struct {
 
 type cache map[Key]*reference
 
+// An allocation in progress.  Done is closed once the object is in the cache, or the allocation failed.
+type allocation struct {
+	done chan int
+}
+
 // The implementation of the referencing counting store.
 type RefCountStore struct {
 	lock             sync.Mutex
 	cache            cache
+	allocating       map[Key]*allocation
 	proposeToDispose chan Object
-	objectReferenced chan Key
-	objectCreated    chan *reference
 	done             chan int
 	stopped          chan error
 	keyFunc          KeyFunc
@@ -60,8 +64,7 @@ type RefCountStore struct {
 func NewRefCountStore(kf KeyFunc) *RefCountStore {
 	return &RefCountStore{
 		cache:            cache{},
-		objectCreated:    make(chan *reference),
-		objectReferenced: make(chan Key),
+		allocating:       map[Key]*allocation{},
 		proposeToDispose: make(chan Object),
 		done:             make(chan int),
 		stopped:          make(chan error),
@@ -93,8 +96,8 @@ func (this cache) remove(key Key) {
 // Track an object references. The alloc function takes a dispose
 // that it can later on use to notify when the object is about to be disposed and
 // get approval for actual disposal.  The alloc function should return the object to be
-// reference counted.
-func (this *RefCountStore) allocate(alloc AllocatorFunc) (Object, error) {
+// reference counted.  Called without the lock, since allocating may take a while, e.g. to connect.
+func (this *RefCountStore) allocate(alloc AllocatorFunc) (*reference, error) {
 	d := &dispose{
 		propose: this.proposeToDispose,
 		accept:  make(chan bool, 1),
@@ -103,25 +106,48 @@ func (this *RefCountStore) allocate(alloc AllocatorFunc) (Object, error) {
 	if err != nil {
 		return nil, err
 	} else {
-		ref := &reference{
+		return &reference{
 			dispose: d,
 			count:   1,
 			key:     key,
 			object:  obj,
-		}
-		this.objectCreated <- ref
-		return obj, nil
+		}, nil
 	}
 }
 
+// Concurrent gets of a key that is not in the cache wait for the first one to allocate the object,
+// so it's allocated only once.  Gets of other keys are not held up by the allocation.
 func (this *RefCountStore) Get(key Key, alloc AllocatorFunc) (Object, error) {
-	ref := this.cache.get(key)
-	if ref != nil {
-		this.objectReferenced <- ref.key
+	for {
+		this.lock.Lock()
+		if ref := this.cache.get(key); ref != nil {
+			ref.count++
+			glog.V(500).Infoln("Referencing object:", "key=", key, "ref=", ref.count)
+			this.lock.Unlock()
+			return ref.object, nil
+		}
+		if pending, has := this.allocating[key]; has {
+			this.lock.Unlock()
+			<-pending.done
+			continue // Look again, and allocate if the other get failed.
+		}
+		pending := &allocation{done: make(chan int)}
+		this.allocating[key] = pending
+		this.lock.Unlock()
+
+		ref, err := this.allocate(alloc)
+
+		this.lock.Lock()
+		delete(this.allocating, key)
+		if err == nil {
+			this.cache.add(ref.key, ref)
+		}
+		close(pending.done)
+		this.lock.Unlock()
+		if err != nil {
+			return nil, err
+		}
 		return ref.object, nil
-	} else {
-		// Allocate
-		return this.allocate(alloc)
 	}
 }
 
@@ -138,6 +164,7 @@ func (this *RefCountStore) Start() *RefCountStore {
 		for {
 			select {
 			case obj := <-this.proposeToDispose:
+				this.lock.Lock()
 				key := this.keyFunc(obj)
 				ref := this.cache.get(key)
 				if ref != nil {
@@ -153,16 +180,7 @@ func (this *RefCountStore) Start() *RefCountStore {
 				} else {
 					panic("shouldn't be here!")
 				}
-			case ref := <-this.objectCreated:
-				this.cache.add(ref.key, ref)
-			case key := <-this.objectReferenced:
-				ref := this.cache.get(key)
-				if ref != nil {
-					ref.count++
-					glog.V(500).Infoln("Referencing object:", "key=", key, "ref=", ref.count)
-				} else {
-					panic("shouldn't be here")
-				}
+				this.lock.Unlock()
 			case <-this.done:
 				break
 			}
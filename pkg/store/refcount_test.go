@@ -0,0 +1,74 @@
+package store
+
+import (
+	. "gopkg.in/check.v1"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefCount(t *testing.T) { TestingT(t) }
+
+type TestSuiteRefCount struct {
+}
+
+var _ = Suite(&TestSuiteRefCount{})
+
+func (suite *TestSuiteRefCount) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteRefCount) TearDownSuite(c *C) {
+}
+
+type object struct {
+	key string
+}
+
+func (suite *TestSuiteRefCount) TestAllocateWithoutLock(c *C) {
+	store := NewRefCountStore(func(o Object) Key { return o.(*object).key }).Start()
+
+	// The allocation of slow holds up the gets of slow only.
+	release := make(chan int)
+	allocs := int32(0)
+	slow := func(d Dispose) (Key, Object, error) {
+		atomic.AddInt32(&allocs, 1)
+		<-release
+		return "slow", &object{key: "slow"}, nil
+	}
+	var wg sync.WaitGroup
+	objects := make([]Object, 3)
+	errs := make([]error, 3)
+	for i := range objects {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			objects[i], errs[i] = store.Get("slow", slow)
+		}(i)
+	}
+	for atomic.LoadInt32(&allocs) == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan Object)
+	go func() {
+		obj, _ := store.Get("fast", func(d Dispose) (Key, Object, error) {
+			return "fast", &object{key: "fast"}, nil
+		})
+		done <- obj
+	}()
+	select {
+	case obj := <-done:
+		c.Assert(obj.(*object).key, Equals, "fast")
+	case <-time.After(time.Second):
+		c.Fatal("Get blocked by the allocation of another key")
+	}
+
+	close(release)
+	wg.Wait()
+	c.Assert(atomic.LoadInt32(&allocs), Equals, int32(1))
+	for i, obj := range objects {
+		c.Assert(errs[i], IsNil)
+		c.Assert(obj, Equals, objects[0])
+	}
+}
@@ -0,0 +1,35 @@
+// Helpers for testing registries.  Kept apart from testutil, which packages imported by namespace
+// use in their tests.
+package nstest
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"time"
+)
+
+// Collects events from the channel until it's closed, count events arrive, or no event comes
+// within the timeout.
+func Collect(events <-chan namespace.Event, count int, timeout time.Duration) []namespace.Event {
+	out := []namespace.Event{}
+	for len(out) < count {
+		select {
+		case e, open := <-events:
+			if !open {
+				return out
+			}
+			out = append(out, e)
+		case <-time.After(timeout):
+			return out
+		}
+	}
+	return out
+}
+
+// Collects the changes delivered by a Members trigger, the same way as Collect.
+func CollectMembers(events <-chan namespace.Event, count int, timeout time.Duration) []namespace.MembersChange {
+	out := []namespace.MembersChange{}
+	for _, e := range Collect(events, count, timeout) {
+		out = append(out, *e.Members)
+	}
+	return out
+}
@@ -0,0 +1,334 @@
+package union
+
+import (
+	"errors"
+	. "github.com/conductant/gohm/pkg/namespace"
+	. "github.com/conductant/gohm/pkg/store"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	net "net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const Scheme = "union"
+
+var (
+	ErrNoLayers      = errors.New("error-union-no-layers")
+	ErrBadWriteLayer = errors.New("error-union-bad-write-layer")
+	ErrUnknownUnion  = errors.New("error-union-not-defined")
+
+	definitionsLock sync.Mutex
+	definitions     = map[string]Layers{}
+)
+
+func init() {
+	Register(Scheme, NewService)
+}
+
+// The layers of a union, in priority order: reads go to Urls[0] first.  Writes go to the layer at
+// index Write.
+type Layers struct {
+	Urls  []string `json:"urls"`
+	Write int      `json:"write"`
+}
+
+// Defines the union with the name, so that union://name/some/path reads /some/path through the
+// layers, e.g. Layers{Urls: []string{"file:///etc/overrides", "zk://host:2181"}} for a local override
+// over a shared tree.  The layers can also be given in the url, as in
+// union://conf/some/path?layer=file:///etc/overrides&layer=zk://host:2181&write=0
+func Define(name string, layers Layers) {
+	definitionsLock.Lock()
+	defer definitionsLock.Unlock()
+	definitions[name] = layers
+}
+
+// A registry that layers other registries.  Reads fall through the layers in priority order, List
+// merges the children of all the layers, and writes go to the write layer only.  There are no
+// whiteouts: deleting a node in the write layer shows the node of a lower layer, if any.
+type registry struct {
+	url   net.URL
+	ctx   context.Context
+	urls  []string
+	index int // of the write layer
+	close Dispose
+
+	connect sync.Once
+	err     error
+	layers  []Registry
+	write   Registry
+
+	lock   sync.Mutex
+	closed bool
+}
+
+// The layers are dialed on first use, since a registry cannot be dialed while another is being created.
+func NewService(ctx context.Context, u net.URL, close Dispose) (Registry, error) {
+	definitionsLock.Lock()
+	layers, has := definitions[u.Host]
+	definitionsLock.Unlock()
+	if !has {
+		params := ContextGetOptions(ctx).Params
+		if len(params["layer"]) == 0 {
+			return nil, ErrUnknownUnion
+		}
+		layers.Urls = params["layer"]
+		if v := params.Get("write"); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, &BadOption{Name: "write", Value: v}
+			}
+			layers.Write = i
+		}
+	}
+	if layers.Write < 0 || layers.Write >= len(layers.Urls) {
+		return nil, ErrBadWriteLayer
+	}
+	return &registry{
+		url:   net.URL{Scheme: u.Scheme, Host: u.Host},
+		ctx:   ctx,
+		urls:  layers.Urls,
+		index: layers.Write,
+		close: close,
+	}, nil
+}
+
+// Layers the registries, highest priority first.  Closing the union closes the layers.
+func New(write int, layers ...Registry) (Registry, error) {
+	if len(layers) == 0 {
+		return nil, ErrNoLayers
+	}
+	if write < 0 || write >= len(layers) {
+		return nil, ErrBadWriteLayer
+	}
+	reg := &registry{
+		url:    net.URL{Scheme: Scheme},
+		layers: layers,
+		write:  layers[write],
+	}
+	reg.connect.Do(func() {})
+	return reg, nil
+}
+
+func (this *registry) dial() error {
+	for _, url := range this.urls {
+		reg, err := Dial(this.ctx, url)
+		if err != nil {
+			for _, r := range this.layers {
+				r.Close()
+			}
+			this.layers = nil
+			return err
+		}
+		this.layers = append(this.layers, reg)
+	}
+	this.write = this.layers[this.index]
+	return nil
+}
+
+func (this *registry) check() error {
+	this.lock.Lock()
+	closed := this.closed
+	this.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	this.connect.Do(func() { this.err = this.dial() })
+	return this.err
+}
+
+func (this *registry) Close() error {
+	ok := true
+	if this.close != nil {
+		this.close.Propose() <- this
+		ok = <-this.close.Accept()
+	}
+	if ok {
+		this.connect.Do(func() {}) // Not dialed yet, or wait for the dial in progress.
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		if !this.closed {
+			this.closed = true
+			for _, layer := range this.layers {
+				layer.Close()
+			}
+			glog.Infoln("Closed union registry", this.url.String())
+		}
+	}
+	return nil
+}
+
+func (this *registry) Id() net.URL {
+	return this.url
+}
+
+func (this *registry) Exists(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	for _, layer := range this.layers {
+		if exists, err := layer.Exists(key); err != nil || exists {
+			return exists, err
+		}
+	}
+	return false, nil
+}
+
+// Returns the node in the first layer that has it.  The version is that of the write layer, where
+// PutVersion checks it, so nodes of the other layers have InvalidVersion.
+func (this *registry) Get(key Path) ([]byte, Version, error) {
+	if err := this.check(); err != nil {
+		return nil, InvalidVersion, err
+	}
+	for _, layer := range this.layers {
+		value, version, err := layer.Get(key)
+		if err == nil && layer != this.write {
+			version = InvalidVersion
+		}
+		if err != ErrNotExist {
+			return value, version, err
+		}
+	}
+	return nil, InvalidVersion, ErrNotExist
+}
+
+func (this *registry) IsEphemeral(key Path) (bool, error) {
+	if err := this.check(); err != nil {
+		return false, err
+	}
+	for _, layer := range this.layers {
+		exists, err := layer.Exists(key)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			continue
+		}
+		if reporter, is := layer.(EphemeralReporter); is {
+			return reporter.IsEphemeral(key)
+		}
+		return false, nil
+	}
+	return false, ErrNotExist
+}
+
+// The metadata of the node in the first layer that has it, but counting the children of all the layers.
+func (this *registry) Stat(key Path) (*Stat, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	for _, layer := range this.layers {
+		stat, err := layer.Stat(key)
+		if err == ErrNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		children, err := this.List(key)
+		if err != nil {
+			return nil, err
+		}
+		stat.Children = len(children)
+		return stat, nil
+	}
+	return nil, ErrNotExist
+}
+
+// Returns the children in all the layers, sorted.
+func (this *registry) List(key Path) ([]Path, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	children, err := this.members(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		if exists, err := this.Exists(key); err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, ErrNotExist
+		}
+	}
+	list := make([]Path, len(children))
+	for i, c := range children {
+		list[i] = NewPath(c)
+	}
+	return list, nil
+}
+
+func (this *registry) members(key Path) ([]string, error) {
+	seen := map[string]bool{}
+	for _, layer := range this.layers {
+		list, err := layer.List(key)
+		if err == ErrNotExist {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, p := range list {
+			seen[p.String()] = true
+		}
+	}
+	members := []string{}
+	for p, _ := range seen {
+		members = append(members, p)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+func (this *registry) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	return this.write.Put(key, value, ephemeral)
+}
+
+// The version is checked against the node in the write layer.  At InvalidVersion, as returned by Get
+// for the nodes of the other layers, a node that is only in the other layers is copied up: it is
+// created in the write layer, or ErrNodeExists is returned if another client did so first.
+func (this *registry) PutVersion(key Path, value []byte, version Version) (Version, error) {
+	if err := this.check(); err != nil {
+		return InvalidVersion, err
+	}
+	written, err := this.write.PutVersion(key, value, version)
+	if err != ErrNotExist || version != InvalidVersion {
+		return written, err
+	}
+	if exists, err := this.Exists(key); err != nil {
+		return InvalidVersion, err
+	} else if !exists {
+		return InvalidVersion, ErrNotExist
+	}
+	results, err := this.write.Txn(OpCreate{Path: key, Value: value})
+	if txnErr, is := err.(*TxnError); is {
+		return InvalidVersion, txnErr.Err
+	} else if err != nil {
+		return InvalidVersion, err
+	}
+	return results[0].Version, nil
+}
+
+func (this *registry) Delete(key Path) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	return this.write.Delete(key)
+}
+
+func (this *registry) DeleteVersion(key Path, version Version) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	return this.write.DeleteVersion(key, version)
+}
+
+// The transaction is applied to the write layer.
+func (this *registry) Txn(ops ...Op) ([]OpResult, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	return this.write.Txn(ops...)
+}
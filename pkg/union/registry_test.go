@@ -0,0 +1,279 @@
+package union
+
+import (
+	_ "github.com/conductant/gohm/pkg/mem"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/recipes"
+	"github.com/conductant/gohm/pkg/template"
+	"golang.org/x/net/context"
+	. "gopkg.in/check.v1"
+	"testing"
+	"time"
+)
+
+var delay = 200 * time.Millisecond
+
+func TestUnion(t *testing.T) { TestingT(t) }
+
+type TestSuiteUnion struct{}
+
+var _ = Suite(&TestSuiteUnion{})
+
+func (suite *TestSuiteUnion) SetUpSuite(c *C) {
+}
+
+func (suite *TestSuiteUnion) TearDownSuite(c *C) {
+}
+
+func (suite *TestSuiteUnion) TestLayers(c *C) {
+	ctx := context.Background()
+	local, err := namespace.Dial(ctx, "mem://union-local")
+	c.Assert(err, IsNil)
+	defer local.Close()
+	shared, err := namespace.Dial(ctx, "mem://union-shared")
+	c.Assert(err, IsNil)
+	defer shared.Close()
+
+	p := namespace.NewPath("/unit-test/union")
+	_, err = shared.Put(p.Sub("a"), []byte("shared-a"), false)
+	c.Assert(err, IsNil)
+	_, err = shared.Put(p.Sub("b"), []byte("shared-b"), false)
+	c.Assert(err, IsNil)
+	_, err = local.Put(p.Sub("b"), []byte("local-b"), false)
+	c.Assert(err, IsNil)
+	_, err = local.Put(p.Sub("c"), []byte("local-c"), false)
+	c.Assert(err, IsNil)
+
+	Define("conf", Layers{Urls: []string{"mem://union-local", "mem://union-shared"}})
+	reg, err := namespace.Dial(ctx, "union://conf")
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	for k, v := range map[string]string{"a": "shared-a", "b": "local-b", "c": "local-c"} {
+		value, _, err := reg.Get(p.Sub(k))
+		c.Assert(err, IsNil)
+		c.Assert(string(value), Equals, v)
+	}
+	_, _, err = reg.Get(p.Sub("d"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+	exists, err := reg.Exists(p.Sub("a"))
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, true)
+
+	list, err := reg.List(p)
+	c.Assert(err, IsNil)
+	c.Assert(list, DeepEquals, []namespace.Path{p.Sub("a"), p.Sub("b"), p.Sub("c")})
+	_, err = reg.List(p.Sub("d"))
+	c.Assert(err, Equals, namespace.ErrNotExist)
+
+	// Writes go to the write layer, and deletes show the lower layers again
+	_, err = reg.Put(p.Sub("a"), []byte("local-a"), false)
+	c.Assert(err, IsNil)
+	value, _, err := shared.Get(p.Sub("a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "shared-a")
+	value, _, err = reg.Get(p.Sub("a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "local-a")
+	c.Assert(reg.Delete(p.Sub("a")), IsNil)
+	value, _, err = reg.Get(p.Sub("a"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "shared-a")
+
+	// Templates see the overlay
+	_, err = local.Put(p.Sub("tmpl"), []byte(`{{get "union://conf/unit-test/union/b"}}`), false)
+	c.Assert(err, IsNil)
+	applied, err := template.Execute(ctx, "union://conf/unit-test/union/tmpl")
+	c.Assert(err, IsNil)
+	c.Assert(string(applied), Equals, "local-b")
+
+	// Layers in the url
+	other, err := namespace.Dial(ctx, "union://other?layer=mem://union-shared&layer=mem://union-local&write=1")
+	c.Assert(err, IsNil)
+	defer other.Close()
+	value, _, err = other.Get(p.Sub("b"))
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "shared-b")
+
+	_, err = namespace.Dial(ctx, "union://none")
+	c.Assert(err, Equals, ErrUnknownUnion)
+	_, err = New(2, local, shared)
+	c.Assert(err, Equals, ErrBadWriteLayer)
+}
+
+func (suite *TestSuiteUnion) TestUpdateCopiesUp(c *C) {
+	ctx := context.Background()
+	top, err := namespace.Dial(ctx, "mem://union-update-top")
+	c.Assert(err, IsNil)
+	bottom, err := namespace.Dial(ctx, "mem://union-update-bottom")
+	c.Assert(err, IsNil)
+	reg, err := New(0, top, bottom)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/union/count")
+	_, err = bottom.Put(p, []byte("1"), false)
+	c.Assert(err, IsNil)
+
+	// Nodes of the other layers have no version in the union, and are copied up by the first write.
+	_, version, err := reg.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(version, Equals, namespace.InvalidVersion)
+
+	count, err := recipes.NewCounter(reg, p).Increment()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(2))
+	count, err = recipes.NewCounter(reg, p).Increment()
+	c.Assert(err, IsNil)
+	c.Assert(count, Equals, int64(3))
+
+	value, _, err := bottom.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "1")
+	value, _, err = top.Get(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(value), Equals, "3")
+
+	// Missing nodes are not created.
+	_, err = reg.PutVersion(p.Sub("missing"), []byte{}, namespace.InvalidVersion)
+	c.Assert(err, Equals, namespace.ErrNotExist)
+}
+
+func (suite *TestSuiteUnion) TestTrigger(c *C) {
+	ctx := context.Background()
+	top, err := namespace.Dial(ctx, "mem://union-top")
+	c.Assert(err, IsNil)
+	bottom, err := namespace.Dial(ctx, "mem://union-bottom")
+	c.Assert(err, IsNil)
+
+	p := namespace.NewPath("/unit-test/union/trigger")
+	_, err = top.Put(p.Sub("a"), []byte("a"), false)
+	c.Assert(err, IsNil)
+	_, err = bottom.Put(p.Sub("a"), []byte("a"), false)
+	c.Assert(err, IsNil)
+
+	reg, err := New(0, top, bottom)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	watch, stop := context.WithCancel(context.Background())
+	members, err := reg.Trigger(watch, namespace.Members{Path: p})
+	c.Assert(err, IsNil)
+	changes, err := reg.Trigger(watch, namespace.Change{Path: p.Sub("a")})
+	c.Assert(err, IsNil)
+
+	// A node added to both layers is one change of the members
+	_, err = bottom.Put(p.Sub("b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+	_, err = top.Put(p.Sub("b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+	select {
+	case e := <-members:
+		c.Assert(e.Kind, Equals, namespace.EventMembers)
+		change := e.Members
+		c.Assert(change.Before, DeepEquals, []string{p.Sub("a").String()})
+		c.Assert(change.After, DeepEquals, []string{p.Sub("a").String(), p.Sub("b").String()})
+	case <-time.After(delay):
+		c.Fatal("Should get members change")
+	}
+	select {
+	case e := <-members:
+		c.Fatal("Should not get another change", e)
+	case <-time.After(delay):
+	}
+
+	// Only the changes of the layer serving the node are seen
+	_, err = bottom.Put(p.Sub("a"), []byte("a2"), false)
+	c.Assert(err, IsNil)
+	_, err = top.Put(p.Sub("a"), []byte("a3"), false)
+	c.Assert(err, IsNil)
+	select {
+	case e := <-changes:
+		c.Assert(e.Value, DeepEquals, []byte("a3"))
+	case <-time.After(delay):
+		c.Fatal("Should get change")
+	}
+	select {
+	case e := <-changes:
+		c.Fatal("Should not see the change of the shadowed node", e)
+	case <-time.After(delay):
+	}
+	stop()
+	_, open := <-members
+	c.Assert(open, Equals, false)
+}
+
+func (suite *TestSuiteUnion) TestTriggerShadowed(c *C) {
+	ctx := context.Background()
+	top, err := namespace.Dial(ctx, "mem://union-shadow-top")
+	c.Assert(err, IsNil)
+	bottom, err := namespace.Dial(ctx, "mem://union-shadow-bottom")
+	c.Assert(err, IsNil)
+	reg, err := New(0, top, bottom)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	p := namespace.NewPath("/unit-test/union/shadow")
+	watch, stop := context.WithCancel(context.Background())
+	defer stop()
+	created, err := reg.Trigger(watch, namespace.Create{Path: p})
+	c.Assert(err, IsNil)
+	deleted, err := reg.Trigger(watch, namespace.Delete{Path: p})
+	c.Assert(err, IsNil)
+	expect := func(events <-chan namespace.Event, kind namespace.EventKind) {
+		select {
+		case e := <-events:
+			c.Assert(e.Kind, Equals, kind)
+		case <-time.After(delay):
+			c.Fatal("Should get", kind)
+		}
+	}
+	none := func(events <-chan namespace.Event) {
+		select {
+		case e := <-events:
+			c.Fatal("Should not get", e)
+		case <-time.After(delay):
+		}
+	}
+
+	// The node exists in the union from the first create to the last delete.
+	_, err = bottom.Put(p, []byte("bottom"), false)
+	c.Assert(err, IsNil)
+	expect(created, namespace.EventCreate)
+	_, err = top.Put(p, []byte("top"), false)
+	c.Assert(err, IsNil)
+	none(created)
+	c.Assert(top.Delete(p), IsNil)
+	none(deleted)
+	c.Assert(bottom.Delete(p), IsNil)
+	expect(deleted, namespace.EventDelete)
+}
+
+func (suite *TestSuiteUnion) TestTriggerMembersOfMissingNode(c *C) {
+	ctx := context.Background()
+	top, err := namespace.Dial(ctx, "mem://union-missing-top")
+	c.Assert(err, IsNil)
+	bottom, err := namespace.Dial(ctx, "mem://union-missing-bottom")
+	c.Assert(err, IsNil)
+	reg, err := New(0, top, bottom)
+	c.Assert(err, IsNil)
+	defer reg.Close()
+
+	// The node is only in the bottom layer when the trigger is set.
+	p := namespace.NewPath("/unit-test/union/missing")
+	_, err = bottom.Put(p.Sub("a"), []byte("a"), false)
+	c.Assert(err, IsNil)
+	watch, stop := context.WithCancel(context.Background())
+	defer stop()
+	members, err := reg.Trigger(watch, namespace.Members{Path: p})
+	c.Assert(err, IsNil)
+
+	_, err = top.Put(p.Sub("b"), []byte("b"), false)
+	c.Assert(err, IsNil)
+	select {
+	case e := <-members:
+		c.Assert(e.Members.After, DeepEquals, []string{p.Sub("a").String(), p.Sub("b").String()})
+	case <-time.After(delay):
+		c.Fatal("Should see the members added to the top layer")
+	}
+}
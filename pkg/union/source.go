@@ -0,0 +1,11 @@
+package union
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/resource"
+)
+
+// Binds the union protocol to the generic Source implementation in the namespace package.
+func init() {
+	resource.Register(Scheme, namespace.Source)
+}
@@ -0,0 +1,139 @@
+package union
+
+import (
+	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+	"sync"
+)
+
+// Returns the indexes of the layers that have the node, in priority order.  The first one serves it.
+func (this *registry) holders(key Path) ([]int, error) {
+	holders := []int{}
+	for i, layer := range this.layers {
+		if exists, err := layer.Exists(key); err != nil {
+			return nil, err
+		} else if exists {
+			holders = append(holders, i)
+		}
+	}
+	return holders, nil
+}
+
+// Whether the event of the layer is one of the union, given the layers that have the node after it:
+// a change of the layer that serves the node, a create in the only layer that has the node, or a
+// delete leaving no layer with the node.  The others are shadowed by higher layers, or leave the
+// node in a lower layer.
+func visible(e Event, layer int, holders []int) bool {
+	switch e.Kind {
+	case EventChange:
+		return len(holders) > 0 && holders[0] == layer
+	case EventCreate:
+		return len(holders) == 1 && holders[0] == layer
+	case EventDelete:
+		return len(holders) == 0
+	}
+	return true
+}
+
+// A layer event, with the index of the layer.
+type layerEvent struct {
+	layer int
+	event Event
+}
+
+// Sets the trigger on every layer and merges the events.  The events of the node triggers are those
+// of the union: a layer's event is delivered only if the node is not shadowed by a higher layer, see
+// visible.  Members triggers are evaluated against the merged children, so the criteria apply to the
+// union and not to the individual layers.  All the layers are watched, including those where the node
+// does not exist yet.
+func (this *registry) Trigger(ctx context.Context, t Trigger) (<-chan Event, error) {
+	if err := this.check(); err != nil {
+		return nil, err
+	}
+	if m, is := t.(*Members); is {
+		t = *m
+	}
+	var matcher *MembersMatcher
+	var path Path
+	layerTrigger := t
+	switch t := t.(type) {
+	case Create:
+		path = t.Path
+	case Change:
+		path = t.Path
+	case Delete:
+		path = t.Path
+	case Members:
+		members, err := this.members(t.Path)
+		if err != nil {
+			return nil, err
+		}
+		matcher, path = NewMembersMatcher(t, members), t.Path
+		layerTrigger = Members{Path: t.Path}
+	default:
+		return nil, ErrBadTrigger
+	}
+
+	// The layers are stopped with the trigger.  The merged channel is closed once all of them are.
+	ctx, cancel := context.WithCancel(ctx)
+	in := make(chan layerEvent)
+	var wg sync.WaitGroup
+	for i, layer := range this.layers {
+		events, err := layer.Trigger(ctx, layerTrigger)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		wg.Add(1)
+		go func(i int, events <-chan Event) {
+			defer wg.Done()
+			for e := range events {
+				select {
+				case in <- layerEvent{layer: i, event: e}:
+				case <-ctx.Done():
+				}
+			}
+		}(i, events)
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+		cancel()
+	}()
+
+	out := make(chan Event, 8)
+	go func() {
+		defer close(out)
+		for le := range in {
+			e := le.event
+			switch {
+			case matcher != nil && e.Kind == EventMembers:
+				members, err := this.members(path)
+				if err != nil {
+					glog.Warningln("Cannot list members", path, "err=", err)
+					continue
+				}
+				change := matcher.Update(members)
+				if change == nil {
+					continue
+				}
+				e = change.Event()
+			case matcher == nil:
+				holders, err := this.holders(path)
+				if err != nil {
+					glog.Warningln("Cannot find the layers of", path, "err=", err)
+					continue
+				}
+				if !visible(e, le.layer, holders) {
+					continue
+				}
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,137 @@
+package zk
+
+import (
+	"fmt"
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
+	"strings"
+)
+
+const (
+	PermRead   = zk.PermRead
+	PermWrite  = zk.PermWrite
+	PermCreate = zk.PermCreate
+	PermDelete = zk.PermDelete
+	PermAdmin  = zk.PermAdmin
+	PermAll    = zk.PermAll
+)
+
+var (
+	// Default ACL of the nodes created, unless set with the acl option of the url.
+	DefaultACL = zk.WorldACL(zk.PermAll)
+
+	permLetters = map[rune]int32{
+		'r': PermRead,
+		'w': PermWrite,
+		'c': PermCreate,
+		'd': PermDelete,
+		'a': PermAdmin,
+	}
+)
+
+// ACL operations of the zk registry.  Dial returns a registry that implements this interface, unless
+// the url has the chroot or readonly option.
+type ACLRegistry interface {
+	namespace.Registry
+
+	// Create or set, like Put.  The ACL is only applied when the node is created.
+	PutACL(namespace.Path, []byte, bool, []zk.ACL) (namespace.Version, error)
+
+	// Returns the ACL and the ACL version of the node.  The ACL version is separate from the data version.
+	GetACL(namespace.Path) ([]zk.ACL, namespace.Version, error)
+
+	// Sets the ACL with CAS on the ACL version.  Use InvalidVersion to match any version.
+	SetACL(namespace.Path, []zk.ACL, namespace.Version) (namespace.Version, error)
+}
+
+type credential struct {
+	scheme string
+	data   []byte
+}
+
+// Parses an ACL in the format of the zk cli: scheme:id:perms separated by commas, with perms made of
+// the letters rwcda.  For example, world:anyone:r,auth::cdrwa.  For the digest scheme, the id is
+// user:password and is hashed here, e.g. digest:admin:secret:cdrwa.
+func ParseACL(s string) ([]zk.ACL, error) {
+	acl := []zk.ACL{}
+	for _, entry := range strings.Split(s, ",") {
+		i, j := strings.Index(entry, ":"), strings.LastIndex(entry, ":")
+		if i < 0 || i == j {
+			return nil, fmt.Errorf("bad-acl: %s", entry)
+		}
+		scheme, id, letters := entry[:i], entry[i+1:j], entry[j+1:]
+		perms := int32(0)
+		for _, l := range letters {
+			p, has := permLetters[l]
+			if !has {
+				return nil, fmt.Errorf("bad-acl-perms: %s", entry)
+			}
+			perms |= p
+		}
+		switch scheme {
+		case "digest":
+			parts := strings.SplitN(id, ":", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("bad-acl-digest: %s", entry)
+			}
+			acl = append(acl, zk.DigestACL(perms, parts[0], parts[1])...)
+		default:
+			acl = append(acl, zk.ACL{Perms: perms, Scheme: scheme, ID: id})
+		}
+	}
+	return acl, nil
+}
+
+// Adds the credentials to the session.  The credentials are applied again every time the session is
+// reestablished.
+func (this *client) AddAuth(scheme string, data []byte) error {
+	if err := this.check(); err != nil {
+		return err
+	}
+	this.lock.Lock()
+	this.auth = append(this.auth, credential{scheme: scheme, data: data})
+	this.lock.Unlock()
+	return this.conn.AddAuth(scheme, data)
+}
+
+func (this *client) reauthenticate() {
+	this.lock.Lock()
+	credentials := append([]credential{}, this.auth...)
+	this.lock.Unlock()
+	for _, a := range credentials {
+		if err := this.conn.AddAuth(a.scheme, a.data); err != nil {
+			glog.Warningln("Cannot authenticate with scheme", a.scheme, "err=", err)
+		}
+	}
+}
+
+func (this *client) PutACL(key namespace.Path, value []byte, ephemeral bool, acl []zk.ACL) (namespace.Version, error) {
+	n, err := this.putNode(key.String(), value, ephemeral, acl)
+	if err != nil {
+		return namespace.InvalidVersion, toNamespaceError(err)
+	}
+	return namespace.Version(n.Version()), nil
+}
+
+func (this *client) GetACL(key namespace.Path) ([]zk.ACL, namespace.Version, error) {
+	if err := this.check(); err != nil {
+		return nil, namespace.InvalidVersion, toNamespaceError(err)
+	}
+	acl, stat, err := this.conn.GetACL(key.String())
+	if err != nil {
+		return nil, namespace.InvalidVersion, toNamespaceError(err)
+	}
+	return acl, namespace.Version(stat.Aversion), nil
+}
+
+func (this *client) SetACL(key namespace.Path, acl []zk.ACL, version namespace.Version) (namespace.Version, error) {
+	if err := this.check(); err != nil {
+		return namespace.InvalidVersion, toNamespaceError(err)
+	}
+	stat, err := this.conn.SetACL(key.String(), acl, int32(version))
+	if err != nil {
+		return namespace.InvalidVersion, toNamespaceError(err)
+	}
+	return namespace.Version(stat.Aversion), nil
+}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -16,6 +17,10 @@ type client struct {
 	servers []string
 	timeout time.Duration
 	events  chan Event
+	acl     []zk.ACL // default for the nodes created
+
+	lock sync.Mutex
+	auth []credential
 
 	ephemeral        map[string]*Node
 	ephemeral_add    chan *Node
@@ -35,6 +40,7 @@ type client struct {
 }
 
 func (this *client) on_connect() {
+	this.reauthenticate()
 	for _, n := range this.ephemeral {
 		this.retry <- n
 	}
@@ -64,6 +70,7 @@ func Connect(servers []string, timeout time.Duration) (*client, error) {
 		conn:             conn,
 		servers:          servers,
 		timeout:          timeout,
+		acl:              DefaultACL,
 		events:           make(chan Event, 4096),
 		stop:             make(chan int),
 		ephemeral:        map[string]*Node{},
@@ -143,7 +150,7 @@ func Connect(servers []string, timeout time.Duration) (*client, error) {
 			select {
 			case r := <-zz.retry:
 				if r != nil {
-					_, err := zz.CreateNode(r.Path, r.Value, true)
+					_, err := zz.createNodeACL(r.Path, r.Value, true, r.acl)
 					switch err {
 					case nil, ErrNodeExists:
 						glog.Infoln("emphemeral-resync: Key=", r.Path, "retry ok.")
@@ -405,6 +412,15 @@ func (this *client) watch(cf getChan, p string, f callBack, errs ...chan<- error
 							}
 						}
 						this.events <- Event{Event: zk.Event{Path: p}, Action: "watch-retry", Note: "retrying"}
+						select {
+						case <-time.After(WatchRetryDelay):
+							continue
+						case <-stop:
+						case <-this.stop:
+						}
+						glog.Infoln("watch: Watch terminated while retrying:", "path=", p)
+						bufferedChan <- nil
+						return
 					}
 				}
 
@@ -413,6 +429,11 @@ func (this *client) watch(cf getChan, p string, f callBack, errs ...chan<- error
 				// Send a nil to the processing goroutine to stop it.
 				bufferedChan <- nil
 				return
+
+			case <-this.stop:
+				glog.Infoln("watch: Client shut down:", "path=", p)
+				bufferedChan <- nil
+				return
 			}
 		}
 	}()
@@ -421,15 +442,22 @@ func (this *client) watch(cf getChan, p string, f callBack, errs ...chan<- error
 
 // Creates a new node.  If node already exists, error will be returned.
 func (this *client) CreateNode(path string, value []byte, ephemeral bool) (*Node, error) {
+	return this.createNodeACL(path, value, ephemeral, this.acl)
+}
+
+func (this *client) createNodeACL(path string, value []byte, ephemeral bool, acl []zk.ACL) (*Node, error) {
 	if err := this.check(); err != nil {
 		return nil, err
 	}
+	if acl == nil {
+		acl = this.acl
+	}
 	// Make sure all parents exist - they can't be ephemeral
 	err := this.createParents(path)
 	if err != nil {
 		return nil, err
 	}
-	return this.createNode(path, value, ephemeral)
+	return this.createNode(path, value, ephemeral, acl)
 }
 
 func listParents(path string) []string {
@@ -459,7 +487,7 @@ func (this *client) createParents(path string) error {
 			return err
 		}
 		if !exists {
-			_, err := this.createNode(p, []byte{}, false)
+			_, err := this.createNode(p, []byte{}, false, this.acl)
 			if err != nil {
 				return err
 			}
@@ -469,26 +497,29 @@ func (this *client) createParents(path string) error {
 }
 
 // Assumes all parent nodes have been created.
-func (this *client) createNode(path string, value []byte, ephemeral bool) (*Node, error) {
+func (this *client) createNode(path string, value []byte, ephemeral bool, acl []zk.ACL) (*Node, error) {
 	key := path
 	flags := int32(0)
 	if ephemeral {
 		flags = int32(zk.FlagEphemeral)
 	}
-	acl := zk.WorldACL(zk.PermAll) // TODO - PermAll permission
 	p, err := this.conn.Create(key, value, flags, acl)
 	if err != nil {
 		return nil, err
 	}
-	zn := &Node{Path: p, Value: value, client: this}
+	zn := &Node{Path: p, Value: value, client: this, acl: acl}
 	this.trackEphemeral(zn, ephemeral)
 	return this.GetNode(p)
 }
 
 // Sets the node value, creates if not exists.
 func (this *client) PutNode(key string, value []byte, ephemeral bool) (*Node, error) {
+	return this.putNode(key, value, ephemeral, this.acl)
+}
+
+func (this *client) putNode(key string, value []byte, ephemeral bool, acl []zk.ACL) (*Node, error) {
 	if ephemeral {
-		n, err := this.CreateNode(key, value, true)
+		n, err := this.createNodeACL(key, value, true, acl)
 		return n, err
 	}
 	n, err := this.GetNode(key)
@@ -496,7 +527,7 @@ func (this *client) PutNode(key string, value []byte, ephemeral bool) (*Node, er
 	case nil:
 		return n, n.Set(value)
 	case ErrNotExist:
-		n, err = this.CreateNode(key, value, false)
+		n, err = this.createNodeACL(key, value, false, acl)
 		if err != nil {
 			return nil, err
 		} else {
@@ -2,6 +2,7 @@ package zk
 
 import (
 	"errors"
+	"github.com/conductant/gohm/pkg/namespace"
 	"github.com/samuel/go-zookeeper/zk"
 )
 
@@ -23,4 +24,25 @@ var (
 	ErrClosing                 = zk.ErrClosing
 	ErrNothing                 = zk.ErrNothing
 	ErrSessionMoved            = zk.ErrSessionMoved
+	ErrNoServer                = zk.ErrNoServer
 )
+
+// The namespace.Registry methods return the backend-neutral errors of the namespace package
+// so that callers can check errors the same way for all backends.
+func toNamespaceError(err error) error {
+	switch err {
+	case ErrNotExist:
+		return namespace.ErrNotExist
+	case ErrNodeExists:
+		return namespace.ErrNodeExists
+	case ErrBadVersion:
+		return namespace.ErrBadVersion
+	case ErrNotEmpty:
+		return namespace.ErrNotEmpty
+	case ErrNoChildrenForEphemerals:
+		return namespace.ErrNoChildrenForEphemerals
+	case ErrNotConnected, ErrClosing:
+		return namespace.ErrClosed
+	}
+	return err
+}
@@ -218,37 +218,41 @@ func (this *Node) Delete() error {
 	}
 }
 
+// Adds to the count kept as the value of the node, which is set with the version it was read at.  If
+// someone else changed the value in the meantime, the node is loaded again and the update retried.
 func (this *Node) Increment(increment int) (int, error) {
-	if err := this.client.check(); err != nil {
-		return -1, err
-	}
-	count, err := strconv.Atoi(this.ValueString())
-	if err != nil {
-		count = 0
-	}
-	count += increment
-	err = this.Set([]byte(strconv.Itoa(count)))
-	if err != nil {
-		return -1, err
-	}
-	return count, nil
+	return this.increment(nil, increment)
 }
 
+// Like Increment, but fails with ErrConflict unless the count is the current value.
 func (this *Node) CheckAndIncrement(current, increment int) (int, error) {
+	return this.increment(&current, increment)
+}
+
+func (this *Node) increment(current *int, increment int) (int, error) {
 	if err := this.client.check(); err != nil {
 		return -1, err
 	}
-	count, err := strconv.Atoi(this.ValueString())
-	switch {
-	case err != nil:
-		return -1, err
-	case count != current:
-		return -1, ErrConflict
-	}
-	count += increment
-	err = this.Set([]byte(strconv.Itoa(count)))
-	if err != nil {
-		return -1, err
+	for {
+		count, err := strconv.Atoi(this.ValueString())
+		switch {
+		case err != nil && current != nil:
+			return -1, err
+		case err != nil:
+			count = 0
+		case current != nil && count != *current:
+			return -1, ErrConflict
+		}
+		count += increment
+		err = this.Set([]byte(strconv.Itoa(count)))
+		if err == ErrBadVersion {
+			if err := this.Load(); err != nil {
+				return -1, err
+			}
+			continue
+		} else if err != nil {
+			return -1, err
+		}
+		return count, nil
 	}
-	return count, nil
 }
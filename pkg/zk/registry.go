@@ -2,17 +2,19 @@ package zk
 
 import (
 	"fmt"
-	. "github.com/conductant/gohm/pkg/namespace"
+	"github.com/conductant/gohm/pkg/namespace"
 	. "github.com/conductant/gohm/pkg/store"
 	"github.com/golang/glog"
+	"github.com/samuel/go-zookeeper/zk"
 	"golang.org/x/net/context"
 	"net/url"
 	"strings"
+	"time"
 )
 
 func init() {
-	Register("zk", NewService)
-	RegisterSanitizer("zk", SanitizeUrl)
+	namespace.Register("zk", NewService)
+	namespace.RegisterSanitizer("zk", SanitizeUrl)
 }
 
 func SanitizeUrl(url url.URL) url.URL {
@@ -26,10 +28,25 @@ func SanitizeUrl(url url.URL) url.URL {
 	}
 }
 
-// Optional parameter is timeout, in Duration.
-func NewService(ctx context.Context, url url.URL, close Dispose) (Registry, error) {
+// Optional parameter is timeout, in Duration.  The timeout option of the url takes precedence.
+// The user and password of the url, as in zk://user:password@host:2181, are used for digest auth, and
+// the acl option sets the ACL of the nodes created, in the format of ParseACL.  For example,
+// zk://admin:secret@host:2181/?acl=auth::cdrwa,world:anyone:r
+func NewService(ctx context.Context, url url.URL, close Dispose) (namespace.Registry, error) {
 	// Look for a duration and use that as the timeout
 	timeout := ContextGetTimeout(ctx)
+	opts := namespace.ContextGetOptions(ctx)
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	acl := DefaultACL
+	if v := opts.Params.Get("acl"); v != "" {
+		parsed, err := ParseACL(v)
+		if err != nil {
+			return nil, &namespace.BadOption{Name: "acl", Value: v}
+		}
+		acl = parsed
+	}
 	servers := strings.Split(url.Host, ",") // host:port,host:port,...
 	if len(servers) == 0 {
 		panic(fmt.Errorf("No zk servers"))
@@ -38,15 +55,29 @@ func NewService(ctx context.Context, url url.URL, close Dispose) (Registry, erro
 	if err != nil {
 		return nil, err
 	}
+	cl.acl = acl
+	if url.User != nil {
+		// The id has the user so that sessions with different credentials are not shared.
+		cl.url.User = withoutPassword(url.User)
+		password, _ := url.User.Password()
+		if err := cl.AddAuth("digest", []byte(url.User.Username()+":"+password)); err != nil {
+			cl.Close()
+			return nil, err
+		}
+	}
 	cl.close = close
 	return cl, err
 }
 
+func withoutPassword(user *url.Userinfo) *url.Userinfo {
+	return url.User(user.Username())
+}
+
 func (this *client) Id() url.URL {
 	return this.url
 }
 
-func (this *client) Exists(key Path) (bool, error) {
+func (this *client) Exists(key namespace.Path) (bool, error) {
 	_, err := this.GetNode(key.String())
 	switch err {
 	case ErrNotExist:
@@ -54,116 +85,226 @@ func (this *client) Exists(key Path) (bool, error) {
 	case nil:
 		return true, nil
 	default:
-		return false, err
+		return false, toNamespaceError(err)
 	}
 }
 
-func (this *client) Get(key Path) ([]byte, Version, error) {
+func (this *client) Get(key namespace.Path) ([]byte, namespace.Version, error) {
 	n, err := this.GetNode(key.String())
 	if err != nil {
-		return nil, InvalidVersion, err
+		return nil, namespace.InvalidVersion, toNamespaceError(err)
 	}
-	return n.Value, Version(n.Version()), nil
+	return n.Value, namespace.Version(n.Version()), nil
 }
 
-func (this *client) List(key Path) ([]Path, error) {
+func (this *client) IsEphemeral(key namespace.Path) (bool, error) {
 	n, err := this.GetNode(key.String())
 	if err != nil {
-		return nil, err
+		return false, toNamespaceError(err)
+	}
+	return n.Stats != nil && n.Stats.EphemeralOwner > 0, nil
+}
+
+// Same as the Stats of the Node, for any backend.  The owner is the session id, in hex.
+func (this *client) Stat(key namespace.Path) (*namespace.Stat, error) {
+	n, err := this.GetNode(key.String())
+	if err != nil {
+		return nil, toNamespaceError(err)
+	}
+	if n.Stats == nil {
+		return &namespace.Stat{Version: namespace.InvalidVersion, Size: len(n.Value)}, nil
+	}
+	stat := &namespace.Stat{
+		Version:   namespace.Version(n.Stats.Version),
+		Created:   time.Unix(0, n.Stats.Ctime*int64(time.Millisecond)),
+		Modified:  time.Unix(0, n.Stats.Mtime*int64(time.Millisecond)),
+		Size:      int(n.Stats.DataLength),
+		Children:  int(n.Stats.NumChildren),
+		Ephemeral: n.Stats.EphemeralOwner > 0,
+	}
+	if stat.Ephemeral {
+		stat.Owner = fmt.Sprintf("0x%x", n.Stats.EphemeralOwner)
+	}
+	return stat, nil
+}
+
+func (this *client) List(key namespace.Path) ([]namespace.Path, error) {
+	n, err := this.GetNode(key.String())
+	if err != nil {
+		return nil, toNamespaceError(err)
 	}
 	children, err := n.Children()
 	if err != nil {
-		return nil, err
+		return nil, toNamespaceError(err)
 	}
-	paths := []Path{}
+	paths := []namespace.Path{}
 	for _, n := range children {
-		paths = append(paths, NewPath(n.Path))
+		paths = append(paths, namespace.NewPath(n.Path))
 	}
 	return paths, nil
 }
 
-func (this *client) Delete(key Path) error {
-	return this.DeleteNode(key.String())
+func (this *client) Delete(key namespace.Path) error {
+	return toNamespaceError(this.DeleteNode(key.String()))
 }
 
-func (this *client) DeleteVersion(key Path, version Version) error {
-	return this.conn.Delete(key.String(), int32(version))
+func (this *client) DeleteVersion(key namespace.Path, version namespace.Version) error {
+	return toNamespaceError(this.conn.Delete(key.String(), int32(version)))
 }
 
-func (this *client) Put(key Path, value []byte, ephemeral bool) (Version, error) {
+func (this *client) Put(key namespace.Path, value []byte, ephemeral bool) (namespace.Version, error) {
 	n, err := this.PutNode(key.String(), value, ephemeral)
 	if err != nil {
-		return InvalidVersion, err
+		return namespace.InvalidVersion, toNamespaceError(err)
 	}
-	return Version(n.Version()), nil
+	return namespace.Version(n.Version()), nil
 }
 
-func (this *client) PutVersion(key Path, value []byte, version Version) (Version, error) {
+func (this *client) PutVersion(key namespace.Path, value []byte, version namespace.Version) (namespace.Version, error) {
 	stat, err := this.conn.Set(key.String(), value, int32(version))
 	if err != nil {
-		return InvalidVersion, err
+		return namespace.InvalidVersion, toNamespaceError(err)
 	} else {
-		return Version(stat.Version), nil
+		return namespace.Version(stat.Version), nil
 	}
 }
 
-func (this *client) Trigger(t Trigger) (<-chan interface{}, chan<- int, error) {
-	stop := make(chan int)
-	events := make(chan interface{}, 8)
+// Returns the full paths of the children.  A node that does not exist has no members.
+func (this *client) members(key namespace.Path) ([]string, error) {
+	names, _, err := this.conn.Children(key.String())
+	switch err {
+	case nil:
+	case ErrNotExist:
+		return []string{}, nil
+	default:
+		return nil, toNamespaceError(err)
+	}
+	members := []string{}
+	for _, name := range names {
+		members = append(members, key.Sub(name).String())
+	}
+	return members, nil
+}
 
+// Session changes seen by the watch are delivered as EventSession events.  Other errors setting the
+// watch again end the trigger with an EventError.  Zk does not report the value or the version of the
+// node with the watch events, so they are nil and InvalidVersion.
+func (this *client) Trigger(ctx context.Context, t namespace.Trigger) (<-chan namespace.Event, error) {
+	if m, is := t.(*namespace.Members); is {
+		t = *m
+	}
+	events := make(chan namespace.Event, 8)
+	deliver := func(e namespace.Event) {
+		select {
+		case events <- e:
+		case <-ctx.Done():
+		case <-this.stop:
+		}
+	}
+	node := func(kind namespace.EventKind, zkType zk.EventType, path namespace.Path) func(Event) {
+		return func(e Event) {
+			if e.Type == zkType {
+				deliver(namespace.Event{Kind: kind, Path: path.String(), Version: namespace.InvalidVersion})
+			}
+		}
+	}
+
+	errs := make(chan error, 1)
 	var cStop chan<- int
 	var cStopped <-chan error
 	var err error
+	var path namespace.Path
 	switch t := t.(type) {
-	case Create:
-		cStop, cStopped, err = this.Watch(t.Path.String(),
-			func(e Event) {
-				if e.Type == EventNodeCreated {
-					events <- e
-				}
-			})
-		if err != nil {
-			return nil, nil, err
+	case namespace.Create:
+		path = t.Path
+		cStop, cStopped, err = this.Watch(path.String(), node(namespace.EventCreate, EventNodeCreated, path), errs)
+	case namespace.Change:
+		path = t.Path
+		cStop, cStopped, err = this.Watch(path.String(), node(namespace.EventChange, EventNodeDataChanged, path), errs)
+	case namespace.Delete:
+		path = t.Path
+		cStop, cStopped, err = this.Watch(path.String(), node(namespace.EventDelete, EventNodeDeleted, path), errs)
+	case namespace.Members:
+		path = t.Path
+		var members []string
+		if members, err = this.members(path); err != nil {
+			return nil, err
 		}
-	case Change:
-		cStop, cStopped, err = this.Watch(t.Path.String(),
+		matcher := namespace.NewMembersMatcher(t, members)
+		cStop, cStopped, err = this.WatchChildren(path.String(),
 			func(e Event) {
-				if e.Type == EventNodeDataChanged {
-					events <- e
+				if e.Type != EventNodeChildrenChanged {
+					return
 				}
-			})
-		if err != nil {
-			return nil, nil, err
-		}
-	case Delete:
-		cStop, cStopped, err = this.Watch(t.Path.String(),
-			func(e Event) {
-				if e.Type == EventNodeDeleted {
-					events <- e
+				members, err := this.members(path)
+				if err != nil {
+					glog.Warningln("Cannot list members", path, "err=", err)
+					return
 				}
-			})
-		if err != nil {
-			return nil, nil, err
-		}
-	case Members:
-		// TODO - Implement the matching criteria using min/max/delta, etc.
-		cStop, cStopped, err = this.WatchChildren(t.Path.String(),
-			func(e Event) {
-				if e.Type == EventNodeChildrenChanged {
-					events <- e
+				if change := matcher.Update(members); change != nil {
+					deliver(change.Event())
 				}
-			})
-		if err != nil {
-			return nil, nil, err
-		}
+			}, errs)
+	default:
+		return nil, namespace.ErrBadTrigger
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	quit := make(chan int)
+	sessions := make(chan int)
+	failed := make(chan int)
+	var failure error
 	go func() {
-		// Stop the watch
-		c := <-stop
-		cStop <- c
-		glog.Infoln("Waiting for user callbacks to finish")
-		<-cStopped
+		defer close(sessions)
+		for {
+			select {
+			case err := <-errs:
+				switch err {
+				case ErrSessionExpired:
+					deliver(namespace.Event{Kind: namespace.EventSession, Path: path.String(), State: "expired"})
+				case ErrConnectionClosed, ErrNoServer:
+					deliver(namespace.Event{Kind: namespace.EventSession, Path: path.String(), State: "disconnected"})
+				case ErrClosing:
+					// The client is shutting down and stops the watch.
+				default:
+					glog.Warningln("Watch of", path, "failed. err=", err)
+					if failure == nil {
+						failure = toNamespaceError(err)
+						close(failed)
+					}
+				}
+			case <-quit:
+				return
+			}
+		}
+	}()
+	go func() {
+		// The watch stops when the context is done, the watch fails or the client shuts down.
+		select {
+		case <-ctx.Done():
+		case <-failed:
+		case <-cStopped:
+			cStopped = nil
+		}
+		if cStopped != nil {
+			select {
+			case cStop <- 1:
+				glog.Infoln("Waiting for user callbacks to finish")
+				<-cStopped
+			case <-cStopped:
+			}
+		}
+		close(quit)
+		<-sessions
+		// No more callbacks, so the error is the last event.
+		if failure != nil {
+			deliver(namespace.Event{Kind: namespace.EventError, Path: path.String(),
+				Version: namespace.InvalidVersion, Err: failure})
+		}
+		close(events)
 		glog.Infoln("Stopped.")
 	}()
-	return events, stop, nil
+	return events, nil
 }
@@ -3,6 +3,7 @@ package zk
 import (
 	"fmt"
 	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/samuel/go-zookeeper/zk"
 	"golang.org/x/net/context"
 	. "gopkg.in/check.v1"
 	net "net/url"
@@ -97,7 +98,7 @@ func (suite *TestSuiteRegistry) DISABLED_TestEphemeral(c *C) {
 	zk, err = namespace.Dial(ctx, url)
 	c.Assert(err, IsNil)
 	_, _, err = zk.Get(p)
-	c.Assert(err, Equals, ErrNotExist)
+	c.Assert(err, Equals, namespace.ErrNotExist)
 	exists, _ = zk.Exists(p)
 	c.Assert(exists, Equals, false)
 	zk.Close()
@@ -128,7 +129,7 @@ func (suite *TestSuiteRegistry) TestVersions(c *C) {
 
 	// now try to delete with outdated version number
 	err = zk.DeleteVersion(p, version)
-	c.Assert(err, Equals, ErrBadVersion)
+	c.Assert(err, Equals, namespace.ErrBadVersion)
 
 	// read again
 	cv, version4, err := zk.Get(p)
@@ -141,7 +142,7 @@ func (suite *TestSuiteRegistry) TestVersions(c *C) {
 	c.Assert(err, IsNil)
 
 	_, _, err = zk.Get(p)
-	c.Assert(err, Equals, ErrNotExist)
+	c.Assert(err, Equals, namespace.ErrNotExist)
 }
 
 func (suite *TestSuiteRegistry) TestFollow(c *C) {
@@ -186,34 +187,25 @@ func (suite *TestSuiteRegistry) TestTriggerCreate(c *C) {
 
 	p := namespace.NewPath(fmt.Sprintf("/unit-test/namespace.%d/trigger/create", time.Now().Unix()))
 
-	created, stop, err := zk.Trigger(namespace.Create{Path: p})
+	watch, stop := context.WithCancel(ctx)
+	created, err := zk.Trigger(watch, namespace.Create{Path: p})
 	c.Assert(err, IsNil)
 
 	count := new(int)
-	done := make(chan int)
 	go func() {
-		for {
-			select {
-			case e := <-created:
-				*count++
-				c.Log("**** Got event:", e, " count=", *count)
-			case <-done:
-				break
-			}
+		for e := range created {
+			*count++
+			c.Log("**** Got event:", e, " count=", *count)
 		}
 	}()
 
 	_, err = zk.Put(p, []byte{1}, false)
 	c.Assert(err, IsNil)
 
-	time.Sleep(delay)
-
-	done <- 1
-
-	time.Sleep(delay)
+	time.Sleep(delay * 2)
 
 	c.Assert(*count, Equals, 1)
-	stop <- 1
+	stop()
 }
 
 func (suite *TestSuiteRegistry) TestTriggerDelete(c *C) {
@@ -226,7 +218,8 @@ func (suite *TestSuiteRegistry) TestTriggerDelete(c *C) {
 
 	p := namespace.NewPath(fmt.Sprintf("/unit-test/registry/%d/trigger/delete", time.Now().Unix()))
 
-	deleted, stop, err := zk.Trigger(namespace.Delete{Path: p})
+	watch, stop := context.WithCancel(ctx)
+	deleted, err := zk.Trigger(watch, namespace.Delete{Path: p})
 	c.Assert(err, IsNil)
 
 	count := new(int)
@@ -249,7 +242,7 @@ func (suite *TestSuiteRegistry) TestTriggerDelete(c *C) {
 
 	time.Sleep(delay)
 
-	stop <- 1
+	stop()
 
 	time.Sleep(delay)
 
@@ -266,20 +259,15 @@ func (suite *TestSuiteRegistry) TestTriggerChange(c *C) {
 
 	p := namespace.NewPath(fmt.Sprintf("/unit-test/registry/%d/trigger/change", time.Now().Unix()))
 
-	changed, stop, err := zk.Trigger(namespace.Change{Path: p})
+	watch, stop := context.WithCancel(ctx)
+	changed, err := zk.Trigger(watch, namespace.Change{Path: p})
 	c.Assert(err, IsNil)
 
 	count := new(int)
-	done := make(chan int)
 	go func() {
-		for {
-			select {
-			case e := <-changed:
-				*count++
-				c.Log("**** Got event:", e, " count=", *count)
-			case <-done:
-				break
-			}
+		for e := range changed {
+			*count++
+			c.Log("**** Got event:", e, " count=", *count)
 		}
 	}()
 
@@ -307,12 +295,10 @@ func (suite *TestSuiteRegistry) TestTriggerChange(c *C) {
 
 	time.Sleep(delay * 2)
 
-	stop <- 1
+	stop()
 
 	time.Sleep(delay * 2)
 
-	done <- 1
-
 	if *count < 3 {
 		panic("Should be at least 3 events... sometimes zk will send 4 changes.")
 	}
@@ -333,13 +319,13 @@ func (suite *TestSuiteRegistry) TestTriggerMembers(c *C) {
 
 	time.Sleep(delay)
 
-	members, stop, err := zk.Trigger(namespace.Members{Path: p})
+	watch, stop := context.WithCancel(ctx)
+	members, err := zk.Trigger(watch, namespace.Members{Path: p})
 	c.Assert(err, IsNil)
 
 	count := new(int)
 	go func() {
-		for {
-			e := <-members
+		for e := range members {
 			*count++
 			c.Log("**** Got event:", e, " count=", *count)
 		}
@@ -364,6 +350,81 @@ func (suite *TestSuiteRegistry) TestTriggerMembers(c *C) {
 	c.Assert(err, IsNil)
 
 	time.Sleep(delay * 2)
-	stop <- 1
+	stop()
 	c.Assert(*count, Equals, 4)
 }
+
+func (suite *TestSuiteRegistry) TestParseACL(c *C) {
+	acl, err := ParseACL("world:anyone:r,auth::cdrwa,ip:10.0.0.1:rw,digest:admin:secret:a")
+	c.Assert(err, IsNil)
+	c.Assert(len(acl), Equals, 4)
+	c.Assert(acl[0].Perms, Equals, int32(PermRead))
+	c.Assert(acl[1].Scheme, Equals, "auth")
+	c.Assert(acl[1].Perms, Equals, int32(PermAll))
+	c.Assert(acl[2].ID, Equals, "10.0.0.1")
+	c.Assert(acl[2].Perms, Equals, int32(PermRead|PermWrite))
+	c.Assert(strings.HasPrefix(acl[3].ID, "admin:"), Equals, true)
+	c.Assert(acl[3].ID, Not(Equals), "admin:secret")
+
+	for _, bad := range []string{"world", "world:anyone", "world:anyone:x", "digest:admin:a"} {
+		_, err = ParseACL(bad)
+		c.Assert(err, Not(IsNil))
+	}
+}
+
+func (suite *TestSuiteRegistry) TestACL(c *C) {
+	ctx := ContextPutTimeout(context.Background(), 1*time.Minute)
+	hosts := strings.Join(Hosts(), ",")
+	admin, err := namespace.Dial(ctx, "zk://admin:secret@"+hosts+"/?acl=auth::cdrwa,world:anyone:r")
+	c.Assert(err, IsNil)
+	defer admin.Close()
+	anonymous, err := namespace.Dial(ctx, "zk://"+hosts)
+	c.Assert(err, IsNil)
+	defer anonymous.Close()
+	c.Assert(admin, Not(Equals), anonymous)
+
+	p := namespace.NewPath(fmt.Sprintf("/unit-test/registry/%d/acl", time.Now().Unix()))
+	_, err = admin.Put(p.Sub("protected"), []byte("admin"), false)
+	c.Assert(err, IsNil)
+
+	// Readable but not writable by others
+	value, _, err := anonymous.Get(p.Sub("protected"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("admin"))
+	_, err = anonymous.Put(p.Sub("protected"), []byte("anonymous"), false)
+	c.Assert(err, Equals, ErrNoAuth)
+
+	// ACL per node
+	acl := admin.(ACLRegistry)
+	_, err = acl.PutACL(p.Sub("private"), []byte("private"), false, zk.AuthACL(PermAll))
+	c.Assert(err, IsNil)
+	_, _, err = anonymous.Get(p.Sub("private"))
+	c.Assert(err, Equals, ErrNoAuth)
+
+	current, version, err := acl.GetACL(p.Sub("private"))
+	c.Assert(err, IsNil)
+	c.Assert(len(current), Equals, 1)
+	_, err = acl.SetACL(p.Sub("private"), zk.WorldACL(PermRead), version)
+	c.Assert(err, IsNil)
+	value, _, err = anonymous.Get(p.Sub("private"))
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("private"))
+}
+
+func (suite *TestSuiteRegistry) TestSequential(c *C) {
+	ctx := ContextPutTimeout(context.Background(), 1*time.Minute)
+	zk, err := namespace.Dial(ctx, "zk://"+strings.Join(Hosts(), ","))
+	c.Assert(err, IsNil)
+	defer zk.Close()
+
+	p := namespace.NewPath(fmt.Sprintf("/unit-test/registry/%d/sequential", time.Now().Unix()))
+	first, err := namespace.CreateSequential(zk, p.Sub("job-"), []byte("1"), false)
+	c.Assert(err, IsNil)
+	c.Assert(first.String(), Equals, p.Sub("job-0000000000").String())
+	second, err := namespace.CreateSequential(zk, p.Sub("job-"), []byte("2"), true)
+	c.Assert(err, IsNil)
+	c.Assert(second.String(), Equals, p.Sub("job-0000000001").String())
+	value, _, err := zk.Get(second)
+	c.Assert(err, IsNil)
+	c.Assert(value, DeepEquals, []byte("2"))
+}
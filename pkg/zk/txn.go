@@ -0,0 +1,164 @@
+package zk
+
+import (
+	"github.com/conductant/gohm/pkg/namespace"
+	"github.com/samuel/go-zookeeper/zk"
+	"path/filepath"
+)
+
+// Applies the operations using zk multi.  Missing parents of the created nodes are created before
+// the multi is sent and are not removed if the multi fails.
+func (this *client) Txn(ops ...namespace.Op) ([]namespace.OpResult, error) {
+	if err := this.check(); err != nil {
+		return nil, toNamespaceError(err)
+	}
+	created := map[string]bool{}
+	requests := []interface{}{}
+	for _, op := range ops {
+		switch op := op.(type) {
+		case namespace.OpCheck:
+			requests = append(requests, &zk.CheckVersionRequest{
+				Path: op.Path.String(), Version: int32(op.Version)})
+		case namespace.OpCreate:
+			if err := this.createTxnParents(op.Path.String(), created); err != nil {
+				return namespace.AbortTxn(ops, -1, toNamespaceError(err))
+			}
+			created[op.Path.String()] = true
+			flags := int32(0)
+			if op.Ephemeral {
+				flags |= int32(zk.FlagEphemeral)
+			}
+			if op.Sequential {
+				flags |= int32(zk.FlagSequence)
+			}
+			requests = append(requests, &zk.CreateRequest{
+				Path: op.Path.String(), Data: op.Value, Acl: this.acl, Flags: flags})
+		case namespace.OpSet:
+			requests = append(requests, &zk.SetDataRequest{
+				Path: op.Path.String(), Data: op.Value, Version: int32(op.Version)})
+		case namespace.OpDelete:
+			requests = append(requests, &zk.DeleteRequest{
+				Path: op.Path.String(), Version: int32(op.Version)})
+		}
+	}
+	responses, err := this.conn.Multi(requests...)
+	if err != nil {
+		// The multi response does not say which op failed, so find out by replaying the ops.
+		index, cause := this.diagnoseTxn(ops)
+		if cause == nil {
+			cause = toNamespaceError(err)
+		}
+		return namespace.AbortTxn(ops, index, cause)
+	}
+	results := make([]namespace.OpResult, len(ops))
+	for i, op := range ops {
+		results[i] = namespace.OpResult{Path: namespace.PathOf(op), Version: namespace.InvalidVersion}
+		switch op := op.(type) {
+		case namespace.OpCheck:
+			results[i].Version = op.Version
+			if op.Version == namespace.InvalidVersion {
+				if _, stat, err := this.conn.Exists(op.Path.String()); err == nil && stat != nil {
+					results[i].Version = namespace.Version(stat.Version)
+				}
+			}
+		case namespace.OpCreate:
+			results[i].Version = 0
+			if op.Sequential && i < len(responses) {
+				results[i].Path = namespace.NewPath(responses[i].String)
+			}
+			if op.Ephemeral {
+				this.trackEphemeral(&Node{Path: results[i].Path.String(), Value: op.Value, client: this}, true)
+			}
+		case namespace.OpSet:
+			if i < len(responses) && responses[i].Stat != nil {
+				results[i].Version = namespace.Version(responses[i].Stat.Version)
+			}
+		case namespace.OpDelete:
+			this.untrackEphemeral(op.Path.String())
+		}
+	}
+	return results, nil
+}
+
+// Creates the missing parents of the path, except the ones that are created in the transaction.
+func (this *client) createTxnParents(path string, created map[string]bool) error {
+	dir := filepath.Dir(path)
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	for _, p := range listParents(dir) {
+		if created[p] {
+			return nil
+		}
+		exists, _, err := this.conn.Exists(p)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := this.conn.Create(p, []byte{}, 0, this.acl); err != nil && err != ErrNodeExists {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Replays the operations against the current state to find the first one that fails.
+// Returns -1 and nil if none of them fails.
+func (this *client) diagnoseTxn(ops []namespace.Op) (int, error) {
+	overlay := map[string]int32{} // path -> version, -1 if deleted in the txn
+	version := func(path string) (int32, bool, error) {
+		if v, has := overlay[path]; has {
+			return v, v >= 0, nil
+		}
+		exists, stat, err := this.conn.Exists(path)
+		if err != nil || !exists {
+			return 0, false, err
+		}
+		return stat.Version, true, nil
+	}
+	matches := func(expected namespace.Version, actual int32) bool {
+		return expected == namespace.InvalidVersion || int32(expected) == actual
+	}
+	for i, op := range ops {
+		path := namespace.PathOf(op).String()
+		v, exists, err := version(path)
+		if err != nil {
+			return i, toNamespaceError(err)
+		}
+		switch op := op.(type) {
+		case namespace.OpCheck:
+			if !exists {
+				return i, namespace.ErrNotExist
+			} else if !matches(op.Version, v) {
+				return i, namespace.ErrBadVersion
+			}
+		case namespace.OpCreate:
+			if op.Sequential {
+				break // The name is not known until the node is created.
+			}
+			if exists {
+				return i, namespace.ErrNodeExists
+			}
+			overlay[path] = 0
+		case namespace.OpSet:
+			if !exists {
+				return i, namespace.ErrNotExist
+			} else if !matches(op.Version, v) {
+				return i, namespace.ErrBadVersion
+			}
+			overlay[path] = v + 1
+		case namespace.OpDelete:
+			if !exists {
+				return i, namespace.ErrNotExist
+			} else if !matches(op.Version, v) {
+				return i, namespace.ErrBadVersion
+			}
+			if _, stat, err := this.conn.Exists(path); err == nil && stat != nil && stat.NumChildren > 0 {
+				return i, namespace.ErrNotEmpty
+			}
+			overlay[path] = -1
+		}
+	}
+	return -1, nil
+}
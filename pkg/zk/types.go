@@ -25,6 +25,9 @@ const (
 	// Default Zk timeout
 	DefaultTimeout = 1 * time.Hour
 
+	// Wait between attempts to set a watch again after it fired.
+	WatchRetryDelay = 1 * time.Second
+
 	// Defaults to localhost at port 2181.
 	DefaultZkHosts = "localhost:2181"
 
@@ -39,6 +42,7 @@ type Node struct {
 	Stats   *zk.Stat
 	Leaf    bool
 	client  *client
+	acl     []zk.ACL // of ephemeral nodes, for recreating them
 }
 
 type Event struct {